@@ -0,0 +1,157 @@
+package afterburner
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// DefaultPath is the standard install location of MSIAfterburner.exe.
+const DefaultPath = `C:\Program Files (x86)\MSI Afterburner\MSIAfterburner.exe`
+
+// Afterburner supports five saved profiles.
+const (
+	MinProfile = 1
+	MaxProfile = 5
+)
+
+// commandTimeout is how long ApplyProfile waits for MSIAfterburner.exe to hand the command to
+// the running instance and exit. If Afterburner was not running, the launched process becomes
+// the resident instance and never exits, so it is left running after this long.
+const commandTimeout = 10 * time.Second
+
+const profileArgPrefix = "-Profile"
+
+// ApplyProfile retries a failed command this many times in total, waiting applyRetryDelay
+// before the second attempt and doubling the wait for each one after, since a busy or
+// still-starting Afterburner usually recovers within a few seconds.
+const (
+	applyAttempts   = 4
+	applyRetryDelay = 500 * time.Millisecond
+)
+
+// Client runs MSIAfterburner.exe to switch profiles.
+type Client struct {
+	Path string
+	// Args are passed to the executable before the profile flag.
+	Args []string
+}
+
+// New returns a Client for the given executable, or DefaultPath if path is empty.
+func New(path string) *Client {
+	if path == "" {
+		path = DefaultPath
+	}
+	return &Client{Path: path}
+}
+
+// ProfileArg returns the command-line flag that selects profile n, e.g. "-Profile3".
+func ProfileArg(n int) string {
+	return profileArgPrefix + strconv.Itoa(n)
+}
+
+// ParseProfile converts a "-ProfileN" flag back into its profile number.
+func ParseProfile(arg string) (int, error) {
+	numStr, ok := strings.CutPrefix(arg, profileArgPrefix)
+	if !ok {
+		return 0, fmt.Errorf("invalid profile format: %q (must start with %q)", arg, profileArgPrefix)
+	}
+	n, err := strconv.Atoi(numStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid profile number: %q", arg)
+	}
+	if err := validate(n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func validate(n int) error {
+	if n < MinProfile || n > MaxProfile {
+		return fmt.Errorf("profile %d is out of the valid range of %d-%d", n, MinProfile, MaxProfile)
+	}
+	return nil
+}
+
+// ApplyProfile switches Afterburner to profile n, waits for the command to finish and checks
+// with CurrentProfile that the profile is now active. A failed or unconfirmed switch is retried
+// with exponential backoff, and the last error is returned once every attempt has failed.
+func (c *Client) ApplyProfile(n int) error {
+	return c.ApplyProfileContext(context.Background(), n)
+}
+
+// ApplyProfileContext is like ApplyProfile but gives up as soon as ctx is cancelled: the
+// MSIAfterburner.exe command still running is killed, no further attempt is made and ctx's
+// error is returned, so a newer switch need not wait for a stale one.
+func (c *Client) ApplyProfileContext(ctx context.Context, n int) error {
+	if err := validate(n); err != nil {
+		return err
+	}
+	delay := applyRetryDelay
+	var err error
+	for attempt := 1; attempt <= applyAttempts; attempt++ {
+		if err = c.applyOnce(ctx, n); err == nil {
+			if err = c.verify(ctx, n); err == nil {
+				return nil
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt < applyAttempts {
+			logging.Warnf("Applying profile %d failed (attempt %d of %d), retrying in %v: %v", n, attempt, applyAttempts, delay, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+	}
+	return fmt.Errorf("profile %d was not applied after %d attempts: %w", n, applyAttempts, err)
+}
+
+// applyOnce runs MSIAfterburner.exe once with the flag for profile n. Cancelling ctx kills the
+// command while it is being waited for, but not once it has been left running as the
+// resident instance: from then on cancelling only stops the retries.
+func (c *Client) applyOnce(ctx context.Context, n int) error {
+	arg := ProfileArg(n)
+	cmd := exec.CommandContext(ctx, c.Path, append(slices.Clone(c.Args), arg)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	var resident atomic.Bool
+	cmd.Cancel = func() error {
+		if resident.Load() {
+			return nil
+		}
+		return cmd.Process.Kill()
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not launch %s %s: %w", c.Path, arg, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s failed: %w", c.Path, arg, err)
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(commandTimeout):
+		resident.Store(true)
+		logging.Infof("MSI Afterburner is still running after %v; assuming it was started by this command.", commandTimeout)
+	}
+	return nil
+}
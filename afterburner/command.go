@@ -0,0 +1,77 @@
+package afterburner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// commandTimeoutLimit bounds how long a custom rule command may run.
+const commandTimeoutLimit = 30 * time.Second
+
+// RunTemplate runs a custom command line after substituting {name} placeholders from values in
+// each argument. The template is split into arguments first (double quotes group words), and is
+// never passed to a shell, so substituted values cannot inject extra commands or arguments.
+// It returns the command's combined stdout and stderr.
+func RunTemplate(template string, values map[string]string) (string, error) {
+	return RunTemplateEnv(template, values, nil)
+}
+
+// RunTemplateEnv is RunTemplate with extra "NAME=value" environment variables added to the
+// command's inherited environment.
+func RunTemplateEnv(template string, values map[string]string, env []string) (string, error) {
+	args := splitArgs(template)
+	if len(args) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+	for i, arg := range args {
+		for name, value := range values {
+			arg = strings.ReplaceAll(arg, "{"+name+"}", value)
+		}
+		args[i] = arg
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeoutLimit)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s failed: %w", args[0], err)
+	}
+	return string(out), nil
+}
+
+// splitArgs splits a command line on spaces, keeping double-quoted sections together.
+func splitArgs(s string) []string {
+	var args []string
+	var cur strings.Builder
+	inQuotes, started := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			started = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if started {
+				args = append(args, cur.String())
+				cur.Reset()
+				started = false
+			}
+		default:
+			cur.WriteRune(r)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, cur.String())
+	}
+	return args
+}
@@ -0,0 +1,128 @@
+package afterburner
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// ErrProfileUnknown is returned by CurrentProfile when the current settings do not match any
+// saved profile, for example after they were changed by hand.
+var ErrProfileUnknown = errors.New("the current settings do not match a saved profile")
+
+// verifyTimeout is how long ApplyProfile waits for Afterburner to record the new settings.
+const verifyTimeout = 3 * time.Second
+
+// CurrentProfile returns the saved profile whose settings Afterburner currently uses.
+// Afterburner records the settings it last applied in the [Startup] section of each GPU's
+// file in its Profiles folder, next to the [Profile1]-[Profile5] sections, so the current
+// profile is the one whose settings equal the startup ones on every GPU.
+func (c *Client) CurrentProfile() (int, error) {
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(c.Path), "Profiles", "VEN_*.cfg"))
+	if err != nil || len(files) == 0 {
+		return 0, fmt.Errorf("no GPU profile files found next to %s", c.Path)
+	}
+	candidates := make(map[int]bool)
+	for n := MinProfile; n <= MaxProfile; n++ {
+		candidates[n] = true
+	}
+	for _, file := range files {
+		sections, err := readINI(file)
+		if err != nil {
+			return 0, err
+		}
+		if len(sections["Startup"]) == 0 {
+			return 0, fmt.Errorf("%s has no current settings to compare with", file)
+		}
+		for n := range candidates {
+			if !sameSettings(sections[fmt.Sprintf("Profile%d", n)], sections["Startup"]) {
+				delete(candidates, n)
+			}
+		}
+	}
+	for n := MinProfile; n <= MaxProfile; n++ {
+		if candidates[n] {
+			return n, nil
+		}
+	}
+	return 0, ErrProfileUnknown
+}
+
+// verify waits for Afterburner to report profile n. It fails only when Afterburner reports a
+// different profile. If the settings match no saved profile a warning is logged, and if they
+// cannot be read at all the switch is assumed to have worked. It stops waiting when ctx is
+// cancelled.
+func (c *Client) verify(ctx context.Context, n int) error {
+	deadline := time.Now().Add(verifyTimeout)
+	for {
+		current, err := c.CurrentProfile()
+		if err == nil && current == n {
+			return nil
+		}
+		if err != nil && !errors.Is(err, ErrProfileUnknown) {
+			logging.Debugf("Cannot confirm that profile %d is active: %v", n, err)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				logging.Warnf("Profile %d was applied, but %v.", n, err)
+				return nil
+			}
+			return fmt.Errorf("profile %d is not active; Afterburner reports profile %d", n, current)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// sameSettings reports whether a saved profile holds the same settings as the current ones.
+// Empty profile slots never match.
+func sameSettings(profile, current map[string]string) bool {
+	if len(profile) == 0 || len(current) == 0 {
+		return false
+	}
+	for key, value := range profile {
+		if current[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// readINI parses the sections of an Afterburner .cfg file into key-value maps.
+func readINI(path string) (map[string]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	sections := make(map[string]map[string]string)
+	var section map[string]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if name, ok := strings.CutPrefix(line, "["); ok && strings.HasSuffix(name, "]") {
+			section = make(map[string]string)
+			sections[strings.TrimSuffix(name, "]")] = section
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && section != nil {
+			section[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	return sections, nil
+}
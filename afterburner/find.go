@@ -0,0 +1,89 @@
+package afterburner
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+const exeName = "MSIAfterburner.exe"
+
+// ErrNotFound is returned by FindAfterburnerExe when no installation could be located.
+var ErrNotFound = errors.New("MSIAfterburner.exe could not be found; please set 'afterburner_path' in config.json to its full path")
+
+// uninstallKeys are the registry locations that list installed programs.
+var uninstallKeys = []string{
+	`SOFTWARE\WOW6432Node\Microsoft\Windows\CurrentVersion\Uninstall`,
+	`SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall`,
+}
+
+// FindAfterburnerExe looks for MSIAfterburner.exe in the registry (App Paths and uninstall
+// entries) and then in the usual Program Files folders, returning the first path that exists.
+func FindAfterburnerExe() (string, error) {
+	for _, candidate := range candidatePaths() {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+func candidatePaths() []string {
+	var paths []string
+	if p, ok := readString(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows\CurrentVersion\App Paths\`+exeName, ""); ok {
+		paths = append(paths, strings.Trim(p, `"`))
+	}
+	for _, key := range uninstallKeys {
+		paths = append(paths, uninstallPaths(key)...)
+	}
+	for _, env := range []string{"ProgramFiles(x86)", "ProgramFiles"} {
+		if dir := os.Getenv(env); dir != "" {
+			paths = append(paths, filepath.Join(dir, "MSI Afterburner", exeName))
+		}
+	}
+	return append(paths, DefaultPath)
+}
+
+// uninstallPaths returns exe locations derived from uninstall entries whose name mentions Afterburner.
+func uninstallPaths(parent string) []string {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, parent, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil
+	}
+	defer k.Close()
+	names, err := k.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, name := range names {
+		sub := parent + `\` + name
+		displayName, _ := readString(registry.LOCAL_MACHINE, sub, "DisplayName")
+		if !strings.Contains(strings.ToLower(displayName), "afterburner") {
+			continue
+		}
+		if dir, ok := readString(registry.LOCAL_MACHINE, sub, "InstallLocation"); ok && dir != "" {
+			paths = append(paths, filepath.Join(strings.Trim(dir, `"`), exeName))
+		}
+		if uninstaller, ok := readString(registry.LOCAL_MACHINE, sub, "UninstallString"); ok && uninstaller != "" {
+			paths = append(paths, filepath.Join(filepath.Dir(strings.Trim(uninstaller, `"`)), exeName))
+		}
+	}
+	return paths
+}
+
+func readString(root registry.Key, path, name string) (string, bool) {
+	k, err := registry.OpenKey(root, path, registry.QUERY_VALUE)
+	if err != nil {
+		return "", false
+	}
+	defer k.Close()
+	v, _, err := k.GetStringValue(name)
+	if err != nil {
+		return "", false
+	}
+	return v, true
+}
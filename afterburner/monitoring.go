@@ -0,0 +1,102 @@
+package afterburner
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Afterburner publishes its hardware monitoring data in a named shared memory block
+// (the "MAHM" layout from its SDK) while it is running.
+const (
+	monitoringMemoryName = "MAHMSharedMemory"
+	mahmSignature        = 0x4D41484D // "MAHM"
+	mahmEntryDataOffset  = 5 * windows.MAX_PATH
+	mahmEntrySrcIDOffset = mahmEntryDataOffset + 5*4
+	mahmHeaderSize       = 32
+	mahmVersionSrcID     = 0x00020000 // entries carry a source ID from version 2.0
+	sourceGPUTemperature = 0x00000000
+)
+
+var (
+	kernel32              = windows.NewLazySystemDLL("kernel32.dll")
+	procOpenFileMappingW  = kernel32.NewProc("OpenFileMappingW")
+	procRtlMoveMemory     = kernel32.NewProc("RtlMoveMemory")
+	errMonitoringNotReady = errors.New("MSI Afterburner's hardware monitoring data is not available; make sure Afterburner is running with monitoring enabled")
+)
+
+// GPUTemperature returns the hottest GPU temperature, in degrees Celsius, from Afterburner's
+// hardware monitoring. It fails if Afterburner is not running or does not report a temperature.
+func GPUTemperature() (float64, error) {
+	name, err := windows.UTF16PtrFromString(monitoringMemoryName)
+	if err != nil {
+		return 0, err
+	}
+	handle, _, _ := procOpenFileMappingW.Call(windows.FILE_MAP_READ, 0, uintptr(unsafe.Pointer(name)))
+	if handle == 0 {
+		return 0, errMonitoringNotReady
+	}
+	defer windows.CloseHandle(windows.Handle(handle))
+	view, err := windows.MapViewOfFile(windows.Handle(handle), windows.FILE_MAP_READ, 0, 0, 0)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read MSI Afterburner's monitoring data: %w", err)
+	}
+	defer windows.UnmapViewOfFile(view)
+
+	header := readView(view, 0, mahmHeaderSize)
+	le := binary.LittleEndian
+	if le.Uint32(header[0:]) != mahmSignature {
+		return 0, errMonitoringNotReady
+	}
+	version, headerSize := le.Uint32(header[4:]), le.Uint32(header[8:])
+	numEntries, entrySize := le.Uint32(header[12:]), le.Uint32(header[16:])
+	if entrySize < mahmEntrySrcIDOffset+4 || numEntries > 1024 {
+		return 0, fmt.Errorf("unexpected MSI Afterburner monitoring data layout (version %#x)", version)
+	}
+	entries := readView(view, uintptr(headerSize), int(numEntries*entrySize))
+	return hottestGPU(entries, int(entrySize), version)
+}
+
+// hottestGPU finds the highest GPU temperature among the monitoring entries.
+func hottestGPU(entries []byte, entrySize int, version uint32) (float64, error) {
+	le := binary.LittleEndian
+	found := false
+	hottest := math.Inf(-1)
+	for offset := 0; offset+entrySize <= len(entries); offset += entrySize {
+		entry := entries[offset : offset+entrySize]
+		var isTemperature bool
+		if version >= mahmVersionSrcID {
+			isTemperature = le.Uint32(entry[mahmEntrySrcIDOffset:]) == sourceGPUTemperature
+		} else {
+			// Older layouts have no source ID, so match the English source name ("GPU1 temperature").
+			name := strings.ToLower(windows.ByteSliceToString(entry[:windows.MAX_PATH]))
+			isTemperature = strings.HasPrefix(name, "gpu") && strings.HasSuffix(name, "temperature")
+		}
+		if !isTemperature {
+			continue
+		}
+		value := float64(math.Float32frombits(le.Uint32(entry[mahmEntryDataOffset:])))
+		// Afterburner reports unavailable readings as FLT_MAX.
+		if value < math.MaxFloat32 && value > hottest {
+			hottest, found = value, true
+		}
+	}
+	if !found {
+		return 0, errors.New("MSI Afterburner is not reporting a GPU temperature; enable it under Settings > Monitoring")
+	}
+	return hottest, nil
+}
+
+// readView copies size bytes at offset from a mapped view into Go memory.
+func readView(view, offset uintptr, size int) []byte {
+	buf := make([]byte, size)
+	if size > 0 {
+		procRtlMoveMemory.Call(uintptr(unsafe.Pointer(&buf[0])), view+offset, uintptr(size))
+	}
+	return buf
+}
@@ -0,0 +1,55 @@
+package afterburner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// DefaultRTSSDir is the standard install folder of RivaTuner Statistics Server, which is
+// installed along with MSI Afterburner.
+const DefaultRTSSDir = `C:\Program Files (x86)\RivaTuner Statistics Server`
+
+// rtssHooksDLL is the library RTSS ships for other programs to edit its profiles.
+const rtssHooksDLL = "RTSSHooks64.dll"
+
+// rtssMu serializes calls into the hooks library, which keeps the loaded profile as global state.
+var rtssMu sync.Mutex
+
+// ApplyRTSSProfile makes the settings of the named RTSS profile active by loading it and saving
+// it as RTSS's global profile through RTSSHooks64.dll, then telling RTSS to reload its profiles.
+// name is a file in the Profiles folder of dir without ".cfg", e.g. "competitive" for
+// Profiles\competitive.cfg. An empty dir means DefaultRTSSDir.
+func ApplyRTSSProfile(dir, name string) error {
+	if dir == "" {
+		dir = DefaultRTSSDir
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Profiles", name+".cfg")); err != nil {
+		return fmt.Errorf("RTSS profile %q was not found: %w", name, err)
+	}
+	hooks := windows.NewLazyDLL(filepath.Join(dir, rtssHooksDLL))
+	load, save, update := hooks.NewProc("LoadProfile"), hooks.NewProc("SaveProfile"), hooks.NewProc("UpdateProfiles")
+	for _, proc := range []*windows.LazyProc{load, save, update} {
+		if err := proc.Find(); err != nil {
+			return fmt.Errorf("cannot use %s: %w", rtssHooksDLL, err)
+		}
+	}
+	profile, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return fmt.Errorf("invalid RTSS profile name %q: %w", name, err)
+	}
+	global, _ := syscall.BytePtrFromString("")
+
+	rtssMu.Lock()
+	defer rtssMu.Unlock()
+	// The functions return nothing, so there is no result to check.
+	load.Call(uintptr(unsafe.Pointer(profile)))
+	save.Call(uintptr(unsafe.Pointer(global)))
+	update.Call()
+	return nil
+}
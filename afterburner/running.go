@@ -0,0 +1,106 @@
+package afterburner
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/mitchellh/go-ps"
+	"golang.org/x/sys/windows"
+)
+
+// readyTimeout is how long EnsureAfterburnerRunning waits for a freshly started Afterburner
+// to create its window before giving up.
+const readyTimeout = 5 * time.Second
+
+var (
+	user32                       = windows.NewLazySystemDLL("user32.dll")
+	procEnumWindows              = user32.NewProc("EnumWindows")
+	procGetWindowThreadProcessId = user32.NewProc("GetWindowThreadProcessId")
+)
+
+// EnsureAfterburnerRunning starts Afterburner hidden if it is not already running and waits
+// until its window exists, so that profile commands sent afterwards are not lost.
+func (c *Client) EnsureAfterburnerRunning() error {
+	if len(afterburnerPIDs()) > 0 {
+		return nil
+	}
+	cmd := exec.Command(c.Path, c.Args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start %s: %w", c.Path, err)
+	}
+	// Afterburner keeps running on its own; release the handle instead of waiting on it.
+	if err := cmd.Process.Release(); err != nil {
+		return fmt.Errorf("could not release %s: %w", c.Path, err)
+	}
+
+	deadline := time.Now().Add(readyTimeout)
+	for time.Now().Before(deadline) {
+		if hasWindow(afterburnerPIDs()) {
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("MSI Afterburner was started but its window did not appear within %v", readyTimeout)
+}
+
+// afterburnerPIDs returns the PIDs of running MSIAfterburner.exe processes.
+func afterburnerPIDs() map[uint32]bool {
+	pids := make(map[uint32]bool)
+	processes, err := ps.Processes()
+	if err != nil {
+		return pids
+	}
+	for _, p := range processes {
+		if strings.EqualFold(p.Executable(), exeName) {
+			pids[uint32(p.Pid())] = true
+		}
+	}
+	return pids
+}
+
+// hasWindow reports whether any top-level window, visible or not, belongs to one of pids.
+func hasWindow(pids map[uint32]bool) bool {
+	if len(pids) == 0 {
+		return false
+	}
+	call := &hasWindowCall{pids: pids}
+	key := nextEnumKey.Add(1)
+	enumCalls.Store(key, call)
+	defer enumCalls.Delete(key)
+	procEnumWindows.Call(hasWindowProc, key)
+	return call.found
+}
+
+// hasWindowCall is the state of one hasWindow enumeration.
+type hasWindowCall struct {
+	pids  map[uint32]bool
+	found bool
+}
+
+// hasWindowProc is the EnumWindows callback for hasWindow, created once because callbacks are
+// never freed. Each call's state is looked up in enumCalls by the key passed as the lparam.
+var (
+	hasWindowProc = syscall.NewCallback(func(hwnd syscall.Handle, key uintptr) uintptr {
+		v, ok := enumCalls.Load(key)
+		if !ok {
+			return 0 // Stop enumeration
+		}
+		call := v.(*hasWindowCall)
+		var pid uint32
+		tid, _, _ := procGetWindowThreadProcessId.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&pid)))
+		if tid != 0 && call.pids[pid] {
+			call.found = true
+			return 0 // Stop enumeration
+		}
+		return 1 // Continue
+	})
+	enumCalls   sync.Map
+	nextEnumKey atomic.Uintptr
+)
@@ -1,23 +1,278 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"maps"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+
+	"MSIAfterburnerScript/logging"
+
+	"golang.org/x/text/cases"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the default config file, relative to the working directory.
+const FileName = "config.json"
+
+// yamlFileNames are used instead of FileName when only one of them exists.
+var yamlFileNames = []string{"config.yaml", "config.yml"}
+
+// Config file formats accepted by LoadFileFormat.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
 )
 
-const configFile = "config.json"
+// regexPrefix marks an override keyword as a regular expression.
+const regexPrefix = "re:"
+
+// excludePrefix marks an override keyword as an exclusion that vetoes all other matches.
+const excludePrefix = "!"
+
+// fieldPrefixes name the field a keyword is matched against; a regular expression can follow them.
+var fieldPrefixes = []string{"class:", "cmdline:", "product:", "aumid:", "audio:", "dir:"}
 
 type Config struct {
-	AfterburnerPath string            `json:"afterburner_path"`
-	ProfileOn       string            `json:"profile_on"`
-	ProfileOff      string            `json:"profile_off"`
-	DelaySeconds    int               `json:"delay_seconds"`
-	MonitoringMode  string            `json:"monitoring_mode"`
-	Overrides       map[string]string `json:"overrides"`
+	AfterburnerPath   string            `json:"afterburner_path"`
+	AfterburnerArgs   []string          `json:"afterburner_args,omitempty"`
+	LaunchAfterburner bool              `json:"launch_afterburner"`
+	ProfileOn         string            `json:"profile_on"`
+	ProfileOff        string            `json:"profile_off"`
+	RTSSPath          string            `json:"rtss_path,omitempty"`
+	RTSSProfileOff    string            `json:"rtss_profile_off,omitempty"`
+	DelaySeconds      int               `json:"delay_seconds"`
+	PollJitterPercent int               `json:"poll_jitter_percent,omitempty"`
+	MonitoringMode    string            `json:"monitoring_mode"`
+	MatchMode         string            `json:"match_mode"`
+	PathMatch         bool              `json:"path_match"`
+	DebounceMs        int               `json:"debounce_ms"`
+	HandlerTimeoutMs  int               `json:"handler_timeout_ms"`
+	FullscreenOnly    bool              `json:"fullscreen_only"`
+	ForegroundOnly    bool              `json:"foreground_only"`
+	Stages            []string          `json:"stages,omitempty"`
+	NormalizeTitles   bool              `json:"normalize_titles"`
+	TitleStrip        []string          `json:"title_strip,omitempty"`
+	DwellMs           int               `json:"dwell_ms"`
+	CooldownMs        int               `json:"cooldown_ms"`
+	RevertGraceMs     int               `json:"revert_grace_ms"`
+	SwitchLimit       int               `json:"switch_limit"`
+	SwitchLimitMs     int               `json:"switch_limit_ms"`
+	WindowCacheMs     int               `json:"window_cache_ms"`
+	ProcessCacheMs    int               `json:"process_cache_ms"`
+	LogLevel          string            `json:"log_level"`
+	LogFile           string            `json:"log_file"`
+	LogMaxSizeMB      int               `json:"log_max_size_mb"`
+	LogMaxFiles       int               `json:"log_max_files"`
+	Notifications     bool              `json:"notifications"`
+	Tray              bool              `json:"tray"`
+	RestoreOnExit     bool              `json:"restore_on_exit"`
+	PauseHotkey       string            `json:"pause_hotkey"`
+	PresetHotkey      string            `json:"preset_hotkey,omitempty"`
+	DryRun            bool              `json:"dry_run"`
+	LogUnmatched      bool              `json:"log_unmatched"`
+	LearnFile         string            `json:"learn_file"`
+	StatusAddr        string            `json:"status_addr"`
+	MetricsAddr       string            `json:"metrics_addr"`
+	HistorySize       int               `json:"history_size"`
+	Hooks             []string          `json:"hooks,omitempty"`
+	Overrides         map[string]string `json:"overrides"`
+	Priority          []string          `json:"priority"`
+	Rules             []Rule            `json:"rules,omitempty"`
+	Preset            string            `json:"preset,omitempty"`
+	Presets           map[string]Preset `json:"presets,omitempty"`
+	TemperatureRules  []TemperatureRule `json:"temperature_rules,omitempty"`
+	Schedules         []Schedule        `json:"schedules,omitempty"`
+	PowerRules        []PowerRule       `json:"power_rules,omitempty"`
+	DisplayRules      []DisplayRule     `json:"display_rules,omitempty"`
+}
+
+// Preset is a named rule set that replaces the top-level rules, overrides and priority
+// while it is active.
+type Preset struct {
+	Rules     []Rule            `json:"rules,omitempty"`
+	Overrides map[string]string `json:"overrides,omitempty"`
+	Priority  []string          `json:"priority,omitempty"`
+}
+
+// WithPreset returns the config with the rule set of the named preset in place of the
+// top-level one. An empty name returns the top-level rule set.
+func (cfg Config) WithPreset(name string) (Config, bool) {
+	if name == "" {
+		cfg.Preset = ""
+		return cfg, true
+	}
+	preset, ok := cfg.Presets[name]
+	if !ok {
+		return cfg, false
+	}
+	cfg.Rules, cfg.Overrides, cfg.Priority, cfg.Preset = preset.Rules, preset.Overrides, preset.Priority, name
+	return cfg, true
+}
+
+// PresetNames returns the names of the presets in alphabetical order.
+func (cfg Config) PresetNames() []string {
+	return slices.Sorted(maps.Keys(cfg.Presets))
+}
+
+// Rule is a structured target. Rules are checked in the order they are listed,
+// before the targets in Overrides.
+type Rule struct {
+	// Name is shown in the log, the tray and the status instead of the keywords when set.
+	Name    string `json:"name,omitempty"`
+	Keyword string `json:"keyword,omitempty"`
+	// Keywords lists more keywords that select the same profile, e.g. a group of games.
+	Keywords []string `json:"keywords,omitempty"`
+	// MatchMode overrides the global match_mode for this rule when set.
+	MatchMode string `json:"match_mode,omitempty"`
+	Profile   string `json:"profile"`
+	// Exclude lists keywords that stop this rule from matching while they are present.
+	Exclude []string `json:"exclude,omitempty"`
+	// DwellMs overrides the global dwell_ms for this rule when set.
+	DwellMs *int `json:"dwell_ms,omitempty"`
+	// CooldownMs overrides the global cooldown_ms for this rule when set.
+	CooldownMs *int `json:"cooldown_ms,omitempty"`
+	// RTSSProfile is a RivaTuner Statistics Server profile to switch to along with Profile.
+	RTSSProfile string `json:"rtss_profile,omitempty"`
+	// AfterburnerPath and AfterburnerArgs run a different MSIAfterburner.exe, with extra
+	// arguments, for this rule, e.g. a second install that controls another GPU.
+	AfterburnerPath string   `json:"afterburner_path,omitempty"`
+	AfterburnerArgs []string `json:"afterburner_args,omitempty"`
+	// Command replaces the Afterburner invocation with a custom command line.
+	// {profile} and {keyword} are substituted; it is not run through a shell.
+	Command string `json:"command,omitempty"`
+	// Scope limits where the keywords match: "title", "exe" and "foreground" can be combined.
+	// Empty matches everywhere.
+	Scope []string `json:"scope,omitempty"`
+	// FullscreenOnly matches the rule only while the foreground window is borderless or
+	// exclusive fullscreen, not windowed.
+	FullscreenOnly bool `json:"fullscreen_only,omitempty"`
+	// RequireForeground matches the rule only in the foreground window, whatever the stages;
+	// it is the same as adding "foreground" to Scope.
+	RequireForeground bool `json:"require_foreground,omitempty"`
+	// IncludeChildren also matches a foreground process started by a process matching the
+	// keyword, e.g. a game started by its launcher.
+	IncludeChildren bool `json:"include_children,omitempty"`
+	// Enabled can be set to false to keep a rule in the file without matching it.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Notes is free text for whoever edits the file; it is not used.
+	Notes string `json:"notes,omitempty"`
+}
+
+// IsEnabled reports whether the rule takes part in matching. Rules are enabled unless
+// "enabled" is set to false.
+func (r Rule) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// AllKeywords returns Keyword, if set, followed by Keywords.
+func (r Rule) AllKeywords() []string {
+	if r.Keyword == "" {
+		return r.Keywords
+	}
+	return append([]string{r.Keyword}, r.Keywords...)
+}
+
+// OverridesAsRules converts Overrides into rules in the order they are matched in: the
+// Priority keys first, then the rest alphabetically. Targets without a profile get ProfileOn.
+// Exclusions cannot be written as rules, so they are returned as the overrides to keep.
+func (cfg Config) OverridesAsRules() (rules []Rule, kept map[string]string) {
+	keywords := slices.DeleteFunc(slices.Clone(cfg.Priority), func(k string) bool {
+		_, ok := cfg.Overrides[k]
+		return !ok
+	})
+	keywords = slices.Compact(keywords)
+	var rest []string
+	for k := range cfg.Overrides {
+		if !slices.Contains(keywords, k) {
+			rest = append(rest, k)
+		}
+	}
+	slices.Sort(rest)
+	kept = make(map[string]string)
+	for _, k := range append(keywords, rest...) {
+		if strings.HasPrefix(k, excludePrefix) {
+			kept[k] = cfg.Overrides[k]
+			continue
+		}
+		profile := cfg.Overrides[k]
+		if profile == "" {
+			profile = cfg.ProfileOn
+		}
+		rules = append(rules, Rule{Keyword: k, Profile: profile})
+	}
+	return rules, kept
+}
+
+// TemperatureRule forces a profile while the GPU is hot, whatever target is active.
+type TemperatureRule struct {
+	AboveC float64 `json:"above_c"`
+	// ClearBelowC is the temperature the GPU must drop below before the rule stops
+	// applying. It defaults to 5 degrees below AboveC so the profile does not flap.
+	ClearBelowC *float64 `json:"clear_below_c,omitempty"`
+	Profile     string   `json:"profile"`
+}
+
+// DefaultTemperatureHysteresis is how far below above_c a temperature rule clears
+// when clear_below_c is not set.
+const DefaultTemperatureHysteresis = 5.0
+
+// ClearBelow returns the temperature below which the rule stops applying.
+func (r TemperatureRule) ClearBelow() float64 {
+	if r.ClearBelowC != nil {
+		return *r.ClearBelowC
+	}
+	return r.AboveC - DefaultTemperatureHysteresis
+}
+
+// Schedule forces a profile during a daily time window. Start and End are "HH:MM" in local
+// time; a window whose end is earlier than its start runs past midnight.
+type Schedule struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Profile string `json:"profile"`
+}
+
+// Minutes returns the window's start and end as minutes after midnight.
+// The schedule must have passed validation.
+func (s Schedule) Minutes() (start, end int) {
+	start, _ = parseClock(s.Start)
+	end, _ = parseClock(s.End)
+	return start, end
+}
+
+// PowerRule selects a profile by power source. Power is "ac" or "battery". Unless
+// OverrideTargets is set, the rule only replaces profile_off while no target is active.
+type PowerRule struct {
+	Power           string `json:"power"`
+	Profile         string `json:"profile"`
+	OverrideTargets bool   `json:"override_targets"`
+}
+
+// DisplayRule selects a profile by whether an external monitor is connected, for laptops that
+// are used both docked and on their own panel. OverrideTargets works as in PowerRule.
+type DisplayRule struct {
+	External        bool   `json:"external"`
+	Profile         string `json:"profile"`
+	OverrideTargets bool   `json:"override_targets"`
+}
+
+// parseClock parses "HH:MM" (24-hour) into minutes after midnight.
+func parseClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if !ok || errH != nil || errM != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time %q (must be \"HH:MM\" from 00:00 to 23:59)", s)
+	}
+	return h*60 + m, nil
 }
 
 func defaultConfig() Config {
@@ -27,6 +282,13 @@ func defaultConfig() Config {
 		ProfileOff:      "-Profile1",
 		DelaySeconds:    15,
 		MonitoringMode:  "event",
+		MatchMode:       "contains",
+		LogLevel:        "info",
+		LogMaxSizeMB:    5,
+		LogMaxFiles:     3,
+		Notifications:   true,
+		Tray:            true,
+		RestoreOnExit:   true,
 		Overrides:       make(map[string]string),
 	}
 }
@@ -52,61 +314,589 @@ func validateProfileString(profile string) error {
 	return nil
 }
 
-func Load() Config {
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		log.Printf("Configuration file not found. Creating %s with default values.", configFile)
+func validMatchMode(mode string) bool {
+	switch strings.ToLower(mode) {
+	case "", "contains", "exact", "word":
+		return true
+	}
+	return false
+}
+
+// validStages holds the detection stage names accepted in 'stages'.
+var validStages = []string{"foreground", "fullscreen", "process", "window", "audio"}
+
+// validateRTSSProfile checks that an RTSS profile name is a plain file name in RTSS's Profiles folder.
+func validateRTSSProfile(name string) error {
+	if strings.ContainsAny(name, `\/:`) {
+		return fmt.Errorf("must be the name of a profile in RTSS's Profiles folder without \".cfg\", not a path, but found %q", name)
+	}
+	return nil
+}
+
+// validScopes holds the values accepted in a rule's 'scope'.
+var validScopes = []string{"title", "exe", "foreground"}
+
+// keywordPattern returns the regular expression in a keyword, after any exclusion and
+// field prefix, and whether there is one.
+func keywordPattern(keyword string) (string, bool) {
+	keyword = strings.TrimPrefix(keyword, excludePrefix)
+	for _, prefix := range fieldPrefixes {
+		if rest, ok := cutPrefixFold(keyword, prefix); ok {
+			keyword = rest
+			break
+		}
+	}
+	return strings.CutPrefix(keyword, regexPrefix)
+}
+
+// cutPrefixFold is strings.CutPrefix ignoring ASCII case.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+// normalizeKeyword case-folds a keyword the same way the watcher folds names and titles,
+// unless it is a regular expression, whose case is kept so escapes like \D are not altered;
+// the watcher folds a pattern's literal text itself. Prefixes before the pattern are still folded.
+func normalizeKeyword(keyword string) string {
+	if pattern, ok := keywordPattern(keyword); ok {
+		return cases.Fold().String(keyword[:len(keyword)-len(pattern)]) + pattern
+	}
+	return cases.Fold().String(keyword)
+}
+
+// validateKeyword checks that a "re:" keyword compiles.
+func validateKeyword(keyword string) error {
+	if pattern, ok := keywordPattern(keyword); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid regular expression %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// lineOf returns the 1-based line number of a byte offset in data.
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Path returns the default config file: config.json, or config.yaml or config.yml if
+// config.json does not exist but one of them does.
+func Path() string {
+	if _, err := os.Stat(FileName); err == nil {
+		return FileName
+	}
+	for _, name := range yamlFileNames {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return FileName
+}
+
+// Load reads and validates the config file at path, as YAML for .yaml and .yml files and
+// as JSON otherwise, creating it with default values if it does not exist. Errors name the
+// rule or setting at fault, and the line for syntax and type errors.
+func Load(path string) (*Config, error) {
+	cfg, err := LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// FormatOf returns the format of a config file from its extension: FormatYAML for .yaml and
+// .yml files and FormatJSON otherwise.
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+	return FormatJSON
+}
+
+// LoadFile reads and validates the config file at path in the format given by its extension.
+// It is LoadFileFormat with the format from FormatOf.
+func LoadFile(path string) (Config, error) {
+	return LoadFileFormat(path, FormatOf(path))
+}
+
+// LoadFileFormat reads and validates the config file at path, creating it with default values
+// if it does not exist. JSON and YAML files use the same field names and are validated the same
+// way. Errors describe the offending setting (and line, for syntax errors).
+func LoadFileFormat(path, format string) (Config, error) {
+	if format != FormatJSON && format != FormatYAML {
+		return Config{}, fmt.Errorf("Unknown config format %q for %s. Use %q or %q.", format, path, FormatJSON, FormatYAML)
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		logging.Infof("Configuration file not found. Creating %s with default values.", path)
 		cfg := defaultConfig()
-		file, err := os.Create(configFile)
+		data, err := encode(cfg, format)
 		if err != nil {
-			log.Fatalf("Fatal: Could not create config file %s: %v", configFile, err)
+			return Config{}, fmt.Errorf("Could not write to config file %s: %v", path, err)
 		}
-		defer func(file *os.File) {
-			err := file.Close()
-			if err != nil {
-				log.Printf("Warning: Cannot close %s: %v", configFile, err)
-			}
-		}(file)
-		encoder := json.NewEncoder(file)
-		encoder.SetIndent("", "    ")
-		if err := encoder.Encode(cfg); err != nil {
-			log.Fatalf("Fatal: Could not write to config file %s: %v", configFile, err)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return Config{}, fmt.Errorf("Could not create config file %s: %v", path, err)
 		}
-		return cfg
+		return cfg, nil
 	}
 
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("Cannot open config file %s: %v", path, err)
+	}
+	if format == FormatYAML {
+		return loadYAML(path, data)
+	}
 	var cfg Config
-	file, err := os.Open(configFile)
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return Config{}, fmt.Errorf("Could not parse config file %s (line %d). Please check for JSON syntax errors like a missing comma or quote. Details: %v", path, lineOf(data, syntaxErr.Offset), err)
+		case errors.As(err, &typeErr):
+			return Config{}, fmt.Errorf("Could not parse config file %s (line %d). The value of %q has the wrong type. Details: %v", path, lineOf(data, typeErr.Offset), typeErr.Field, err)
+		}
+		return Config{}, fmt.Errorf("Could not parse config file %s. Please check for JSON syntax errors like a missing comma or quote. Details: %v", path, err)
+	}
+
+	if err := cfg.validate(path); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadYAML parses a YAML config by converting it to JSON first, so both formats share the
+// field names and types of the JSON tags.
+func loadYAML(path string, data []byte) (Config, error) {
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Config{}, fmt.Errorf("Could not parse config file %s. Please check for YAML syntax errors like wrong indentation. Details: %v", path, err)
+	}
+	if doc == nil {
+		doc = map[string]any{}
+	}
+	converted, err := json.Marshal(doc)
 	if err != nil {
-		log.Fatalf("Fatal: Cannot open config file %s: %v", configFile, err)
+		return Config{}, fmt.Errorf("Could not parse config file %s. Settings must be named by text keys. Details: %v", path, err)
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			log.Printf("Warning: Cannot close %s: %v", configFile, err)
+	var cfg Config
+	if err := json.Unmarshal(converted, &cfg); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			var root yaml.Node
+			yaml.Unmarshal(data, &root)
+			kind, _, _ := strings.Cut(typeErr.Value, " ")
+			if line := yamlLine(&root, strings.Split(typeErr.Field, "."), kind); line > 0 {
+				return Config{}, fmt.Errorf("Could not parse config file %s (line %d). The value of %q has the wrong type. Details: %v", path, line, typeErr.Field, err)
+			}
+			return Config{}, fmt.Errorf("Could not parse config file %s. The value of %q has the wrong type. Details: %v", path, typeErr.Field, err)
 		}
-	}(file)
+		return Config{}, fmt.Errorf("Could not parse config file %s. Details: %v", path, err)
+	}
+	if err := cfg.validate(path); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
 
-	if err := json.NewDecoder(file).Decode(&cfg); err != nil {
-		log.Fatalf("Fatal: Could not parse config file %s. Please check for JSON syntax errors like a missing comma or quote. Details: %v", configFile, err)
+// yamlLine returns the line of the first value in a YAML document at the JSON field path that
+// has the JSON kind ("string", "number", "bool", "array" or "object") an UnmarshalTypeError
+// reports, or 0 if there is none. Older Go versions leave list indexes out of the path, so
+// without one every list item is searched.
+func yamlLine(node *yaml.Node, path []string, kind string) int {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if line := yamlLine(child, path, kind); line > 0 {
+				return line
+			}
+		}
+	case yaml.AliasNode:
+		return yamlLine(node.Alias, path, kind)
+	case yaml.SequenceNode:
+		if len(path) == 0 && kind == "array" {
+			return node.Line
+		}
+		if len(path) > 0 {
+			if i, err := strconv.Atoi(path[0]); err == nil {
+				if i < 0 || i >= len(node.Content) {
+					return 0
+				}
+				return yamlLine(node.Content[i], path[1:], kind)
+			}
+		}
+		for _, child := range node.Content {
+			if line := yamlLine(child, path, kind); line > 0 {
+				return line
+			}
+		}
+	case yaml.MappingNode:
+		if len(path) == 0 {
+			if kind == "object" {
+				return node.Line
+			}
+			return 0
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == path[0] {
+				if line := yamlLine(node.Content[i+1], path[1:], kind); line > 0 {
+					return line
+				}
+			}
+		}
+	case yaml.ScalarNode:
+		if len(path) == 0 && yamlScalarKind(node) == kind {
+			return node.Line
+		}
 	}
+	return 0
+}
 
+// yamlScalarKind returns the JSON kind a YAML scalar is converted to.
+func yamlScalarKind(node *yaml.Node) string {
+	switch node.ShortTag() {
+	case "!!int", "!!float":
+		return "number"
+	case "!!bool":
+		return "bool"
+	case "!!null":
+		return "null"
+	}
+	return "string"
+}
+
+// encode writes cfg in the given format. YAML is produced from the JSON encoding so it uses
+// the same field names.
+func encode(cfg Config, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil || format == FormatJSON {
+		return append(data, '\n'), err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// envVar matches a %NAME% environment variable reference.
+var envVar = regexp.MustCompile(`%[^%]+%`)
+
+// expandEnv replaces %NAME% references in the path setting field with the variables' values,
+// as cmd.exe does. References to unset variables are left as they are, with a warning.
+func expandEnv(field, value string) string {
+	return envVar.ReplaceAllStringFunc(value, func(ref string) string {
+		if v, ok := os.LookupEnv(ref[1 : len(ref)-1]); ok {
+			return v
+		}
+		logging.Warnf("Environment variable %s in '%s' is not set, so it is left as it is.", ref, field)
+		return ref
+	})
+}
+
+// validate checks every setting, expands environment variables in paths and normalizes
+// keywords in place.
+func (cfg *Config) validate(path string) error {
+	cfg.AfterburnerPath = expandEnv("afterburner_path", cfg.AfterburnerPath)
+	cfg.LogFile = expandEnv("log_file", cfg.LogFile)
+	cfg.LearnFile = expandEnv("learn_file", cfg.LearnFile)
+	cfg.RTSSPath = expandEnv("rtss_path", cfg.RTSSPath)
+	if err := validateRTSSProfile(cfg.RTSSProfileOff); err != nil {
+		return fmt.Errorf("Configuration error in 'rtss_profile_off': %v. Please correct the value in %s.", err, path)
+	}
 	if err := validateProfileString(cfg.ProfileOn); err != nil || cfg.ProfileOn == "" {
-		log.Fatalf("Configuration error in 'profile_on'. A valid profile must be like \"-ProfileN\" where N is a number from 1 to 5. Details: %v", err)
+		return fmt.Errorf("Configuration error in 'profile_on'. A valid profile must be like \"-ProfileN\" where N is a number from 1 to 5. Details: %v", err)
 	}
-	if err := validateProfileString(cfg.ProfileOff); err != nil || cfg.ProfileOff == "" {
-		log.Fatalf("Configuration error in 'profile_off'. A valid profile must be like \"-ProfileN\" where N is a number from 1 to 5. Details: %v", err)
+	if err := validateProfileString(cfg.ProfileOff); err != nil {
+		return fmt.Errorf("Configuration error in 'profile_off'. A valid profile must be like \"-ProfileN\" where N is a number from 1 to 5, or an empty string \"\" to keep the last profile. Details: %v", err)
 	}
 	mode := strings.ToLower(cfg.MonitoringMode)
-	if mode != "poll" && mode != "event" {
-		log.Fatalf("Configuration error: 'monitoring_mode' must be either \"poll\" or \"event\", but found %q. Please correct the value in %s.", cfg.MonitoringMode, configFile)
+	if mode != "poll" && mode != "event" && mode != "hybrid" && mode != "timer" {
+		return fmt.Errorf("Configuration error: 'monitoring_mode' must be \"poll\", \"event\", \"hybrid\" or \"timer\", but found %q. Please correct the value in %s.", cfg.MonitoringMode, path)
+	}
+	if cfg.DebounceMs < 0 {
+		return fmt.Errorf("Configuration error: 'debounce_ms' cannot be negative, but found %d. Please correct the value in %s.", cfg.DebounceMs, path)
+	}
+	if cfg.ProcessCacheMs < -1 {
+		return fmt.Errorf("Configuration error: 'process_cache_ms' must be 0 or more, or -1 to disable caching, but found %d. Please correct the value in %s.", cfg.ProcessCacheMs, path)
+	}
+	if cfg.PollJitterPercent < 0 || cfg.PollJitterPercent > 50 {
+		return fmt.Errorf("Configuration error: 'poll_jitter_percent' must be from 0 to 50, but found %d. Please correct the value in %s.", cfg.PollJitterPercent, path)
+	}
+	if cfg.WindowCacheMs < 0 {
+		return fmt.Errorf("Configuration error: 'window_cache_ms' cannot be negative, but found %d. Please correct the value in %s.", cfg.WindowCacheMs, path)
+	}
+	if cfg.HandlerTimeoutMs < 0 {
+		return fmt.Errorf("Configuration error: 'handler_timeout_ms' cannot be negative, but found %d. Please correct the value in %s.", cfg.HandlerTimeoutMs, path)
+	}
+	if cfg.DwellMs < 0 {
+		return fmt.Errorf("Configuration error: 'dwell_ms' cannot be negative, but found %d. Please correct the value in %s.", cfg.DwellMs, path)
+	}
+	if cfg.CooldownMs < 0 {
+		return fmt.Errorf("Configuration error: 'cooldown_ms' cannot be negative, but found %d. Please correct the value in %s.", cfg.CooldownMs, path)
+	}
+	if cfg.RevertGraceMs < 0 {
+		return fmt.Errorf("Configuration error: 'revert_grace_ms' cannot be negative, but found %d. Please correct the value in %s.", cfg.RevertGraceMs, path)
+	}
+	if cfg.SwitchLimit < 0 {
+		return fmt.Errorf("Configuration error: 'switch_limit' cannot be negative, but found %d. Please correct the value in %s.", cfg.SwitchLimit, path)
+	}
+	if cfg.SwitchLimitMs < 0 {
+		return fmt.Errorf("Configuration error: 'switch_limit_ms' cannot be negative, but found %d. Please correct the value in %s.", cfg.SwitchLimitMs, path)
+	}
+	if cfg.SwitchLimit > 0 && cfg.SwitchLimitMs == 0 {
+		return fmt.Errorf("Configuration error: 'switch_limit' is set, so 'switch_limit_ms' must be the length of the period in milliseconds. Please correct the value in %s.", path)
 	}
+	if _, ok := logging.ParseLevel(cfg.LogLevel); !ok {
+		return fmt.Errorf("Configuration error: 'log_level' must be \"debug\", \"info\", \"warn\" or \"error\", but found %q. Please correct the value in %s.", cfg.LogLevel, path)
+	}
+	for i, strip := range cfg.TitleStrip {
+		if err := validateKeyword(strip); err != nil {
+			return fmt.Errorf("Configuration error in 'title_strip', entry %d. Details: %v", i+1, err)
+		}
+		cfg.TitleStrip[i] = normalizeKeyword(strip)
+	}
+	for i, hook := range cfg.Hooks {
+		if strings.TrimSpace(hook) == "" {
+			return fmt.Errorf("Configuration error in 'hooks': hook %d is empty. Please correct the value in %s.", i+1, path)
+		}
+	}
+	if cfg.MetricsAddr != "" && cfg.MetricsAddr == cfg.StatusAddr {
+		return fmt.Errorf("Configuration error: 'metrics_addr' and 'status_addr' must be different addresses, but both are %q. Please correct the value in %s.", cfg.MetricsAddr, path)
+	}
+	if cfg.HistorySize < 0 {
+		return fmt.Errorf("Configuration error: 'history_size' cannot be negative, but found %d. Please correct the value in %s.", cfg.HistorySize, path)
+	}
+	if cfg.LogMaxSizeMB < 0 {
+		return fmt.Errorf("Configuration error: 'log_max_size_mb' cannot be negative, but found %d. Please correct the value in %s.", cfg.LogMaxSizeMB, path)
+	}
+	if cfg.LogMaxFiles < 0 {
+		return fmt.Errorf("Configuration error: 'log_max_files' cannot be negative, but found %d. Please correct the value in %s.", cfg.LogMaxFiles, path)
+	}
+	if !validMatchMode(cfg.MatchMode) {
+		return fmt.Errorf("Configuration error: 'match_mode' must be \"contains\", \"exact\" or \"word\", but found %q. Please correct the value in %s.", cfg.MatchMode, path)
+	}
+	for i, stage := range cfg.Stages {
+		stage = strings.ToLower(stage)
+		if !slices.Contains(validStages, stage) {
+			return fmt.Errorf("Configuration error in 'stages': entry %d must be \"foreground\", \"fullscreen\", \"process\", \"window\" or \"audio\", but found %q. Please correct the value in %s.", i+1, cfg.Stages[i], path)
+		}
+		if slices.Contains(cfg.Stages[:i], stage) {
+			return fmt.Errorf("Configuration error in 'stages': %q is listed more than once. Please correct the value in %s.", stage, path)
+		}
+		cfg.Stages[i] = stage
+	}
+
+	if err := cfg.validateTargets(path); err != nil {
+		return err
+	}
+	if cfg.Preset != "" {
+		if _, ok := cfg.Presets[cfg.Preset]; !ok {
+			return fmt.Errorf("Configuration error: 'preset' is %q, but there is no preset with that name in 'presets'. Please correct the value in %s.", cfg.Preset, path)
+		}
+	}
+	for name, preset := range cfg.Presets {
+		if name == "" {
+			return fmt.Errorf("Configuration error in 'presets': a preset needs a name. Please correct the value in %s.", path)
+		}
+		sub := *cfg
+		sub.Rules, sub.Overrides, sub.Priority = preset.Rules, preset.Overrides, preset.Priority
+		if err := sub.validateTargets(path); err != nil {
+			return fmt.Errorf("In preset %q: %v", name, err)
+		}
+		cfg.Presets[name] = Preset{Rules: sub.Rules, Overrides: sub.Overrides, Priority: sub.Priority}
+	}
+
+	for i, rule := range cfg.TemperatureRules {
+		where := fmt.Sprintf("temperature rule %d", i+1)
+		if rule.AboveC <= 0 {
+			return fmt.Errorf("Configuration error in 'temperature_rules', %s: 'above_c' must be a temperature in degrees Celsius greater than 0, but found %g.", where, rule.AboveC)
+		}
+		if rule.ClearBelowC != nil && *rule.ClearBelowC > rule.AboveC {
+			return fmt.Errorf("Configuration error in 'temperature_rules', %s: 'clear_below_c' (%g) cannot be higher than 'above_c' (%g).", where, *rule.ClearBelowC, rule.AboveC)
+		}
+		if err := validateProfileString(rule.Profile); err != nil || rule.Profile == "" {
+			return fmt.Errorf("Configuration error in 'temperature_rules', %s. The profile must be like \"-ProfileN\" where N is a number from 1 to 5. Details: %v", where, err)
+		}
+	}
+
+	for i := range cfg.PowerRules {
+		rule := &cfg.PowerRules[i]
+		where := fmt.Sprintf("power rule %d", i+1)
+		rule.Power = strings.ToLower(rule.Power)
+		if rule.Power != "ac" && rule.Power != "battery" {
+			return fmt.Errorf("Configuration error in 'power_rules', %s: 'power' must be \"ac\" or \"battery\", but found %q.", where, rule.Power)
+		}
+		if err := validateProfileString(rule.Profile); err != nil || rule.Profile == "" {
+			return fmt.Errorf("Configuration error in 'power_rules', %s. The profile must be like \"-ProfileN\" where N is a number from 1 to 5. Details: %v", where, err)
+		}
+	}
+
+	for i, rule := range cfg.DisplayRules {
+		if err := validateProfileString(rule.Profile); err != nil || rule.Profile == "" {
+			return fmt.Errorf("Configuration error in 'display_rules', display rule %d. The profile must be like \"-ProfileN\" where N is a number from 1 to 5. Details: %v", i+1, err)
+		}
+	}
+
+	for i, schedule := range cfg.Schedules {
+		where := fmt.Sprintf("schedule %d", i+1)
+		start, err := parseClock(schedule.Start)
+		if err != nil {
+			return fmt.Errorf("Configuration error in 'schedules', %s: 'start' is not valid. Details: %v", where, err)
+		}
+		end, err := parseClock(schedule.End)
+		if err != nil {
+			return fmt.Errorf("Configuration error in 'schedules', %s: 'end' is not valid. Details: %v", where, err)
+		}
+		if start == end {
+			return fmt.Errorf("Configuration error in 'schedules', %s: 'start' and 'end' are both %s; the window must not be empty.", where, schedule.Start)
+		}
+		if err := validateProfileString(schedule.Profile); err != nil || schedule.Profile == "" {
+			return fmt.Errorf("Configuration error in 'schedules', %s. The profile must be like \"-ProfileN\" where N is a number from 1 to 5. Details: %v", where, err)
+		}
+	}
+	return nil
+}
+
+// validateTargets checks and normalizes the rules, overrides and priority, which make up a
+// rule set: the top-level one or a preset's.
+func (cfg *Config) validateTargets(path string) error {
+	overrides := make(map[string]string, len(cfg.Overrides))
 	for target, profile := range cfg.Overrides {
-		delete(cfg.Overrides, target)
-		cfg.Overrides[strings.ToLower(target)] = profile
+		if err := validateKeyword(target); err != nil {
+			return fmt.Errorf("Configuration error in 'overrides' for target %q. Details: %v", target, err)
+		}
 		if err := validateProfileString(profile); err != nil {
-			log.Fatalf("Configuration error in 'overrides' for target %q. The profile must be like \"-ProfileN\" (where N is 1-5) or an empty string \"\" to use the default 'On' profile. Details: %v", target, err)
+			return fmt.Errorf("Configuration error in 'overrides' for target %q. The profile must be like \"-ProfileN\" (where N is 1-5) or an empty string \"\" to use the default 'On' profile. Details: %v", target, err)
+		}
+		overrides[normalizeKeyword(target)] = profile
+	}
+	cfg.Overrides = overrides
+
+	for i, target := range cfg.Priority {
+		target = normalizeKeyword(target)
+		cfg.Priority[i] = target
+		if _, ok := cfg.Overrides[target]; !ok {
+			return fmt.Errorf("Configuration error in 'priority': %q is not one of the keys in 'overrides'. Please correct the value in %s.", target, path)
+		}
+	}
+
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		where := fmt.Sprintf("rule %d", i+1)
+		keywords := rule.AllKeywords()
+		if rule.Name != "" {
+			where = fmt.Sprintf("rule %d (%q)", i+1, rule.Name)
+		} else if len(keywords) > 0 {
+			where = fmt.Sprintf("rule %d (keyword %q)", i+1, keywords[0])
+		}
+		if len(keywords) == 0 {
+			return fmt.Errorf("Configuration error in 'rules', %s: 'keyword' or 'keywords' is required.", where)
+		}
+		for _, keyword := range keywords {
+			if keyword == "" {
+				return fmt.Errorf("Configuration error in 'rules', %s: 'keywords' cannot contain an empty string.", where)
+			}
+			if err := validateKeyword(keyword); err != nil {
+				return fmt.Errorf("Configuration error in 'rules', %s, keyword %q. Details: %v", where, keyword, err)
+			}
+		}
+		if rule.Profile == "" {
+			return fmt.Errorf("Configuration error in 'rules', %s: 'profile' is required.", where)
+		}
+		if err := validateProfileString(rule.Profile); err != nil {
+			return fmt.Errorf("Configuration error in 'rules', %s. The profile must be like \"-ProfileN\" where N is a number from 1 to 5. Details: %v", where, err)
+		}
+		if err := validateRTSSProfile(rule.RTSSProfile); err != nil {
+			return fmt.Errorf("Configuration error in 'rules', %s: 'rtss_profile' %v.", where, err)
+		}
+		if rule.AfterburnerPath != "" {
+			rule.AfterburnerPath = expandEnv("afterburner_path", rule.AfterburnerPath)
+			if _, err := os.Stat(rule.AfterburnerPath); err != nil {
+				return fmt.Errorf("Configuration error in 'rules', %s: 'afterburner_path' %q cannot be used. Details: %v", where, rule.AfterburnerPath, err)
+			}
+		}
+		if !validMatchMode(rule.MatchMode) {
+			return fmt.Errorf("Configuration error in 'rules', %s: 'match_mode' must be \"contains\", \"exact\" or \"word\", but found %q.", where, rule.MatchMode)
+		}
+		if rule.DwellMs != nil && *rule.DwellMs < 0 {
+			return fmt.Errorf("Configuration error in 'rules', %s: 'dwell_ms' cannot be negative, but found %d.", where, *rule.DwellMs)
+		}
+		if rule.CooldownMs != nil && *rule.CooldownMs < 0 {
+			return fmt.Errorf("Configuration error in 'rules', %s: 'cooldown_ms' cannot be negative, but found %d.", where, *rule.CooldownMs)
+		}
+		for j, scope := range rule.Scope {
+			scope = strings.ToLower(scope)
+			if !slices.Contains(validScopes, scope) {
+				return fmt.Errorf("Configuration error in 'rules', %s: 'scope' entries must be \"title\", \"exe\" or \"foreground\", but found %q.", where, rule.Scope[j])
+			}
+			rule.Scope[j] = scope
+		}
+		if rule.RequireForeground && !slices.Contains(rule.Scope, "foreground") {
+			rule.Scope = append(slices.Clone(rule.Scope), "foreground")
+		}
+		if slices.Contains(rule.Scope, "title") && slices.Contains(rule.Scope, "exe") {
+			return fmt.Errorf("Configuration error in 'rules', %s: 'scope' cannot contain both \"title\" and \"exe\"; leave both out to match either.", where)
+		}
+		if rule.Keyword != "" {
+			rule.Keyword = normalizeKeyword(rule.Keyword)
+		}
+		for j, keyword := range rule.Keywords {
+			rule.Keywords[j] = normalizeKeyword(keyword)
+		}
+		for j, exclude := range rule.Exclude {
+			if err := validateKeyword(exclude); err != nil {
+				return fmt.Errorf("Configuration error in 'rules', %s, exclusion %q. Details: %v", where, exclude, err)
+			}
+			rule.Exclude[j] = normalizeKeyword(exclude)
 		}
 	}
 
-	return cfg
+	return cfg.checkAmbiguousKeywords()
+}
+
+// checkAmbiguousKeywords rejects a keyword that selects different profiles in different places,
+// since only one of them could ever win. Rules with different scopes can share a keyword, as
+// they match in different places. Keywords must already be normalized.
+func (cfg *Config) checkAmbiguousKeywords() error {
+	profiles := make(map[string]string)
+	where := make(map[string]string)
+	add := func(keyword string, scope []string, profile, place string) error {
+		if strings.HasPrefix(keyword, excludePrefix) {
+			return nil
+		}
+		if profile == "" {
+			profile = cfg.ProfileOn
+		}
+		scope = slices.Sorted(slices.Values(scope))
+		key := keyword + "\x00" + strings.Join(slices.Compact(scope), ",")
+		if existing, ok := profiles[key]; ok && existing != profile {
+			return fmt.Errorf("Configuration error: keyword %q selects %s in %s but %s in %s. Each keyword may only select one profile.", keyword, existing, where[key], profile, place)
+		}
+		profiles[key], where[key] = profile, place
+		return nil
+	}
+	for i, rule := range cfg.Rules {
+		if !rule.IsEnabled() {
+			continue
+		}
+		for _, keyword := range rule.AllKeywords() {
+			if err := add(keyword, rule.Scope, rule.Profile, fmt.Sprintf("rule %d", i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	for keyword, profile := range cfg.Overrides {
+		if err := add(keyword, nil, profile, "'overrides'"); err != nil {
+			return err
+		}
+	}
+	return nil
 }
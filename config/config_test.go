@@ -0,0 +1,293 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeConfig writes content to a file called name in a temporary folder and returns its path.
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const yamlRules = `profile_on: "-Profile5"
+profile_off: "-Profile1"
+monitoring_mode: poll
+delay_seconds: 5
+log_level: debug
+rules:
+  - keyword: Doom
+    match_mode: exact
+    profile: "-Profile3"
+    exclude: [Doom_Launcher]
+  - keyword: re:^Witcher
+    profile: "-Profile2"
+`
+
+func TestLoadYAML(t *testing.T) {
+	cfg, err := Load(writeConfig(t, "config.yaml", yamlRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MonitoringMode != "poll" || cfg.DelaySeconds != 5 || cfg.LogLevel != "debug" {
+		t.Errorf("global options = %q, %d, %q; want poll, 5, debug", cfg.MonitoringMode, cfg.DelaySeconds, cfg.LogLevel)
+	}
+	if len(cfg.Rules) != 2 {
+		t.Fatalf("got %d rules, want 2", len(cfg.Rules))
+	}
+	doom, witcher := cfg.Rules[0], cfg.Rules[1]
+	if doom.Keyword != "doom" || doom.MatchMode != "exact" || doom.Profile != "-Profile3" || !slices.Equal(doom.Exclude, []string{"doom_launcher"}) {
+		t.Errorf("first rule = %+v, want keyword doom, exact, -Profile3, excluding doom_launcher", doom)
+	}
+	// A pattern keeps its case, so escapes like \D are not changed.
+	if witcher.Keyword != "re:^Witcher" || witcher.Profile != "-Profile2" {
+		t.Errorf("second rule = %+v, want keyword re:^Witcher, -Profile2", witcher)
+	}
+}
+
+func TestLoadRuleErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  string
+	}{
+		{"missing profile", "  - keyword: doom\n", `rule 1 (keyword "doom"): 'profile' is required`},
+		{"missing keyword", "  - keyword: doom\n    profile: \"-Profile3\"\n  - profile: \"-Profile2\"\n", "rule 2: 'keyword' or 'keywords' is required"},
+		{"unknown match mode", "  - keyword: doom\n    profile: \"-Profile3\"\n    match_mode: fuzzy\n", `'match_mode' must be "contains", "exact" or "word", but found "fuzzy"`},
+		{"invalid pattern", "  - keyword: re:(doom\n    profile: \"-Profile3\"\n", "invalid regular expression"},
+		{"invalid profile", "  - name: Doom\n    keyword: doom\n    profile: \"-Profile9\"\n", `rule 1 ("Doom")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "profile_on: \"-Profile5\"\nmonitoring_mode: event\nrules:\n" + tt.rules
+			cfg, err := Load(writeConfig(t, "config.yaml", content))
+			if err == nil {
+				t.Fatalf("Load returned %+v, want an error", cfg)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadCreatesMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ProfileOn != defaultConfig().ProfileOn {
+		t.Errorf("profile_on = %q, want the default %q", cfg.ProfileOn, defaultConfig().ProfileOn)
+	}
+	again, err := Load(path)
+	if err != nil {
+		t.Fatalf("loading the created file: %v", err)
+	}
+	if again.MonitoringMode != cfg.MonitoringMode || again.ProfileOff != cfg.ProfileOff {
+		t.Errorf("the created file loads as %+v, want the defaults %+v", again, cfg)
+	}
+}
+
+const jsonFull = `{
+    "profile_on": "-Profile5",
+    "profile_off": "-Profile1",
+    "monitoring_mode": "hybrid",
+    "delay_seconds": 10,
+    "dwell_ms": 500,
+    "notifications": true,
+    "overrides": {"cs2.exe": "-Profile4", "re:^valorant": ""},
+    "priority": ["cs2.exe"],
+    "rules": [
+        {"name": "Doom", "keywords": ["doom", "doometernal"], "profile": "-Profile3", "scope": ["exe"], "cooldown_ms": 2000},
+        {"keyword": "vlc", "profile": "-Profile2", "enabled": false}
+    ],
+    "schedules": [{"start": "23:00", "end": "07:00", "profile": "-Profile1"}],
+    "power_rules": [{"power": "battery", "profile": "-Profile1", "override_targets": true}]
+}
+`
+
+const yamlFull = `profile_on: "-Profile5"
+profile_off: "-Profile1"
+monitoring_mode: hybrid
+delay_seconds: 10
+dwell_ms: 500
+notifications: true
+overrides:
+  cs2.exe: "-Profile4"
+  re:^valorant: ""
+priority: [cs2.exe]
+rules:
+  - name: Doom
+    keywords: [doom, doometernal]
+    profile: "-Profile3"
+    scope: [exe]
+    cooldown_ms: 2000
+  - keyword: vlc
+    profile: "-Profile2"
+    enabled: false
+schedules:
+  - start: "23:00"
+    end: "07:00"
+    profile: "-Profile1"
+power_rules:
+  - power: battery
+    profile: "-Profile1"
+    override_targets: true
+`
+
+func TestJSONAndYAMLLoadIdentically(t *testing.T) {
+	fromJSON, err := LoadFile(writeConfig(t, "config.json", jsonFull))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := LoadFile(writeConfig(t, "config.yml", yamlFull))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Errorf("JSON and YAML load differently:\nJSON: %+v\nYAML: %+v", fromJSON, fromYAML)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	want, err := LoadFile(writeConfig(t, "config.json", jsonFull))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{FormatJSON, FormatYAML} {
+		t.Run(format, func(t *testing.T) {
+			data, err := encode(want, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadFileFormat(writeConfig(t, "config."+format, string(data)), format)
+			if err != nil {
+				t.Fatalf("loading the encoded config: %v\n%s", err, data)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip through %s changed the config:\ngot:  %+v\nwant: %+v", format, got, want)
+			}
+		})
+	}
+}
+
+func TestLoadFileFormat(t *testing.T) {
+	tests := []struct {
+		name, file, format, content string
+		wantErr                     bool
+	}{
+		{"yaml by extension", "config.yaml", FormatOf("config.yaml"), yamlFull, false},
+		{"yml by extension", "config.YML", FormatOf("config.YML"), yamlFull, false},
+		{"json by extension", "config.json", FormatOf("config.json"), jsonFull, false},
+		{"explicit yaml", "settings.txt", FormatYAML, yamlFull, false},
+		{"explicit json", "settings.conf", FormatJSON, jsonFull, false},
+		{"yaml read as json", "settings.txt", FormatJSON, yamlFull, true},
+		{"unknown format", "config.toml", "toml", jsonFull, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFileFormat(writeConfig(t, tt.file, tt.content), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadFileFormat error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (cfg.MonitoringMode != "hybrid" || len(cfg.Rules) != 2) {
+				t.Errorf("loaded %+v, want the hybrid config with two rules", cfg)
+			}
+		})
+	}
+}
+
+func TestLoadErrorPositions(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+		want                []string
+	}{
+		{
+			name:    "yaml syntax",
+			file:    "config.yaml",
+			content: "profile_on: \"-Profile5\"\nmonitoring_mode: event\nrules:\n  - keyword: doom\n    profile: \"-Profile3\n",
+			want:    []string{"line 5"},
+		},
+		{
+			name:    "yaml wrong type in a rule",
+			file:    "config.yaml",
+			content: "profile_on: \"-Profile5\"\nmonitoring_mode: event\nrules:\n  - keyword: doom\n    profile: \"-Profile3\"\n  - keyword: vlc\n    profile: [2]\n",
+			want:    []string{"line 7", "profile"},
+		},
+		{
+			name:    "yaml wrong type at the top level",
+			file:    "config.yaml",
+			content: "profile_on: \"-Profile5\"\nmonitoring_mode: event\ndelay_seconds: soon\n",
+			want:    []string{"line 3", `"delay_seconds"`},
+		},
+		{
+			name:    "yaml invalid rule",
+			file:    "config.yaml",
+			content: "profile_on: \"-Profile5\"\nmonitoring_mode: event\nrules:\n  - keyword: doom\n    profile: \"-Profile3\"\n  - keyword: vlc\n",
+			want:    []string{`rule 2 (keyword "vlc")`, "'profile' is required"},
+		},
+		{
+			name:    "json syntax",
+			file:    "config.json",
+			content: "{\n    \"profile_on\": \"-Profile5\",\n    \"monitoring_mode\": \"event\"\n    \"delay_seconds\": 5\n}\n",
+			want:    []string{"line 4"},
+		},
+		{
+			name:    "json wrong type",
+			file:    "config.json",
+			content: "{\n    \"profile_on\": \"-Profile5\",\n    \"monitoring_mode\": \"event\",\n    \"delay_seconds\": \"soon\"\n}\n",
+			want:    []string{"line 4", `"delay_seconds"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFile(writeConfig(t, tt.file, tt.content))
+			if err == nil {
+				t.Fatal("LoadFile succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestYAMLLine(t *testing.T) {
+	var root yaml.Node
+	doc := "rules:\n  - keyword: doom\n    profile: \"-Profile3\"\n  - keyword: vlc\n    profile: [2]\ndelay_seconds: soon\n"
+	if err := yaml.Unmarshal([]byte(doc), &root); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		kind string
+		want int
+	}{
+		{"rules.1.profile", "array", 5},
+		{"rules.profile", "array", 5},
+		{"rules.0.keyword", "string", 2},
+		{"rules.3.profile", "array", 0},
+		{"delay_seconds", "string", 6},
+		{"delay_seconds", "number", 0},
+		{"missing", "string", 0},
+	}
+	for _, tt := range tests {
+		if got := yamlLine(&root, strings.Split(tt.path, "."), tt.kind); got != tt.want {
+			t.Errorf("yamlLine(%q, %q) = %d, want %d", tt.path, tt.kind, got, tt.want)
+		}
+	}
+}
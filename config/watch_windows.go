@@ -0,0 +1,85 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// reloadDebounce is how long the file must be quiet before it is reloaded,
+// since editors often save in several steps.
+const reloadDebounce = 500 * time.Millisecond
+
+// Watch reloads the config file at path whenever it changes and passes the new, validated
+// config to onChange. Invalid edits are logged and ignored so the caller keeps its current
+// config. Watching stops when ctx is cancelled.
+func Watch(ctx context.Context, path string, onChange func(Config)) error {
+	return WatchFormat(ctx, path, FormatOf(path), onChange)
+}
+
+// WatchFormat is Watch for a file in the given format, as in LoadFileFormat.
+func WatchFormat(ctx context.Context, path, format string, onChange func(Config)) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	handle, err := windows.FindFirstChangeNotification(filepath.Dir(abs), false, windows.FILE_NOTIFY_CHANGE_LAST_WRITE|windows.FILE_NOTIFY_CHANGE_FILE_NAME)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer func() {
+			if err := windows.FindCloseChangeNotification(handle); err != nil {
+				logging.Warnf("Cannot stop watching %s: %v", path, err)
+			}
+		}()
+		lastMod := modTime(abs)
+		var pending time.Time
+		for ctx.Err() == nil {
+			event, err := windows.WaitForSingleObject(handle, uint32(reloadDebounce/(2*time.Millisecond)))
+			if err != nil {
+				logging.Warnf("Stopped watching %s for changes: %v", path, err)
+				return
+			}
+			if event == windows.WAIT_OBJECT_0 {
+				pending = time.Now()
+				if err := windows.FindNextChangeNotification(handle); err != nil {
+					logging.Warnf("Stopped watching %s for changes: %v", path, err)
+					return
+				}
+				continue
+			}
+			if pending.IsZero() || time.Since(pending) < reloadDebounce {
+				continue
+			}
+			pending = time.Time{}
+			// The notification covers the whole directory, so only reload when this file changed.
+			// A missing file is usually an editor replacing it; wait for the new one.
+			if mod := modTime(abs); !mod.IsZero() && !mod.Equal(lastMod) {
+				lastMod = mod
+				cfg, err := LoadFileFormat(abs, format)
+				if err != nil {
+					logging.Warnf("Ignoring the changes to %s and keeping the current configuration. %v", path, err)
+					continue
+				}
+				logging.Infof("Configuration reloaded from %s.", path)
+				onChange(cfg)
+			}
+		}
+	}()
+	return nil
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
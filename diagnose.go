@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"MSIAfterburnerScript/afterburner"
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/watcher"
+)
+
+// diagnosis collects the results of the diagnose command's checks.
+type diagnosis struct {
+	passed, failed int
+}
+
+// check prints one check's result, with the details indented below it.
+func (d *diagnosis) check(name string, err error, details ...string) {
+	if err != nil {
+		d.failed++
+		fmt.Printf("FAIL  %s: %v\n", name, err)
+	} else {
+		d.passed++
+		fmt.Printf("PASS  %s\n", name)
+	}
+	for _, detail := range details {
+		fmt.Printf("        %s\n", detail)
+	}
+}
+
+// diagnose checks each part of the application without applying a profile, and prints a
+// pass or fail line for each, for bug reports. It reports whether every check passed.
+func diagnose() bool {
+	var d diagnosis
+	fmt.Printf("Configuration file: %s\n\n", configFile)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		d.check("Configuration", err)
+	} else {
+		details := []string{fmt.Sprintf("%d rules, %d overrides, %d presets, monitoring mode %q", len(cfg.Rules), len(cfg.Overrides), len(cfg.Presets), cfg.MonitoringMode)}
+		if cfg.Preset != "" {
+			details = append(details, fmt.Sprintf("using the rules of preset %q", cfg.Preset))
+			cfg, _ = cfg.WithPreset(cfg.Preset)
+		}
+		for i, rule := range cfg.Rules {
+			state := ""
+			if !rule.IsEnabled() {
+				state = " (disabled)"
+			}
+			details = append(details, fmt.Sprintf("rule %d: %s -> %s%s", i+1, ruleName(rule), rule.Profile, state))
+		}
+		d.check("Configuration", nil, details...)
+		diagnoseAfterburner(&d, &cfg)
+	}
+
+	if watcher.Elevated() {
+		d.check("Running as administrator", nil)
+	} else {
+		d.check("Running as administrator", errors.New("not elevated, so MSI Afterburner and games run as administrator cannot be controlled or fully matched"))
+	}
+	d.check("Event hooks", watcher.CheckEventHooks())
+
+	if fg, ok := watcher.CurrentForeground(); ok {
+		details := []string{fmt.Sprintf("exe %q, title %q, pid %d", fg.ExePath, fg.Title, fg.PID)}
+		if err == nil {
+			if match, found := watcher.FirstActive(targets(&cfg), matchOptions(&cfg)); found {
+				details = append(details, fmt.Sprintf("active target: '%s' via %s -> %s", match.Keyword, match.Source, profileForMatch(&cfg, match)))
+			} else {
+				details = append(details, "no active target")
+			}
+		}
+		d.check("Foreground application", nil, details...)
+	} else {
+		d.check("Foreground application", errors.New("no foreground window found"))
+	}
+
+	fmt.Printf("\n%d passed, %d failed.\n", d.passed, d.failed)
+	return d.failed == 0
+}
+
+// diagnoseAfterburner checks that MSI Afterburner is installed and its current profile can be read.
+func diagnoseAfterburner(d *diagnosis, cfg *config.Config) {
+	path := cfg.AfterburnerPath
+	if _, err := os.Stat(path); err != nil {
+		detected, findErr := afterburner.FindAfterburnerExe()
+		if findErr != nil {
+			d.check("MSI Afterburner", fmt.Errorf("%s was not found and auto-detection failed: %v", path, findErr))
+			return
+		}
+		path = detected
+	}
+	d.check("MSI Afterburner", nil, path)
+	client := afterburner.New(path)
+	current, err := client.CurrentProfile()
+	switch {
+	case err == nil:
+		d.check("Current Afterburner profile", nil, fmt.Sprintf("profile %d", current))
+	case errors.Is(err, afterburner.ErrProfileUnknown):
+		d.check("Current Afterburner profile", nil, "the current settings do not match a saved profile")
+	default:
+		d.check("Current Afterburner profile", err)
+	}
+}
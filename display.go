@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/watcher"
+)
+
+// externalDisplay is whether an external monitor is connected. It is read at startup and
+// refreshed whenever Windows reports a display change.
+var externalDisplay atomic.Bool
+
+// refreshDisplay re-reads the display state, keeping the previous value if it cannot be read.
+func refreshDisplay() {
+	external, err := watcher.ExternalDisplayConnected()
+	if err != nil {
+		logging.Warnf("Cannot read the display configuration: %v", err)
+		return
+	}
+	if externalDisplay.Swap(external) != external {
+		logging.Infof("External display connected: %t.", external)
+	}
+}
+
+// startDisplayWatcher keeps externalDisplay current and re-evaluates the profile when the
+// display configuration changes.
+func startDisplayWatcher(live *liveConfig) {
+	refreshDisplay()
+	errs := watcher.StartDisplayWatcher(context.Background(), func() {
+		refreshDisplay()
+		// Apply off the window thread, so the display change message is not held up.
+		go live.runRecheck()
+	})
+	go func() {
+		if err := <-errs; err != nil {
+			logging.Warnf("Display changes will not be noticed: %v", err)
+		}
+	}()
+}
+
+// displayMatch returns the first rule for the current display state whose override_targets equals overriding.
+func displayMatch(rules []config.DisplayRule, external, overriding bool) (watcher.Match, bool) {
+	for _, rule := range rules {
+		if rule.External == external && rule.OverrideTargets == overriding {
+			keyword := "laptop display only"
+			if external {
+				keyword = "external display connected"
+			}
+			return watcher.Match{Keyword: keyword, Profile: rule.Profile, Source: watcher.SourceDisplay, Tag: rule}, true
+		}
+	}
+	return watcher.Match{}, false
+}
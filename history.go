@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"MSIAfterburnerScript/watcher"
+)
+
+// defaultHistorySize is the number of switches kept when history_size is 0.
+const defaultHistorySize = 50
+
+// switchRecord is one profile switch in the history.
+type switchRecord struct {
+	Time        time.Time `json:"time"`
+	FromProfile string    `json:"from_profile"`
+	ToProfile   string    `json:"to_profile"`
+	Keyword     string    `json:"keyword,omitempty"`
+	Rule        string    `json:"rule,omitempty"`
+	Source      string    `json:"source,omitempty"`
+}
+
+// history keeps the most recent profile switches in a ring buffer.
+var history struct {
+	mu      sync.Mutex
+	records []switchRecord
+	next    int
+	size    int
+}
+
+// setHistorySize sets how many switches are kept, keeping the newest ones when it shrinks.
+func setHistorySize(size int) {
+	if size == 0 {
+		size = defaultHistorySize
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if size == history.size {
+		return
+	}
+	records := orderedHistory()
+	if len(records) > size {
+		records = records[len(records)-size:]
+	}
+	history.records, history.next, history.size = records, len(records)%size, size
+}
+
+// recordSwitch adds a switch from one profile to another to the history.
+// match is the zero Match when switching to profile_off.
+func recordSwitch(from, to string, match watcher.Match) {
+	r := switchRecord{Time: time.Now(), FromProfile: from, ToProfile: to}
+	if match.Keyword != "" {
+		r.Keyword, r.Rule, r.Source = match.Keyword, matchedRuleName(match), match.Source.String()
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if history.size == 0 {
+		history.size = defaultHistorySize
+	}
+	if len(history.records) < history.size {
+		history.records = append(history.records, r)
+	} else {
+		history.records[history.next] = r
+	}
+	history.next = (history.next + 1) % history.size
+}
+
+// orderedHistory returns the recorded switches, oldest first. history.mu must be held.
+func orderedHistory() []switchRecord {
+	if len(history.records) < history.size {
+		return append([]switchRecord(nil), history.records...)
+	}
+	return append(append([]switchRecord(nil), history.records[history.next:]...), history.records[:history.next]...)
+}
+
+// serveHistory answers GET /history with the recorded switches, oldest first.
+func serveHistory(w http.ResponseWriter, _ *http.Request) {
+	history.mu.Lock()
+	records := orderedHistory()
+	history.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// printHistory asks the running instance for its switch history and prints it.
+func printHistory(addr string) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + addr + "/history")
+	if err != nil {
+		return fmt.Errorf("cannot reach the running instance on %s (is it running with 'status_addr' set?): %w", addr, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("history request to %s failed: %s", addr, resp.Status)
+	}
+	var records []switchRecord
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return fmt.Errorf("cannot read history from %s: %w", addr, err)
+	}
+	if len(records) == 0 {
+		fmt.Println("No profile switches yet.")
+	}
+	for _, r := range records {
+		from := r.FromProfile
+		if from == "" {
+			from = "(none)"
+		}
+		reason := "no active targets"
+		if r.Rule != "" {
+			reason = fmt.Sprintf("rule '%s', '%s' via %s", r.Rule, r.Keyword, r.Source)
+		} else if r.Keyword != "" {
+			reason = fmt.Sprintf("'%s' via %s", r.Keyword, r.Source)
+		}
+		fmt.Printf("%s  %s -> %s  (%s)\n", r.Time.Format("2006-01-02 15:04:05"), from, r.ToProfile, reason)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"MSIAfterburnerScript/afterburner"
+	"MSIAfterburnerScript/logging"
+)
+
+// holdProfile returns the "-ProfileN" flag for a profile given as "N" or "-ProfileN".
+func holdProfile(profile string) (string, error) {
+	if n, err := strconv.Atoi(profile); err == nil {
+		profile = afterburner.ProfileArg(n)
+	}
+	if _, err := afterburner.ParseProfile(profile); err != nil {
+		return "", err
+	}
+	return profile, nil
+}
+
+// setHold applies profile and keeps it until release is called; automatic switching is
+// suppressed meanwhile, but still logged. An empty profile releases the hold.
+func (l *liveConfig) setHold(profile string) {
+	if profile != "" {
+		logging.Infof("Holding profile %s until it is released.", profile)
+	} else {
+		logging.Infof("Manual hold released. Resuming automatic switching.")
+	}
+	l.update(func(s *configSnapshot) { s.held = profile })
+}
+
+// serveApply applies the profile in the "profile" parameter and holds it.
+func serveApply(w http.ResponseWriter, r *http.Request, live *liveConfig) {
+	profile, err := holdProfile(r.FormValue("profile"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	live.setHold(profile)
+	fmt.Fprintf(w, "Holding profile %s. Run 'release' to resume automatic switching.\n", profile)
+}
+
+// serveRelease ends a manual hold.
+func serveRelease(w http.ResponseWriter, _ *http.Request, live *liveConfig) {
+	if live.load().held == "" {
+		fmt.Fprintln(w, "No profile is being held.")
+		return
+	}
+	live.setHold("")
+	fmt.Fprintln(w, "Released the held profile. Automatic switching has resumed.")
+}
+
+// requestHold asks the running instance to apply and hold profile, or with release set, to end
+// the hold.
+func requestHold(addr, profile string, release bool) error {
+	path, form := "/release", url.Values{}
+	if !release {
+		if profile == "" {
+			return fmt.Errorf("name the profile to apply, e.g. 'apply 3'")
+		}
+		path, form = "/apply", url.Values{"profile": {profile}}
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := postForm(client, "http://"+addr+path, form)
+	if err != nil {
+		return fmt.Errorf("cannot reach the running instance on %s (is it running with 'status_addr' set?): %w", addr, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed: %s", strings.TrimSpace(string(body)))
+	}
+	fmt.Print(string(body))
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestHoldProfile(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"3", "-Profile3", false},
+		{"-Profile5", "-Profile5", false},
+		{"1", "-Profile1", false},
+		{"0", "", true},
+		{"6", "", true},
+		{"-Profile9", "", true},
+		{"", "", true},
+		{"quiet", "", true},
+	}
+	for _, tt := range tests {
+		got, err := holdProfile(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("holdProfile(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
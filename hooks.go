@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"MSIAfterburnerScript/afterburner"
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/watcher"
+)
+
+// runningHooks counts the hooks that have not finished yet, so -once can wait for them.
+var runningHooks sync.WaitGroup
+
+// runHooks starts the configured hooks for a switch from one profile to another. Each hook runs
+// in its own goroutine, so a slow one does not hold up detection; its exit code is logged.
+func runHooks(cfg *config.Config, from, to string, match watcher.Match) {
+	if len(cfg.Hooks) == 0 {
+		return
+	}
+	source := ""
+	if match.Keyword != "" {
+		source = match.Source.String()
+	}
+	values := map[string]string{"profile": to, "previous": from, "keyword": match.Keyword, "source": source}
+	env := []string{
+		"MSIAB_PROFILE=" + to,
+		"MSIAB_PREVIOUS_PROFILE=" + from,
+		"MSIAB_KEYWORD=" + match.Keyword,
+		"MSIAB_SOURCE=" + source,
+		fmt.Sprintf("MSIAB_PID=%d", match.PID),
+		"MSIAB_EXE_PATH=" + match.ExePath,
+		"MSIAB_WINDOW_TITLE=" + match.WindowTitle,
+	}
+	for _, hook := range cfg.Hooks {
+		runningHooks.Add(1)
+		go func() {
+			defer runningHooks.Done()
+			out, err := afterburner.RunTemplateEnv(hook, values, env)
+			if out != "" {
+				logging.Debugf("Output of hook %q: %s", hook, strings.TrimSpace(out))
+			}
+			var exitErr *exec.ExitError
+			switch {
+			case errors.As(err, &exitErr):
+				logging.Warnf("Hook %q exited with code %d.", hook, exitErr.ExitCode())
+			case err != nil:
+				logging.Warnf("Hook %q failed: %v", hook, err)
+			default:
+				logging.Debugf("Hook %q exited with code 0.", hook)
+			}
+		}()
+	}
+}
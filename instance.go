@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// instanceMutexName is shared by every copy of the application in the user's session.
+const instanceMutexName = `Local\MSIAfterburnerScript-SingleInstance`
+
+// errAlreadyRunning is returned by acquireSingleInstance when another copy holds the lock.
+var errAlreadyRunning = errors.New("MSI Afterburner Script is already running")
+
+// acquireSingleInstance takes the named mutex that marks this process as the running instance.
+// The handle is deliberately never closed: Windows releases it when the process exits.
+func acquireSingleInstance() error {
+	name, err := windows.UTF16PtrFromString(instanceMutexName)
+	if err != nil {
+		return err
+	}
+	handle, err := windows.CreateMutex(nil, false, name)
+	if errors.Is(err, windows.ERROR_ALREADY_EXISTS) {
+		windows.CloseHandle(handle)
+		return errAlreadyRunning
+	}
+	return err
+}
+
+// showError displays a message box, since a build without a console has nowhere else to say it.
+func showError(message string) {
+	text, _ := windows.UTF16PtrFromString(message)
+	caption, _ := windows.UTF16PtrFromString("MSI Afterburner Script")
+	windows.MessageBox(0, text, caption, windows.MB_OK|windows.MB_ICONWARNING)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// stubKeyword finds the keyword of a rule stub written by learnForeground.
+var stubKeyword = regexp.MustCompile(`^// \{"keyword": ("(?:[^"\\]|\\.)*")`)
+
+// learned tracks the programs that already have a stub in learn_file.
+var learned struct {
+	mu   sync.Mutex
+	path string
+	seen map[string]bool
+}
+
+// learnForeground appends a commented-out rule stub for an unmatched foreground program to
+// the learn file, unless the file already has one for the same exe name.
+func learnForeground(path, exePath, title string) {
+	if exePath == "" {
+		return
+	}
+	keyword := strings.ToLower(filepath.Base(exePath))
+	learned.mu.Lock()
+	defer learned.mu.Unlock()
+	if learned.path != path {
+		learned.path, learned.seen = path, readStubs(path)
+	}
+	if learned.seen[keyword] {
+		return
+	}
+	learned.seen[keyword] = true
+
+	stub, _ := json.Marshal(keyword)
+	line := fmt.Sprintf("// Seen %s with title %q:\n// {\"keyword\": %s, \"profile\": \"TODO\"},\n", time.Now().Format("2006-01-02 15:04"), title, stub)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		logging.Warnf("Cannot write a rule stub for %s to %s: %v", keyword, path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.WriteString(line); err != nil {
+		logging.Warnf("Cannot write a rule stub for %s to %s: %v", keyword, path, err)
+		return
+	}
+	logging.Infof("Learn mode: added a rule stub for %s to %s.", keyword, path)
+}
+
+// readStubs returns the keywords of the stubs already in the learn file.
+func readStubs(path string) map[string]bool {
+	seen := make(map[string]bool)
+	f, err := os.Open(path)
+	if err != nil {
+		return seen
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := stubKeyword.FindStringSubmatch(strings.TrimSpace(scanner.Text())); m != nil {
+			var keyword string
+			if json.Unmarshal([]byte(m[1]), &keyword) == nil {
+				seen[keyword] = true
+			}
+		}
+	}
+	return seen
+}
@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an io.Writer that appends to a log file and rotates it once it grows past
+// MaxSize bytes. Rotated files are renamed path.1, path.2, ... and at most MaxFiles files,
+// including the current one, are kept.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens path for appending, creating it if needed.
+func OpenRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+	r := &RotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := openShared(r.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past its size limit.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than losing messages.
+			fmt.Fprintf(os.Stderr, "Warning: Cannot rotate log file %s: %v\n", r.path, err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path.N-1 ... path.1 up by one, moves the current file to path.1 and
+// starts a new one. The oldest file is removed.
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(r.backup(r.maxFiles - 1))
+	for i := r.maxFiles - 2; i >= 1; i-- {
+		os.Rename(r.backup(i), r.backup(i+1))
+	}
+	var renameErr error
+	if r.maxFiles > 1 {
+		renameErr = os.Rename(r.path, r.backup(1))
+	} else {
+		renameErr = os.Truncate(r.path, 0)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
+
+func (r *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+// Close closes the current file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
@@ -0,0 +1,85 @@
+// Package logging is a small leveled wrapper around the standard log package.
+// Messages below the current level are dropped; the rest go to the standard logger,
+// so its flags and output apply.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is the minimum severity that is written.
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var level atomic.Int32
+
+func init() {
+	level.Store(int32(LevelInfo))
+}
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("Level(%d)", int32(l))
+}
+
+// ParseLevel converts a config value into a Level. An empty string is LevelInfo.
+func ParseLevel(s string) (Level, bool) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, true
+	case "", "info":
+		return LevelInfo, true
+	case "warn", "warning":
+		return LevelWarn, true
+	case "error":
+		return LevelError, true
+	}
+	return LevelInfo, false
+}
+
+// SetLevel changes the minimum level. It is safe to call while other goroutines are logging.
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// Enabled reports whether messages at l are currently written.
+func Enabled(l Level) bool {
+	return int32(l) >= level.Load()
+}
+
+func output(l Level, prefix, format string, args ...any) {
+	if Enabled(l) {
+		// Skip output and the level function so Lshortfile points at the caller.
+		_ = log.Output(3, prefix+fmt.Sprintf(format, args...))
+	}
+}
+
+// Debugf logs diagnostic detail such as every detected match.
+func Debugf(format string, args ...any) { output(LevelDebug, "Debug: ", format, args...) }
+
+// Infof logs normal operation such as profile switches.
+func Infof(format string, args ...any) { output(LevelInfo, "", format, args...) }
+
+// Warnf logs a recoverable problem.
+func Warnf(format string, args ...any) { output(LevelWarn, "Warning: ", format, args...) }
+
+// Errorf logs a failure that stopped an operation.
+func Errorf(format string, args ...any) { output(LevelError, "Error: ", format, args...) }
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// repeatWindow is how often a message that keeps repeating is summarized.
+const repeatWindow = 30 * time.Second
+
+// repeats tracks the last message written by DebugfCollapsed or InfofCollapsed.
+var repeats struct {
+	mu    sync.Mutex
+	last  string
+	count int
+	since time.Time
+}
+
+// DebugfCollapsed is Debugf for messages that tend to repeat on every check, such as the
+// detected target in poll mode. A message identical to the previous collapsed one is counted
+// instead of written, and summarized as "(repeated N times in 30s)" every repeatWindow and when
+// a different collapsed message arrives.
+func DebugfCollapsed(format string, args ...any) {
+	collapsed(LevelDebug, "Debug: ", format, args...)
+}
+
+// InfofCollapsed is Infof with the repeat handling of DebugfCollapsed.
+func InfofCollapsed(format string, args ...any) {
+	collapsed(LevelInfo, "", format, args...)
+}
+
+func collapsed(l Level, prefix, format string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	msg := prefix + fmt.Sprintf(format, args...)
+	repeats.mu.Lock()
+	defer repeats.mu.Unlock()
+	now := time.Now()
+	if msg == repeats.last {
+		repeats.count++
+		if elapsed := now.Sub(repeats.since); elapsed >= repeatWindow {
+			_ = log.Output(3, fmt.Sprintf("%s (repeated %d times in %s)", msg, repeats.count, elapsed.Round(time.Second)))
+			repeats.count, repeats.since = 0, now
+		}
+		return
+	}
+	if repeats.count > 0 {
+		_ = log.Output(3, fmt.Sprintf("%s (repeated %d times in %s)", repeats.last, repeats.count, now.Sub(repeats.since).Round(time.Second)))
+	}
+	_ = log.Output(3, msg)
+	repeats.last, repeats.count, repeats.since = msg, 0, now
+}
@@ -1,103 +1,1106 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
-	"os/exec"
+	"os"
+	"slices"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"MSIAfterburnerScript/afterburner"
 	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/notify"
+	"MSIAfterburnerScript/tray"
 	"MSIAfterburnerScript/watcher"
 )
 
-// runAfterburner executes the MSI Afterburner command.
-func runAfterburner(exe, arg string) {
-	cmd := exec.Command(exe, arg)
-	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
-	if err := cmd.Start(); err != nil {
-		log.Printf("Failed to launch Afterburner with profile %s: %v", arg, err)
-	} else {
-		log.Printf("Successfully applied Afterburner profile: %s", arg)
+// matchOptions builds the watcher options from the config.
+func matchOptions(cfg *config.Config) watcher.Options {
+	var stages []watcher.Stage
+	for _, name := range cfg.Stages {
+		stage, _ := watcher.ParseStage(name)
+		stages = append(stages, stage)
+	}
+	return watcher.Options{
+		Stages:          stages,
+		PathMatch:       cfg.PathMatch,
+		FullscreenOnly:  cfg.FullscreenOnly,
+		ForegroundOnly:  cfg.ForegroundOnly,
+		NormalizeTitles: cfg.NormalizeTitles,
+		TitleStrip:      cfg.TitleStrip,
+		WindowCacheTTL:  time.Duration(cfg.WindowCacheMs) * time.Millisecond,
+		ProcessCacheTTL: time.Duration(cfg.ProcessCacheMs) * time.Millisecond,
 	}
 }
 
-// checkStateAndApplyProfile is the core logic for determining and applying a profile.
-// It now uses the Overrides map in the config as the sole list of targets.
-func checkStateAndApplyProfile(cfg *config.Config, currentProfile *string) {
-	// The list of targets is now the keys of the Overrides map.
-	// The watcher will prioritize the foreground application.
-	activeTarget, isActive := watcher.FirstActiveTarget(cfg.Overrides)
+// targets builds the ordered target list: rules in the order they are listed, then the
+// Overrides map ordered by the priority setting.
+func targets(cfg *config.Config) []watcher.Target {
+	mode, _ := watcher.ParseMatchMode(cfg.MatchMode)
+	dwell := time.Duration(cfg.DwellMs) * time.Millisecond
+	cooldown := time.Duration(cfg.CooldownMs) * time.Millisecond
+	result := make([]watcher.Target, 0, len(cfg.Rules)+len(cfg.Overrides))
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if !rule.IsEnabled() {
+			continue
+		}
+		ruleMode := mode
+		if rule.MatchMode != "" {
+			ruleMode, _ = watcher.ParseMatchMode(rule.MatchMode)
+		}
+		ruleDwell := dwell
+		if rule.DwellMs != nil {
+			ruleDwell = time.Duration(*rule.DwellMs) * time.Millisecond
+		}
+		ruleCooldown := cooldown
+		if rule.CooldownMs != nil {
+			ruleCooldown = time.Duration(*rule.CooldownMs) * time.Millisecond
+		}
+		var scope watcher.Scope
+		for _, name := range rule.Scope {
+			s, _ := watcher.ParseScope(name)
+			scope |= s
+		}
+		// Each keyword is its own target, so the match names the keyword that was found.
+		for _, keyword := range rule.AllKeywords() {
+			result = append(result, watcher.Target{Keyword: keyword, Profile: rule.Profile, Mode: ruleMode, Exclude: rule.Exclude, Dwell: ruleDwell, Cooldown: ruleCooldown, Scope: scope, FullscreenOnly: rule.FullscreenOnly, MatchDescendants: rule.IncludeChildren, Tag: rule})
+		}
+	}
+	for _, t := range watcher.TargetsFromMap(cfg.Overrides, mode, cfg.Priority) {
+		t.Dwell, t.Cooldown = dwell, cooldown
+		result = append(result, t)
+	}
+	return result
+}
 
-	var desiredProfile string
-	if isActive {
-		profile := cfg.Overrides[activeTarget]
-		if profile != "" {
-			desiredProfile = profile
-		} else {
-			desiredProfile = cfg.ProfileOn
+// profileForMatch returns the profile configured for an active target,
+// falling back to profile_on when the target has none.
+func profileForMatch(cfg *config.Config, match watcher.Match) string {
+	if match.Profile != "" {
+		return match.Profile
+	}
+	return cfg.ProfileOn
+}
+
+// configFlag and configFormat are the -config and -config-format command line flags.
+var (
+	configFlag   = flag.String("config", "", "config file to use (default config.json, or config.yaml or config.yml if only one of those exists)")
+	configFormat = flag.String("config-format", "", "format of the config file, \"json\" or \"yaml\" (default: from the file extension)")
+)
+
+// configFile is the config file in use, set from the flags at startup.
+var configFile string
+
+// loadConfig reads and validates configFile.
+func loadConfig() (config.Config, error) {
+	return config.LoadFileFormat(configFile, configFileFormat())
+}
+
+// configFileFormat returns the format of configFile, from -config-format or its extension.
+func configFileFormat() string {
+	if *configFormat != "" {
+		return strings.ToLower(*configFormat)
+	}
+	return config.FormatOf(configFile)
+}
+
+// dryRun is the -dry-run command line flag, which forces dry_run on regardless of the config.
+var dryRun = flag.Bool("dry-run", false, "detect targets and log the profiles that would be applied, without running MSI Afterburner")
+
+// matchReason describes why a match selects its profile, for the log.
+func matchReason(match watcher.Match) string {
+	switch match.Source {
+	case watcher.SourceTemperature:
+		return fmt.Sprintf("Temperature rule active: %s.", match.Keyword)
+	case watcher.SourceSchedule:
+		return fmt.Sprintf("Scheduled profile active: %s.", match.Keyword)
+	case watcher.SourcePower:
+		return fmt.Sprintf("Power rule active: %s.", match.Keyword)
+	case watcher.SourceDisplay:
+		return fmt.Sprintf("Display rule active: %s.", match.Keyword)
+	}
+	if name := matchedRuleName(match); name != "" {
+		return fmt.Sprintf("Rule '%s' active: target '%s' found via %s (pid %d, exe %q, title %q).", name, match.Keyword, match.Source, match.PID, match.ExePath, match.WindowTitle)
+	}
+	return fmt.Sprintf("Active target '%s' found via %s (pid %d, exe %q, title %q).", match.Keyword, match.Source, match.PID, match.ExePath, match.WindowTitle)
+}
+
+// onUnmatchedForeground is called when the foreground window changes to one that no keyword
+// matches, so the programs that still need a rule can be collected from the log.
+func onUnmatchedForeground(cfg *config.Config, exe, title string) {
+	if cfg.LearnFile != "" {
+		learnForeground(cfg.LearnFile, exe, title)
+	}
+	if cfg.LogUnmatched {
+		logging.Infof("Foreground changed to %q (title %q), which no keyword matches.", exe, title)
+		return
+	}
+	logging.Debugf("Foreground changed to %q (title %q), which no keyword matches.", exe, title)
+}
+
+// conflictSummary describes the active targets when more than one of them is active and they
+// select different profiles, naming the winner first. It returns "" when there is no conflict.
+func conflictSummary(cfg *config.Config, matches []watcher.Match) string {
+	if len(matches) < 2 {
+		return ""
+	}
+	parts := make([]string, len(matches))
+	conflict := false
+	for i, m := range matches {
+		profile := profileForMatch(cfg, m)
+		conflict = conflict || profile != profileForMatch(cfg, matches[0])
+		parts[i] = fmt.Sprintf("'%s' (profile %s, via %s)", m.Keyword, profile, m.Source)
+	}
+	if !conflict {
+		return ""
+	}
+	return strings.Join(parts, ", ")
+}
+
+// preempts reports whether cur may end the cooldown of last early. Rules that win over targets
+// always may, and a target may when it comes before last in the configured order.
+func preempts(cfg *config.Config, cur, last watcher.Match) bool {
+	switch cur.Source {
+	case watcher.SourceTemperature, watcher.SourceSchedule:
+		return true
+	case watcher.SourcePower:
+		rule, _ := cur.Tag.(config.PowerRule)
+		return rule.OverrideTargets
+	case watcher.SourceDisplay:
+		rule, _ := cur.Tag.(config.DisplayRule)
+		return rule.OverrideTargets
+	}
+	if cur.Keyword == "" {
+		return false
+	}
+	order := targets(cfg)
+	curIndex, lastIndex := slices.IndexFunc(order, cur.Of), slices.IndexFunc(order, last.Of)
+	return curIndex >= 0 && lastIndex >= 0 && curIndex < lastIndex
+}
+
+// reverts reports whether changing from last to cur ends a target's match without another
+// target taking over, which waits out revert_grace_ms. Power and display rules without
+// override_targets stand in for profile_off, so switching to them counts as reverting.
+func reverts(cur, last watcher.Match) bool {
+	switch last.Source {
+	case watcher.SourceTemperature, watcher.SourceSchedule, watcher.SourcePower, watcher.SourceDisplay:
+		return false
+	}
+	if last.Keyword == "" {
+		return false
+	}
+	switch cur.Source {
+	case watcher.SourceTemperature, watcher.SourceSchedule:
+		return false
+	case watcher.SourcePower:
+		rule, _ := cur.Tag.(config.PowerRule)
+		return !rule.OverrideTargets
+	case watcher.SourceDisplay:
+		rule, _ := cur.Tag.(config.DisplayRule)
+		return !rule.OverrideTargets
+	}
+	return cur.Keyword == ""
+}
+
+// detectedPath caches the result of afterburner.FindAfterburnerExe, run once at startup.
+var detectedPath string
+
+// afterburnerPath returns the configured executable if it exists, otherwise the detected one.
+func afterburnerPath(cfg *config.Config) string {
+	if _, err := os.Stat(cfg.AfterburnerPath); err == nil || detectedPath == "" {
+		return cfg.AfterburnerPath
+	}
+	return detectedPath
+}
+
+// afterburnerClient returns the client that applies the profile for match: the matched rule's
+// afterburner_path and afterburner_args when set, and the global ones otherwise.
+func afterburnerClient(cfg *config.Config, match watcher.Match) *afterburner.Client {
+	client := afterburner.New(afterburnerPath(cfg))
+	client.Args = cfg.AfterburnerArgs
+	if rule, ok := match.Tag.(*config.Rule); ok {
+		if rule.AfterburnerPath != "" {
+			client.Path = rule.AfterburnerPath
+		}
+		if rule.AfterburnerArgs != nil {
+			client.Args = rule.AfterburnerArgs
 		}
-	} else {
-		desiredProfile = cfg.ProfileOff
 	}
+	return client
+}
 
+// applyProfile runs Afterburner with desiredProfile unless it is already the current profile.
+// If the matched rule has a custom command, that command is run instead. With cancellable set,
+// supersedeApply may cancel the switch while it is in progress.
+func applyProfile(cfg *config.Config, desiredProfile, reason string, match watcher.Match, currentProfile *string, cancellable bool) {
 	if desiredProfile != *currentProfile {
-		log.Printf("State change detected. Desired profile: %s.", desiredProfile)
-		if isActive {
-			log.Printf("Reason: Active target '%s' found.", activeTarget)
+		logging.Infof("State change detected. Desired profile: %s.", desiredProfile)
+		logging.Infof("Reason: %s", reason)
+		if cfg.DryRun || *dryRun {
+			keyword := match.Keyword
+			if keyword == "" {
+				keyword = "(no active target)"
+			}
+			logging.Infof("[dry-run] would apply profile %s for keyword %s", desiredProfile, keyword)
+			recordSwitch(*currentProfile, desiredProfile, match)
+			*currentProfile = desiredProfile
+			applyRTSS(cfg, match)
+			return
+		}
+		if rule, ok := match.Tag.(*config.Rule); ok && rule.Command != "" {
+			out, err := afterburner.RunTemplate(rule.Command, map[string]string{"profile": desiredProfile, "keyword": match.Keyword})
+			if out != "" {
+				logging.Debugf("Output of custom command for '%s': %s", match.Keyword, strings.TrimSpace(out))
+			}
+			if err != nil {
+				logging.Errorf("Failed to run custom command for '%s': %v", match.Keyword, err)
+				applyFailures.Add(1)
+				notifyFailure(cfg, desiredProfile, err)
+				return
+			}
+			logging.Infof("Successfully ran custom command for profile: %s", desiredProfile)
+			profileApplies.Add(1)
+			recordSwitch(*currentProfile, desiredProfile, match)
+			runHooks(cfg, *currentProfile, desiredProfile, match)
+			*currentProfile = desiredProfile
+			applyRTSS(cfg, match)
+			notifySwitch(cfg, desiredProfile, match)
+			return
+		}
+		client := afterburnerClient(cfg, match)
+		if cfg.LaunchAfterburner {
+			if err := client.EnsureAfterburnerRunning(); err != nil {
+				logging.Warnf("%v", err)
+			}
+		}
+		n, err := afterburner.ParseProfile(desiredProfile)
+		if err == nil {
+			ctx, done := startApply(desiredProfile, cancellable)
+			err = client.ApplyProfileContext(ctx, n)
+			done()
+		}
+		if errors.Is(err, context.Canceled) {
+			logging.Infof("Stopped switching to profile %s because another profile is wanted now.", desiredProfile)
+			// Afterburner may have switched already, so the next decision applies its profile even
+			// if it is the one that was current before.
+			*currentProfile = ""
+			return
+		}
+		if err != nil {
+			logging.Errorf("Failed to apply Afterburner profile %s: %v", desiredProfile, err)
+			applyFailures.Add(1)
+			notifyFailure(cfg, desiredProfile, err)
+			return
+		}
+		if name := matchedRuleName(match); name != "" {
+			logging.Infof("Successfully applied Afterburner profile %s for rule '%s'.", desiredProfile, name)
 		} else {
-			log.Printf("Reason: No active targets found.")
+			logging.Infof("Successfully applied Afterburner profile: %s", desiredProfile)
 		}
-		runAfterburner(cfg.AfterburnerPath, desiredProfile)
+		profileApplies.Add(1)
+		recordSwitch(*currentProfile, desiredProfile, match)
+		runHooks(cfg, *currentProfile, desiredProfile, match)
 		*currentProfile = desiredProfile
+		applyRTSS(cfg, match)
+		notifySwitch(cfg, desiredProfile, match)
 	}
 }
 
-// startPollingMode runs the application by checking for targets on a timer.
-func startPollingMode(cfg config.Config) {
-	log.Println("Starting in Polling Mode.")
+// setLogLevel applies the configured log_level, which LoadFile has already validated.
+func setLogLevel(cfg *config.Config) {
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.SetLevel(level)
+}
+
+// Defaults for log rotation when log_max_size_mb or log_max_files is 0.
+const (
+	defaultLogMaxSizeMB = 5
+	defaultLogMaxFiles  = 3
+)
+
+// openLogFile starts copying the log to cfg.LogFile, if set. The console still gets every line.
+func openLogFile(cfg *config.Config) {
+	if cfg.LogFile == "" {
+		return
+	}
+	sizeMB, files := cfg.LogMaxSizeMB, cfg.LogMaxFiles
+	if sizeMB == 0 {
+		sizeMB = defaultLogMaxSizeMB
+	}
+	if files == 0 {
+		files = defaultLogMaxFiles
+	}
+	file, err := logging.OpenRotatingFile(cfg.LogFile, int64(sizeMB)<<20, files)
+	if err != nil {
+		logging.Warnf("Cannot open log file %s, logging to the console only: %v", cfg.LogFile, err)
+		return
+	}
+	// The file comes first: with -H windowsgui there is no console and writes to it fail,
+	// which would stop io.MultiWriter before it reached the file.
+	log.SetOutput(io.MultiWriter(file, os.Stderr))
+}
+
+// notifyFailure shows a toast when a profile could not be applied, so a failed switch is not
+// mistaken for a successful one.
+func notifyFailure(cfg *config.Config, profile string, err error) {
+	if !cfg.Notifications {
+		return
+	}
+	notify.Show("MSI Afterburner profile", fmt.Sprintf("Could not switch to %s: %v", profileName(profile), err))
+}
+
+// profileName returns a readable name for a profile flag, e.g. "Profile 3" for "-Profile3".
+func profileName(profile string) string {
+	if n, err := afterburner.ParseProfile(profile); err == nil {
+		return fmt.Sprintf("Profile %d", n)
+	}
+	return strings.TrimPrefix(profile, "-")
+}
+
+// notifySwitch shows a toast for a successful profile switch when notifications are enabled.
+// match is the zero Match when switching to profile_off.
+func notifySwitch(cfg *config.Config, profile string, match watcher.Match) {
+	if !cfg.Notifications {
+		return
+	}
+	name := profileName(profile)
+	message := fmt.Sprintf("Switched to %s because no targets are active.", name)
+	if rule := matchedRuleName(match); rule != "" {
+		message = fmt.Sprintf("Switched to %s for rule '%s' ('%s', %s).", name, rule, match.Keyword, match.Source)
+	} else if match.Keyword != "" {
+		message = fmt.Sprintf("Switched to %s for '%s' (%s).", name, match.Keyword, match.Source)
+	}
+	notify.Show("MSI Afterburner profile", message)
+}
+
+// liveConfig holds the active configuration. A hot reload replaces it as a whole,
+// so handlers always see one complete config rather than a mix of old and new settings.
+// Pausing is kept here too, so the handler picks up both kinds of change the same way.
+type liveConfig struct {
+	// mu serializes changes. Readers load the snapshot without locking, so nothing waits on
+	// a handler that is in the middle of a slow scan.
+	mu       sync.Mutex
+	snapshot atomic.Pointer[configSnapshot]
+	// recheck is the current profile handler, run after every change so it applies at once.
+	recheck func()
+}
+
+// configSnapshot is one version of the live configuration, with the targets built from it.
+// It is never modified once published; every change publishes a new one.
+type configSnapshot struct {
+	// base is the config as loaded; cfg is base with the active preset applied.
+	base    config.Config
+	cfg     config.Config
+	targets []watcher.Target
+	paused  bool
+	// held is the profile applied by the apply command, kept until it is released.
+	held    string
+	version int
+}
+
+func newLiveConfig(cfg config.Config) *liveConfig {
+	active, _ := cfg.WithPreset(cfg.Preset)
+	l := &liveConfig{}
+	l.snapshot.Store(&configSnapshot{base: cfg, cfg: active, targets: targets(&active)})
+	return l
+}
+
+// load returns the current snapshot.
+func (l *liveConfig) load() *configSnapshot {
+	return l.snapshot.Load()
+}
+
+func (l *liveConfig) get() (config.Config, bool, int) {
+	s := l.load()
+	return s.cfg, s.paused, s.version
+}
+
+func (l *liveConfig) set(cfg config.Config) {
+	setLogLevel(&cfg)
+	setHistorySize(cfg.HistorySize)
+	active, _ := cfg.WithPreset(cfg.Preset)
+	l.install(cfg, active)
+}
+
+// install publishes active as the config in effect, with base the config it was derived from.
+func (l *liveConfig) install(base, active config.Config) {
+	l.update(func(s *configSnapshot) { s.base, s.cfg, s.targets = base, active, targets(&active) })
+	reportRules(active.Rules)
+	reportPresets(&base, active.Preset)
+}
+
+// setRuleEnabled turns rule i on or off until the configuration is next loaded.
+func (l *liveConfig) setRuleEnabled(i int, enabled bool) {
+	s := l.load()
+	cfg := s.cfg
+	if i < 0 || i >= len(cfg.Rules) {
+		return
+	}
+	// The rules are shared with the config the handler may still be using, so change a copy.
+	cfg.Rules = slices.Clone(cfg.Rules)
+	cfg.Rules[i].Enabled = &enabled
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	logging.Infof("Rule %d (%s) %s until the configuration is reloaded.", i+1, ruleName(cfg.Rules[i]), state)
+	l.install(s.base, cfg)
+}
+
+func (l *liveConfig) setPaused(paused bool) {
+	if paused {
+		logging.Infof("Profile switching paused.")
+	} else {
+		logging.Infof("Profile switching resumed.")
+	}
+	l.update(func(s *configSnapshot) { s.paused = paused })
+}
+
+func (l *liveConfig) setRecheck(recheck func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recheck = recheck
+}
+
+// runRecheck runs the current profile handler, if there is one yet.
+func (l *liveConfig) runRecheck() {
+	l.mu.Lock()
+	recheck := l.recheck
+	l.mu.Unlock()
+	if recheck != nil {
+		recheck()
+	}
+}
+
+// periodicCheckInterval is how often rules that do not depend on window events,
+// such as temperature rules, are re-evaluated.
+const periodicCheckInterval = 5 * time.Second
+
+// startPeriodicChecks re-evaluates the profile on a timer while the config has rules that
+// can change without any window event, so event mode still reacts to them. A schedule
+// boundary is therefore noticed within periodicCheckInterval.
+func startPeriodicChecks(live *liveConfig) {
+	go func() {
+		ticker := time.NewTicker(periodicCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if cfg, _, _ := live.get(); len(cfg.TemperatureRules) > 0 || len(cfg.Schedules) > 0 || len(cfg.PowerRules) > 0 {
+				live.runRecheck()
+			}
+		}
+	}()
+}
+
+// update applies change to a copy of the current snapshot, publishes the copy with a new
+// version and runs the handler.
+func (l *liveConfig) update(change func(s *configSnapshot)) {
+	l.mu.Lock()
+	next := *l.load()
+	change(&next)
+	next.version++
+	l.snapshot.Store(&next)
+	l.mu.Unlock()
+	l.runRecheck()
+}
+
+// reportStatus shows the current target and profile, in the tray when it is enabled.
+var reportStatus = func(string) {}
+
+// reportRules lists the rules and whether they are enabled, in the tray when it is enabled.
+var reportRules = func([]config.Rule) {}
+
+// reportHealth shows whether the watcher is delivering events, in the tray when it is enabled.
+var reportHealth = func(bool) {}
+
+// healthCheckInterval is how often watchHealth checks the watcher.
+const healthCheckInterval = 10 * time.Second
+
+// watchHealth logs when the watcher stops or starts delivering events again, and reports it.
+func watchHealth() {
+	healthy := true
+	for range time.Tick(healthCheckInterval) {
+		h := watcher.Healthy()
+		if h == healthy {
+			continue
+		}
+		healthy = h
+		if h {
+			logging.Infof("The watcher is receiving system events again.")
+		} else {
+			logging.Warnf("The watcher has not received a system event since %s.", lastEventText())
+		}
+		reportHealth(h)
+	}
+}
+
+// lastEventText describes when the watcher last received an event.
+func lastEventText() string {
+	if t := watcher.LastEventTime(); !t.IsZero() {
+		return t.Format(time.TimeOnly)
+	}
+	return "it started"
+}
+
+// ruleName describes a rule by its name, or by its keywords if it has none, for the log and the tray.
+func ruleName(rule config.Rule) string {
+	if rule.Name != "" {
+		return rule.Name
+	}
+	return strings.Join(rule.AllKeywords(), ", ")
+}
+
+// matchedRuleName returns the name of the rule a match came from, or "" if it came from an
+// unnamed rule or from overrides.
+func matchedRuleName(match watcher.Match) string {
+	if rule, ok := match.Tag.(*config.Rule); ok {
+		return rule.Name
+	}
+	return ""
+}
+
+// trayRules converts the config rules into tray menu entries.
+func trayRules(rules []config.Rule) {
+	items := make([]tray.Rule, len(rules))
+	for i, rule := range rules {
+		items[i] = tray.Rule{Name: fmt.Sprintf("%s (%s)", ruleName(rule), rule.Profile), Enabled: rule.IsEnabled()}
+	}
+	tray.SetRules(items)
+}
+
+// statusText describes the active target and profile for the tray.
+func statusText(match watcher.Match, profile string, paused bool) string {
+	text := "No active target"
+	if match.Keyword != "" {
+		text = fmt.Sprintf("'%s' active", match.Keyword)
+	}
+	if n, err := afterburner.ParseProfile(profile); err == nil {
+		text += fmt.Sprintf(", Profile %d", n)
+	}
+	if paused {
+		text += " (paused)"
+	}
+	return text
+}
+
+// overridingMatch returns the first rule that wins over the targets. Temperature rules are a
+// safeguard, so they win over everything; schedules come next, so quiet hours hold even while
+// a game runs, and then power and display rules with override_targets.
+func overridingMatch(cfg *config.Config, temperature *temperatureGuard, power *powerGuard) (watcher.Match, bool) {
+	if match, ok := temperature.check(cfg.TemperatureRules); ok {
+		return match, true
+	}
+	if match, ok := scheduleMatch(cfg.Schedules, time.Now()); ok {
+		return match, true
+	}
+	if match, ok := power.check(cfg.PowerRules, true); ok {
+		return match, true
+	}
+	return displayMatch(cfg.DisplayRules, externalDisplay.Load(), true)
+}
+
+// idleMatch returns the power or display rule without override_targets that stands in for
+// profile_off when no target is active.
+func idleMatch(cfg *config.Config, power *powerGuard) (watcher.Match, bool) {
+	if match, ok := power.check(cfg.PowerRules, false); ok {
+		return match, true
+	}
+	return displayMatch(cfg.DisplayRules, externalDisplay.Load(), false)
+}
+
+// newProfileHandler returns the handler shared by all monitoring modes. Profiles are only
+// re-evaluated when the active target changes or the configuration has been reloaded.
+// It runs the handler once before returning, so a game that is already running gets its
+// profile at startup instead of on the first window event. That first run goes through the
+// same tracker, so the first real event for the same target does not apply it again.
+func newProfileHandler(live *liveConfig) func() {
+	var cfg config.Config
+	var paused bool
+	var holding string
+	// released is set while the handler applies the profile wanted after a hold is released.
+	var released bool
+	seenVersion := -1
 	var currentProfile string
-	checkStateAndApplyProfile(&cfg, &currentProfile)
-	ticker := time.NewTicker(time.Duration(cfg.DelaySeconds) * time.Second)
-	defer ticker.Stop()
-	for range ticker.C {
-		reloadedCfg := config.Load()
-		cfg.ProfileOn = reloadedCfg.ProfileOn
-		cfg.ProfileOff = reloadedCfg.ProfileOff
-		cfg.Overrides = reloadedCfg.Overrides
-		cfg.AfterburnerPath = reloadedCfg.AfterburnerPath
-		checkStateAndApplyProfile(&cfg, &currentProfile)
+	temperature := newTemperatureGuard()
+	var power powerGuard
+	var lastConflict string
+	var restored bool
+	// ruleTargets are the targets of the snapshot cfg was taken from.
+	var ruleTargets []watcher.Target
+	// lastKeyword is the last keyword match, which lets an unchanged foreground window skip matching.
+	var lastKeyword watcher.Match
+	// lastForeground is the foreground window last checked, so onUnmatchedForeground is only
+	// called when it changes.
+	var lastForeground watcher.ForegroundInfo
+	// suppressed counts switches held back by a cooldown; suppressing is the one being held back now.
+	var suppressed int
+	var suppressing *watcher.Match
+	// limiter caps the switch rate; held is the latest switch it is holding back.
+	var limiter switchLimiter
+	var held *heldSwitch
+	// switchTo applies profile, or only logs it while switching is paused, held or too frequent.
+	switchTo := func(profile, reason string, match watcher.Match) {
+		suppressing = nil
+		switch {
+		case holding != "":
+			if profile != holding {
+				logging.Infof("Holding profile %s: would apply profile %s. Reason: %s", holding, profile, reason)
+			}
+		case paused:
+			if profile != currentProfile {
+				logging.Infof("Paused: would apply profile %s. Reason: %s", profile, reason)
+			}
+		case profile == currentProfile:
+			held = nil
+		default:
+			now := time.Now()
+			allowed, wait := limiter.take(cfg.SwitchLimit, time.Duration(cfg.SwitchLimitMs)*time.Millisecond, now)
+			if !allowed {
+				if held == nil {
+					logging.Warnf("Profile switches are limited to %d every %dms. Holding profile %s for %v. Reason: %s", cfg.SwitchLimit, cfg.SwitchLimitMs, profile, wait.Round(time.Millisecond), reason)
+					held = &heldSwitch{retryAt: now.Add(wait)}
+					time.AfterFunc(wait, live.runRecheck)
+				} else if held.profile != profile {
+					logging.Infof("Profile switches are limited: dropping the held switch to %s for %s.", held.profile, profile)
+				}
+				held.profile, held.reason, held.match = profile, reason, match
+				break
+			}
+			held = nil
+			applyProfile(&cfg, profile, reason, match, &currentProfile, !released)
+		}
+		publishStatus(match, currentProfile, paused || holding != "")
+	}
+	// detect applies the rules in precedence order.
+	detect := func() (watcher.Match, bool) {
+		if match, ok := overridingMatch(&cfg, temperature, &power); ok {
+			return match, true
+		}
+		match, ok := watcher.FirstActiveSince(lastKeyword, ruleTargets, matchOptions(&cfg))
+		lastKeyword = match
+		if ok && match.Source == watcher.SourceForeground {
+			lastForeground = watcher.ForegroundInfo{HWND: match.HWND, PID: match.PID, ExePath: match.ExePath, Title: match.WindowTitle}
+		} else if fg, found := watcher.CurrentForeground(); found && (fg.HWND != lastForeground.HWND || fg.Title != lastForeground.Title) {
+			lastForeground = fg
+			onUnmatchedForeground(&cfg, fg.ExePath, fg.Title)
+		}
+		if ok {
+			logging.DebugfCollapsed("Detected target '%s' via %s (pid %d, exe %q, title %q).", match.Keyword, match.Source, match.PID, match.ExePath, match.WindowTitle)
+			// Listing every active target costs a second scan, so it is only done for the debug log.
+			if logging.Enabled(logging.LevelDebug) {
+				conflict := conflictSummary(&cfg, watcher.AllActive(ruleTargets, matchOptions(&cfg)))
+				if conflict != "" && conflict != lastConflict {
+					logging.Debugf("Several active targets select different profiles: %s. The first one wins; reorder 'rules' or set 'priority' to change this.", conflict)
+				}
+				lastConflict = conflict
+			}
+			return match, true
+		}
+		logging.DebugfCollapsed("No target detected.")
+		return idleMatch(&cfg, &power)
+	}
+	onMatch := func(match watcher.Match) {
+		switchTo(profileForMatch(&cfg, match), matchReason(match), match)
+	}
+	onIdle := func() {
+		if cfg.ProfileOff == "" {
+			suppressing = nil
+			logging.Infof("No active targets found. Keeping the current profile because 'profile_off' is empty.")
+			publishStatus(watcher.Match{}, currentProfile, paused || holding != "")
+			return
+		}
+		switchTo(cfg.ProfileOff, "No active targets found.", watcher.Match{})
 	}
+	tracker := watcher.NewMatchIdleTracker(
+		func() (watcher.Match, bool) {
+			match, ok := detect()
+			if ok {
+				matchesFound.Add(1)
+			}
+			match.ExternalDisplay = externalDisplay.Load()
+			return match, ok
+		},
+		onMatch, onIdle,
+	)
+	tracker.Preempts = func(cur, last watcher.Match) bool { return preempts(&cfg, cur, last) }
+	tracker.Reverting = reverts
+	tracker.Suppressed = func(cur watcher.Match, remaining time.Duration) {
+		if suppressing != nil && suppressing.SameTarget(cur) {
+			return
+		}
+		suppressing = &cur
+		suppressed++
+		next := "no active targets"
+		if cur.Keyword != "" {
+			next = fmt.Sprintf("target '%s'", cur.Keyword)
+		}
+		logging.Infof("Cooldown: keeping profile %s for another %s instead of switching for %s (%d switches suppressed so far).", currentProfile, remaining.Round(100*time.Millisecond), next, suppressed)
+	}
+	// supersedeApply asks the tracker, from the event thread, whether a switch in progress is
+	// still wanted. It only calls this while the handler is blocked in that switch.
+	setWantedCheck(func() (string, bool) {
+		match, ok := tracker.Supersedes()
+		if !ok {
+			return "", false
+		}
+		if match.Keyword == "" {
+			return cfg.ProfileOff, true
+		}
+		return profileForMatch(&cfg, match), true
+	})
+	// The handler can also be called from dwell timers, so it is serialized here.
+	var mu sync.Mutex
+	handler := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if shuttingDown.Load() {
+			if !restored {
+				restored = true
+				latest, _, _ := live.get()
+				restoreOnExit(&latest, currentProfile)
+			}
+			return
+		}
+		if snap := live.load(); snap.version != seenVersion {
+			released = holding != "" && snap.held == ""
+			cfg, paused, holding, seenVersion, ruleTargets = snap.cfg, snap.paused, snap.held, snap.version, snap.targets
+			lastKeyword = watcher.Match{}
+			tracker.RevertGrace = time.Duration(cfg.RevertGraceMs) * time.Millisecond
+			tracker.Reset()
+			if holding != "" && holding != currentProfile {
+				applyProfile(&cfg, holding, "Manual hold requested.", watcher.Match{}, &currentProfile, false)
+				publishStatus(watcher.Match{}, currentProfile, true)
+			}
+		}
+		if held != nil && !time.Now().Before(held.retryAt) {
+			h := *held
+			held = nil
+			switchTo(h.profile, h.reason, h.match)
+		}
+		tracker.Check()
+		released = false
+		if takeSuperseded() {
+			// The tracker has the cancelled match as its last, and would now forward the newer
+			// one, so check again at once. If the cancelled target is back by then, nothing is
+			// forwarded, and its profile, which was never applied, is applied here instead.
+			cancelled := tracker.Last()
+			tracker.Check()
+			for takeSuperseded() {
+				cancelled = tracker.Last()
+				tracker.Check()
+			}
+			if last := tracker.Last(); currentProfile == "" && last.SameTarget(cancelled) {
+				if last.Keyword != "" {
+					onMatch(last)
+				} else {
+					onIdle()
+				}
+			}
+		}
+	}
+	tracker.Recheck = handler
+	live.setRecheck(handler)
+	handler()
+	return handler
+}
+
+// startPollingMode runs the application by checking for targets on a timer.
+func startPollingMode(ctx context.Context, live *liveConfig) {
+	logging.Infof("Starting in Polling Mode.")
+	cfg, _, _ := live.get()
+	handler := newProfileHandler(live)
+	<-watcher.StartPollWatcherJitter(ctx, time.Duration(cfg.DelaySeconds)*time.Second, float64(cfg.PollJitterPercent)/100, handler)
 }
 
 // startEventMode runs the application by listening for system events.
-func startEventMode(cfg config.Config) {
-	log.Println("Starting in Event-Driven Mode.")
-	var currentProfile string
-	eventHandler := func() {
-		reloadedCfg := config.Load()
-		cfg.ProfileOn = reloadedCfg.ProfileOn
-		cfg.ProfileOff = reloadedCfg.ProfileOff
-		cfg.Overrides = reloadedCfg.Overrides
-		cfg.AfterburnerPath = reloadedCfg.AfterburnerPath
+func startEventMode(ctx context.Context, live *liveConfig) {
+	startEventWatching(ctx, live, false)
+}
+
+// startTimerMode runs the application by listening for system events, with a re-scan every
+// delay_seconds driven by a timer on the event watcher's own thread.
+func startTimerMode(ctx context.Context, live *liveConfig) {
+	startEventWatching(ctx, live, true)
+}
+
+// startEventWatching runs the event watcher for event mode, or with timed set, timer mode.
+func startEventWatching(ctx context.Context, live *liveConfig, timed bool) {
+	if err := watcher.InitWatcher(); err != nil {
+		logging.Warnf("%v. Using polling mode instead.", err)
+		startPollingMode(ctx, live)
+		return
+	}
+	cfg, _, _ := live.get()
+	rescan := time.Duration(cfg.DelaySeconds) * time.Second
+	if timed {
+		logging.Infof("Starting in Timer Mode, re-scanning every %v.", rescan)
+	} else {
+		logging.Infof("Starting in Event-Driven Mode.")
+	}
+	handler := newProfileHandler(live)
+	async := watcher.Async(ctx, time.Duration(cfg.HandlerTimeoutMs)*time.Millisecond, handler)
+	debounced := watcher.Debounce(time.Duration(cfg.DebounceMs)*time.Millisecond, async)
+	onEvent := func() {
+		supersedeApply()
+		debounced()
+	}
+	// The supervisor replaces a watcher whose hooks have silently stopped delivering events.
+	start := func(ctx context.Context) <-chan error {
+		if timed {
+			return watcher.StartTimedEventWatcher(ctx, rescan, onEvent)
+		}
+		return watcher.StartEventWatcherContext(ctx, onEvent)
+	}
+	if err := <-watcher.Supervise(ctx, start, watcher.LastEventTime); err != nil {
+		logging.Infof("Event watcher stopped: %v. Falling back to polling mode.", err)
+		startPollingMode(ctx, live)
+	}
+}
 
-		checkStateAndApplyProfile(&cfg, &currentProfile)
+// startHybridMode runs the event hooks backed by a low-frequency safety poll.
+func startHybridMode(ctx context.Context, live *liveConfig) {
+	if err := watcher.InitWatcher(); err != nil {
+		logging.Warnf("%v. Using polling mode instead.", err)
+		startPollingMode(ctx, live)
+		return
+	}
+	logging.Infof("Starting in Hybrid Mode.")
+	cfg, _, _ := live.get()
+	handler := newProfileHandler(live)
+	async := watcher.Async(ctx, time.Duration(cfg.HandlerTimeoutMs)*time.Millisecond, handler)
+	debounced := watcher.Debounce(time.Duration(cfg.DebounceMs)*time.Millisecond, async)
+	onEvent := func() {
+		supersedeApply()
+		debounced()
 	}
-	eventHandler()
-	watcher.StartEventWatcher(eventHandler)
-	select {}
+	<-watcher.StartHybridWatcher(ctx, watcher.DefaultSafetyPollInterval, watcher.DefaultHeartbeatTimeout, onEvent)
 }
 
 func main() {
+	flag.Parse()
 	log.SetFlags(log.Ltime)
-	cfg := config.Load()
-	// log.Println("Configuration loaded.")
-	switch strings.ToLower(cfg.MonitoringMode) {
+	if flag.Arg(0) == "list" {
+		if err := printList(); err != nil {
+			logging.Errorf("%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	configFile = *configFlag
+	if configFile == "" {
+		configFile = config.Path()
+	}
+	if flag.Arg(0) == "diagnose" {
+		if !diagnose() {
+			os.Exit(1)
+		}
+		return
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		logging.Errorf("%v", err)
+		os.Exit(1)
+	}
+	if flag.Arg(0) == "migrate" {
+		if err := printMigratedRules(&cfg); err != nil {
+			logging.Errorf("%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	setLogLevel(&cfg)
+	setHistorySize(cfg.HistorySize)
+	if command := flag.Arg(0); command == "status" || command == "history" || command == "preset" || command == "apply" || command == "release" {
+		if cfg.StatusAddr == "" {
+			logging.Errorf("'status_addr' is empty in %s, so the running instance has no status endpoint.", configFile)
+			os.Exit(1)
+		}
+		show := printStatus
+		switch command {
+		case "history":
+			show = printHistory
+		case "preset":
+			show = func(addr string) error { return requestPreset(addr, flag.Arg(1)) }
+		case "apply", "release":
+			show = func(addr string) error { return requestHold(addr, flag.Arg(1), command == "release") }
+		}
+		if err := show(cfg.StatusAddr); err != nil {
+			logging.Errorf("%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// A single -once check does not stay resident, so it neither needs nor claims the instance.
+	if !*once {
+		if err := acquireSingleInstance(); err != nil {
+			if errors.Is(err, errAlreadyRunning) {
+				logging.Errorf("%v. Exiting.", err)
+				showError("MSI Afterburner Script is already running. Check the notification area for its icon.")
+				os.Exit(1)
+			}
+			logging.Warnf("Cannot check for another running instance: %v", err)
+		}
+	}
+	openLogFile(&cfg)
+	if cfg.DryRun || *dryRun {
+		logging.Infof("Dry run: profiles will be logged but not applied.")
+	}
+	// logging.Infof("Configuration loaded.")
+	if _, err := os.Stat(cfg.AfterburnerPath); err != nil {
+		path, err := afterburner.FindAfterburnerExe()
+		if err != nil {
+			logging.Warnf("%s was not found and auto-detection failed: %v", cfg.AfterburnerPath, err)
+		} else {
+			logging.Infof("%s was not found. Using detected MSI Afterburner at %s.", cfg.AfterburnerPath, path)
+			detectedPath = path
+		}
+	}
+	if *once {
+		os.Exit(runOnce(cfg))
+	}
+	if cfg.LaunchAfterburner {
+		if err := afterburnerClient(&cfg, watcher.Match{}).EnsureAfterburnerRunning(); err != nil {
+			logging.Warnf("%v", err)
+		}
+	}
+	live := newLiveConfig(cfg)
+	if err := config.WatchFormat(context.Background(), configFile, configFileFormat(), live.set); err != nil {
+		logging.Warnf("Cannot watch %s for changes, edits will need a restart: %v", configFile, err)
+	}
+	startPeriodicChecks(live)
+	startDisplayWatcher(live)
+	if cfg.StatusAddr != "" {
+		startStatusServer(cfg.StatusAddr, live)
+	}
+	if cfg.MetricsAddr != "" {
+		startMetricsServer(cfg.MetricsAddr)
+	}
+	if cfg.PauseHotkey != "" {
+		startPauseHotkey(live, cfg.PauseHotkey)
+	}
+	if cfg.PresetHotkey != "" {
+		startPresetHotkey(live, cfg.PresetHotkey)
+	}
+	go watchHealth()
+	app := newShutdown(live)
+	app.watchForExit()
+	if !cfg.Tray {
+		app.run(cfg.MonitoringMode)
+		return
+	}
+	reportStatus = tray.SetStatus
+	reportRules = trayRules
+	reportPresets = trayPresets
+	reportHealth = tray.SetHealthy
+	snap := live.load()
+	trayRules(snap.cfg.Rules)
+	trayPresets(&snap.base, snap.cfg.Preset)
+	go func() {
+		app.run(cfg.MonitoringMode)
+		// After Quit, main exits once the tray icon has been removed.
+		if !shuttingDown.Load() {
+			os.Exit(0)
+		}
+	}()
+	tray.Run(tray.Actions{
+		Pause:        live.setPaused,
+		ToggleRule:   live.setRuleEnabled,
+		SelectPreset: live.selectTrayPreset,
+		Quit: func() {
+			app.shutdown("Quit was selected in the tray menu.")
+		},
+		Reload: func() {
+			cfg, err := loadConfig()
+			if err != nil {
+				logging.Warnf("Keeping the current configuration. %v", err)
+				return
+			}
+			logging.Infof("Configuration reloaded from %s.", configFile)
+			live.set(cfg)
+		},
+	})
+}
+
+// startPauseHotkey registers the global shortcut that toggles pausing. A shortcut that is
+// invalid or taken by another application is logged and otherwise ignored.
+func startPauseHotkey(live *liveConfig, shortcut string) {
+	hotkey, err := watcher.ParseHotkey(shortcut)
+	if err != nil {
+		logging.Warnf("Configuration error in 'pause_hotkey', the hotkey is disabled. Details: %v", err)
+		return
+	}
+	errs := watcher.StartHotkeyWatcher(context.Background(), hotkey, func() {
+		_, paused, _ := live.get()
+		live.setPaused(!paused)
+		tray.SetPaused(!paused)
+	})
+	go func() {
+		if err := <-errs; err != nil {
+			logging.Warnf("Cannot use %s as the pause hotkey: %v", shortcut, err)
+		}
+	}()
+}
+
+// printList writes every running process and visible window, so their exact names and titles
+// can be copied into the config as keywords.
+func printList() error {
+	processes, err := watcher.ListProcesses()
+	if err != nil {
+		return fmt.Errorf("cannot list processes: %w", err)
+	}
+	fmt.Println("Processes:")
+	for _, p := range processes {
+		fmt.Printf("  %-40s pid %d\n", p.Executable, p.PID)
+	}
+	fmt.Println()
+	fmt.Println("Windows (* = foreground):")
+	for _, w := range watcher.ListWindows() {
+		mark := " "
+		if w.Foreground {
+			mark = "*"
+		}
+		fmt.Printf("%s %q  class %q  pid %d\n", mark, w.Title, w.Class, w.PID)
+	}
+	return nil
+}
+
+// printMigratedRules prints the overrides rewritten as rules, after the existing rules, for
+// pasting into the config file in place of its 'rules' and 'overrides'.
+func printMigratedRules(cfg *config.Config) error {
+	rules, kept := cfg.OverridesAsRules()
+	out, err := json.MarshalIndent(struct {
+		Rules     []config.Rule     `json:"rules"`
+		Overrides map[string]string `json:"overrides"`
+	}{append(slices.Clone(cfg.Rules), rules...), kept}, "", "    ")
+	if err != nil {
+		return fmt.Errorf("cannot convert the overrides: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// run starts the monitoring mode and blocks until ctx is cancelled.
+func run(ctx context.Context, live *liveConfig, mode string) {
+	switch strings.ToLower(mode) {
 	case "poll":
-		startPollingMode(cfg)
+		startPollingMode(ctx, live)
 	case "event":
-		startEventMode(cfg)
+		startEventMode(ctx, live)
+	case "hybrid":
+		startHybridMode(ctx, live)
+	case "timer":
+		startTimerMode(ctx, live)
 	default:
-		log.Fatalf("Invalid monitoring_mode %q in config.json", cfg.MonitoringMode)
+		logging.Errorf("Invalid monitoring_mode %q in %s. Using event mode.", mode, configFile)
+		startEventMode(ctx, live)
 	}
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/watcher"
+)
+
+func TestPreemptsSharedKeyword(t *testing.T) {
+	cfg := config.Config{Rules: []config.Rule{
+		{Keyword: "chrome", Profile: "-Profile2", Scope: []string{"title"}},
+		{Keyword: "chrome", Profile: "-Profile3", Scope: []string{"exe"}},
+	}}
+	byTitle := watcher.Match{Keyword: "chrome", Profile: "-Profile2", Tag: &cfg.Rules[0]}
+	byExe := watcher.Match{Keyword: "chrome", Profile: "-Profile3", Tag: &cfg.Rules[1]}
+	if !preempts(&cfg, byTitle, byExe) {
+		t.Error("the earlier title rule does not preempt the later exe rule with the same keyword")
+	}
+	if preempts(&cfg, byExe, byTitle) {
+		t.Error("the later exe rule preempts the earlier title rule with the same keyword")
+	}
+}
+
+// TestLiveConfigConcurrentUse reloads and changes the live config from several goroutines while
+// others read it and start, supersede and finish switches, as the watcher, tray, hotkeys and
+// status server do. Run it with -race.
+func TestLiveConfigConcurrentUse(t *testing.T) {
+	configs := make([]config.Config, 4)
+	for i := range configs {
+		profile := fmt.Sprintf("-Profile%d", i+1)
+		configs[i] = config.Config{LogLevel: "error", ProfileOff: profile, Rules: []config.Rule{{Keyword: fmt.Sprintf("game%d", i+1), Profile: profile}}}
+	}
+	live := newLiveConfig(configs[0])
+	// consistent reports how a snapshot mixes settings of different configs, if it does.
+	consistent := func(s *configSnapshot) error {
+		if s.base.ProfileOff != s.cfg.ProfileOff {
+			return fmt.Errorf("base profile_off %s with active %s", s.base.ProfileOff, s.cfg.ProfileOff)
+		}
+		if !s.cfg.Rules[0].IsEnabled() {
+			if len(s.targets) != 0 {
+				return fmt.Errorf("%d targets for a disabled rule", len(s.targets))
+			}
+			return nil
+		}
+		if len(s.targets) != 1 || s.targets[0].Profile != s.cfg.ProfileOff || s.targets[0].Tag != &s.cfg.Rules[0] {
+			return fmt.Errorf("targets %+v for profile_off %s", s.targets, s.cfg.ProfileOff)
+		}
+		return nil
+	}
+	var handled atomic.Int32
+	live.setRecheck(func() {
+		if err := consistent(live.load()); err != nil {
+			t.Errorf("handler: %v", err)
+		}
+		handled.Add(1)
+	})
+	setWantedCheck(func() (string, bool) { return live.load().cfg.ProfileOff, true })
+	defer setWantedCheck(nil)
+
+	const rounds = 200
+	var writers, readers sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for i := 0; i < rounds; i++ {
+				cfg := configs[(w+i)%len(configs)]
+				switch i % 5 {
+				case 0:
+					live.set(cfg)
+				case 1:
+					active, _ := cfg.WithPreset(cfg.Preset)
+					live.install(cfg, active)
+				case 2:
+					live.setPaused(i%2 == 0)
+				case 3:
+					live.setRuleEnabled(0, w%2 == 0)
+				case 4:
+					live.update(func(s *configSnapshot) { s.held = cfg.ProfileOff })
+				}
+			}
+		}()
+	}
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := consistent(live.load()); err != nil {
+					t.Errorf("reader: %v", err)
+				}
+				cfg, _, _ := live.get()
+				_, done := startApply(cfg.ProfileOff, r%2 == 0)
+				supersedeApply()
+				done()
+				takeSuperseded()
+			}
+		}()
+	}
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	if _, _, version := live.get(); version != 4*rounds {
+		t.Errorf("version %d after %d changes, want every change published", version, 4*rounds)
+	}
+	if n := handled.Load(); n != 4*rounds {
+		t.Errorf("handler ran %d times for %d changes", n, 4*rounds)
+	}
+}
+
+func TestDisabledRulesNeverMatch(t *testing.T) {
+	off, on := false, true
+	state := watcher.DetectionState{
+		Foreground: &watcher.ForegroundState{PID: 1, Title: "Cyberpunk 2077", ExePath: `C:\Games\Cyberpunk2077.exe`},
+		Processes:  []watcher.ProcessState{{PID: 1, Executable: "Cyberpunk2077.exe"}, {PID: 2, Executable: "steam.exe"}},
+		Windows:    []watcher.WindowInfo{{PID: 1, Title: "Cyberpunk 2077"}, {PID: 2, Title: "Steam"}},
+	}
+	tests := []struct {
+		name  string
+		rules []config.Rule
+		want  string
+	}{
+		{"enabled rule", []config.Rule{{Keyword: "cyberpunk", Profile: "-Profile2"}}, "-Profile2"},
+		{"explicitly enabled rule", []config.Rule{{Keyword: "cyberpunk", Profile: "-Profile2", Enabled: &on}}, "-Profile2"},
+		{"disabled rule", []config.Rule{{Keyword: "cyberpunk", Profile: "-Profile2", Enabled: &off}}, ""},
+		{"disabled rule with several keywords", []config.Rule{{Keywords: []string{"cyberpunk", "steam"}, Profile: "-Profile2", Enabled: &off}}, ""},
+		{"disabled rule before an enabled one", []config.Rule{
+			{Keyword: "cyberpunk", Profile: "-Profile2", Enabled: &off},
+			{Keyword: "steam", Profile: "-Profile3"},
+		}, "-Profile3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{Rules: tt.rules}
+			m, _ := watcher.Decide(state, targets(&cfg), watcher.Options{})
+			if m.Profile != tt.want {
+				t.Fatalf("matched %q for profile %q, want profile %q", m.Keyword, m.Profile, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireForeground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `profile_on: "-Profile5"
+profile_off: "-Profile1"
+monitoring_mode: event
+rules:
+  - keyword: Game
+    profile: "-Profile3"
+    require_foreground: true
+  - keyword: obs
+    profile: "-Profile2"
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processes := []watcher.ProcessState{{PID: 1, Executable: "game.exe"}, {PID: 2, Executable: "obs64.exe"}, {PID: 3, Executable: "explorer.exe"}}
+	tests := []struct {
+		name       string
+		foreground *watcher.ForegroundState
+		want       string
+	}{
+		{"game in the background", &watcher.ForegroundState{PID: 3, Title: "Downloads", ExePath: `C:\Windows\explorer.exe`}, "-Profile2"},
+		{"game in the foreground", &watcher.ForegroundState{PID: 1, Title: "Game", ExePath: `C:\Games\game.exe`}, "-Profile3"},
+		{"no foreground window", nil, "-Profile2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := watcher.DetectionState{Foreground: tt.foreground, Processes: processes}
+			if m, _ := watcher.Decide(state, targets(cfg), watcher.Options{}); m.Profile != tt.want {
+				t.Fatalf("matched %q for profile %q, want %q", m.Keyword, m.Profile, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleKeywordAlternatives(t *testing.T) {
+	cfg := config.Config{Rules: []config.Rule{
+		{Name: "media", Keywords: []string{"vlc media player", "spotify", "foobar2000"}, Profile: "-Profile1"},
+		{Keyword: "game", Profile: "-Profile3"},
+	}}
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Never Gonna Give You Up - VLC media player", "vlc media player"},
+		{"Spotify Premium", "spotify"},
+		{"Artist - Track [foobar2000]", "foobar2000"},
+		{"Game", "game"},
+		{"Notepad", ""},
+	}
+	for _, tt := range tests {
+		state := watcher.DetectionState{Windows: []watcher.WindowInfo{{PID: 1, Title: tt.title}}}
+		m, ok := watcher.Decide(state, targets(&cfg), watcher.Options{})
+		if m.Keyword != tt.want || ok != (tt.want != "") {
+			t.Errorf("%q matched %q, %v; want %q", tt.title, m.Keyword, ok, tt.want)
+			continue
+		}
+		if ok && tt.want != "game" && (m.Tag != &cfg.Rules[0] || m.Profile != "-Profile1" || matchedRuleName(m) != "media") {
+			t.Errorf("%q matched rule %q with profile %s, want the media rule", tt.title, matchedRuleName(m), m.Profile)
+		}
+	}
+}
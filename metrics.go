@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"MSIAfterburnerScript/afterburner"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/watcher"
+)
+
+// Counters exposed by the metrics endpoint. Events and hook re-arms are counted by the watcher.
+var (
+	matchesFound   atomic.Uint64
+	profileApplies atomic.Uint64
+	applyFailures  atomic.Uint64
+)
+
+// metricsAddr returns the address to listen on, binding to the loopback interface when addr
+// has no host, such as ":9477".
+func metricsAddr(addr string) string {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return addr
+}
+
+// startMetricsServer serves counters in the Prometheus text format at http://addr/metrics.
+func startMetricsServer(addr string) {
+	addr = metricsAddr(addr)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", serveMetrics)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			logging.Warnf("Metrics endpoint on %s stopped: %v", addr, err)
+		}
+	}()
+}
+
+func serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	events, rearms, restarts := watcher.EventStats()
+	statusMu.Lock()
+	profile := currentStatus.Profile
+	statusMu.Unlock()
+	// Custom commands and an empty profile have no number and are reported as 0.
+	current, _ := afterburner.ParseProfile(profile)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range []struct {
+		name, kind, help string
+		value            uint64
+	}{
+		{"msiab_events_received_total", "counter", "Window events received by the event watcher.", events},
+		{"msiab_matches_total", "counter", "Checks that found an active target.", matchesFound.Load()},
+		{"msiab_profile_applies_total", "counter", "Profile switches that succeeded.", profileApplies.Load()},
+		{"msiab_apply_failures_total", "counter", "Profile switches that failed.", applyFailures.Load()},
+		{"msiab_hook_rearms_total", "counter", "Times the event hooks were reinstalled after a resume.", rearms},
+		{"msiab_watcher_restarts_total", "counter", "Times the event watcher was restarted after a crash.", restarts},
+		{"msiab_current_profile", "gauge", "The Afterburner profile last applied, or 0 if none.", uint64(current)},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+}
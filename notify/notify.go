@@ -0,0 +1,51 @@
+// Package notify shows Windows toast notifications.
+package notify
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// appID is the AppUserModelID the toast is shown under. Toasts need a registered app ID,
+// and PowerShell's is present on every Windows install.
+const appID = `{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe`
+
+// toastScript builds and shows the toast. The title and message are read from environment
+// variables and XML-escaped, so they are never parsed as script or markup.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+[Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime] | Out-Null
+$title = [Security.SecurityElement]::Escape($env:NOTIFY_TITLE)
+$message = [Security.SecurityElement]::Escape($env:NOTIFY_MESSAGE)
+$xml = New-Object Windows.Data.Xml.Dom.XmlDocument
+$xml.LoadXml("<toast><visual><binding template=""ToastGeneric""><text>$title</text><text>$message</text></binding></visual></toast>")
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($env:NOTIFY_APP_ID).Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+`
+
+// pending counts the toasts that are still being handed to Windows.
+var pending sync.WaitGroup
+
+// Show displays a toast with title and message. It returns immediately; failures are logged
+// as warnings since a missing notification should never stop a profile switch.
+func Show(title, message string) {
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "NOTIFY_TITLE="+title, "NOTIFY_MESSAGE="+message, "NOTIFY_APP_ID="+appID)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		if out, err := cmd.CombinedOutput(); err != nil {
+			logging.Warnf("Cannot show notification: %v %s", err, out)
+		}
+	}()
+}
+
+// Wait blocks until the toasts shown so far have been handed to Windows, for callers that are
+// about to exit.
+func Wait() {
+	pending.Wait()
+}
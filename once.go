@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/notify"
+	"MSIAfterburnerScript/watcher"
+)
+
+// once is the -once command line flag, which checks and applies a profile a single time and exits.
+var once = flag.Bool("once", false, "evaluate the rules once like the resident tool (temperature, schedule, power and display rules, then targets), apply the profile (or profile_off) and exit, for Task Scheduler and scripts")
+
+// Exit codes of -once. They are documented in the README and must not change.
+const (
+	onceApplied  = 0 // a profile was applied, or would have been in a dry run
+	onceFailed   = 1 // the config is invalid or the profile could not be applied
+	onceNoChange = 2 // no target is active and profile_off is empty, so nothing was applied
+)
+
+// runOnce evaluates the rules in the same order as the profile handler, without starting a
+// watcher, and applies the profile they select, or profile_off when nothing applies. It waits
+// for the hooks and notifications of the switch, which the process exiting would cut short,
+// and returns the exit code. With no history to go on, a temperature rule only applies above
+// its above_c, not while the temperature is still above clear_below_c afterwards.
+func runOnce(cfg config.Config) int {
+	cfg, _ = cfg.WithPreset(cfg.Preset)
+	if len(cfg.DisplayRules) > 0 {
+		refreshDisplay()
+	}
+	var power powerGuard
+	match, found := overridingMatch(&cfg, newTemperatureGuard(), &power)
+	if !found {
+		match, found = watcher.FirstActive(targets(&cfg), matchOptions(&cfg))
+	}
+	if !found {
+		match, found = idleMatch(&cfg, &power)
+	}
+	profile, reason := profileForMatch(&cfg, match), matchReason(match)
+	if !found {
+		if cfg.ProfileOff == "" {
+			logging.Infof("No active targets found. Not applying a profile because 'profile_off' is empty.")
+			return onceNoChange
+		}
+		profile, reason = cfg.ProfileOff, "No active targets found."
+	}
+	var current string
+	applyProfile(&cfg, profile, reason, match, &current, false)
+	runningHooks.Wait()
+	notify.Wait()
+	if current != profile {
+		return onceFailed
+	}
+	return onceApplied
+}
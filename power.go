@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/watcher"
+)
+
+// powerGuard reads the power source for the power rules and warns once if it cannot.
+type powerGuard struct {
+	warned bool
+}
+
+// check returns a match for the power rule that applies to the current power source.
+// overriding selects between rules that win over targets and rules that only replace profile_off.
+func (g *powerGuard) check(rules []config.PowerRule, overriding bool) (watcher.Match, bool) {
+	if len(rules) == 0 {
+		return watcher.Match{}, false
+	}
+	onAC, err := watcher.OnACPower()
+	if err != nil {
+		if !g.warned {
+			logging.Warnf("Power rules are skipped while the power source cannot be read: %v", err)
+			g.warned = true
+		}
+		return watcher.Match{}, false
+	}
+	g.warned = false
+	return powerMatch(rules, onAC, overriding)
+}
+
+// powerMatch returns the first rule for the current power source whose override_targets equals overriding.
+func powerMatch(rules []config.PowerRule, onAC, overriding bool) (watcher.Match, bool) {
+	power := "battery"
+	if onAC {
+		power = "ac"
+	}
+	for _, rule := range rules {
+		if rule.Power == power && rule.OverrideTargets == overriding {
+			return watcher.Match{
+				Keyword: fmt.Sprintf("on %s power", power),
+				Profile: rule.Profile,
+				Source:  watcher.SourcePower,
+				Tag:     rule,
+			}, true
+		}
+	}
+	return watcher.Match{}, false
+}
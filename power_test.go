@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/watcher"
+)
+
+func TestPowerMatch(t *testing.T) {
+	rules := []config.PowerRule{
+		{Power: "ac", Profile: "-Profile5", OverrideTargets: true},
+		{Power: "battery", Profile: "-Profile1"},
+		{Power: "ac", Profile: "-Profile4"},
+	}
+	tests := []struct {
+		name        string
+		onAC        bool
+		overriding  bool
+		wantProfile string
+	}{
+		{"on AC, overriding rules", true, true, "-Profile5"},
+		{"on AC, rules for profile_off", true, false, "-Profile4"},
+		{"on battery, overriding rules", false, true, ""},
+		{"on battery, rules for profile_off", false, false, "-Profile1"},
+	}
+	for _, tt := range tests {
+		m, ok := powerMatch(rules, tt.onAC, tt.overriding)
+		if m.Profile != tt.wantProfile || ok != (tt.wantProfile != "") {
+			t.Errorf("%s: powerMatch = %q, %v; want %q", tt.name, m.Profile, ok, tt.wantProfile)
+		}
+		if ok && m.Source != watcher.SourcePower {
+			t.Errorf("%s: the match's source is %v, want power", tt.name, m.Source)
+		}
+	}
+}
+
+func TestPowerRulePrecedence(t *testing.T) {
+	cfg := config.Config{Rules: []config.Rule{{Keyword: "game", Profile: "-Profile3"}}}
+	game := watcher.Match{Keyword: "game", Profile: "-Profile3", Source: watcher.SourceProcess, Tag: &cfg.Rules[0]}
+	overriding, _ := powerMatch([]config.PowerRule{{Power: "ac", Profile: "-Profile5", OverrideTargets: true}}, true, true)
+	idle, _ := powerMatch([]config.PowerRule{{Power: "battery", Profile: "-Profile1"}}, false, false)
+	tests := []struct {
+		name        string
+		cur, last   watcher.Match
+		wantPreempt bool
+		wantRevert  bool
+	}{
+		{"overriding rule takes over from a target", overriding, game, true, false},
+		{"rule for profile_off does not take over from a target", idle, game, false, true},
+		{"target after an overriding rule", game, overriding, false, false},
+		{"target after a rule for profile_off", game, idle, false, false},
+		{"no target after an overriding rule", watcher.Match{}, overriding, false, false},
+		{"no target after a target", watcher.Match{}, game, false, true},
+	}
+	for _, tt := range tests {
+		if got := preempts(&cfg, tt.cur, tt.last); got != tt.wantPreempt {
+			t.Errorf("%s: preempts = %v, want %v", tt.name, got, tt.wantPreempt)
+		}
+		if got := reverts(tt.cur, tt.last); got != tt.wantRevert {
+			t.Errorf("%s: reverts = %v, want %v", tt.name, got, tt.wantRevert)
+		}
+	}
+}
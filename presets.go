@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/tray"
+	"MSIAfterburnerScript/watcher"
+)
+
+// defaultPreset is how the top-level rule set is named on the command line and in the log.
+const defaultPreset = "default"
+
+// presetChoices lists the rule sets that can be selected: the top-level one, as "", and then
+// the presets in alphabetical order.
+func presetChoices(cfg *config.Config) []string {
+	return append([]string{""}, cfg.PresetNames()...)
+}
+
+// presetTitle names a rule set for the log and the tray.
+func presetTitle(name string) string {
+	if name == "" {
+		return defaultPreset
+	}
+	return name
+}
+
+// setPreset makes the named preset's rule set active, or the top-level one for "", until the
+// configuration is next loaded, and re-evaluates the profile at once.
+func (l *liveConfig) setPreset(name string) error {
+	base := l.load().base
+	active, ok := base.WithPreset(name)
+	if !ok {
+		return fmt.Errorf("there is no preset named %q (presets: %s)", name, strings.Join(base.PresetNames(), ", "))
+	}
+	logging.Infof("Switched to the %s rule set until the configuration is reloaded.", presetTitle(name))
+	l.install(base, active)
+	return nil
+}
+
+// nextPreset switches to the rule set after the active one, wrapping around.
+func (l *liveConfig) nextPreset() {
+	s := l.load()
+	choices := presetChoices(&s.base)
+	next := choices[(slices.Index(choices, s.cfg.Preset)+1)%len(choices)]
+	if err := l.setPreset(next); err != nil {
+		logging.Warnf("%v", err)
+	}
+}
+
+// reportPresets lists the rule sets and the active one, in the tray when it is enabled.
+var reportPresets = func(base *config.Config, active string) {}
+
+// trayPresets shows the rule sets in the tray's Presets menu.
+func trayPresets(base *config.Config, active string) {
+	choices := presetChoices(base)
+	if len(choices) == 1 {
+		tray.SetPresets(nil, 0)
+		return
+	}
+	titles := make([]string, len(choices))
+	for i, name := range choices {
+		titles[i] = presetTitle(name)
+	}
+	tray.SetPresets(titles, slices.Index(choices, active))
+}
+
+// selectTrayPreset switches to the rule set picked by its index in the Presets menu.
+func (l *liveConfig) selectTrayPreset(index int) {
+	s := l.load()
+	if choices := presetChoices(&s.base); index >= 0 && index < len(choices) {
+		if err := l.setPreset(choices[index]); err != nil {
+			logging.Warnf("%v", err)
+		}
+	}
+}
+
+// servePreset switches the running instance to the preset named by the "name" parameter.
+func servePreset(w http.ResponseWriter, r *http.Request, live *liveConfig) {
+	name := r.FormValue("name")
+	if name == defaultPreset {
+		if _, ok := live.load().base.Presets[name]; !ok {
+			name = ""
+		}
+	}
+	if err := live.setPreset(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fmt.Fprintf(w, "Switched to the %s rule set.\n", presetTitle(name))
+}
+
+// requestPreset asks the running instance to switch to the named preset.
+func requestPreset(addr, name string) error {
+	if name == "" {
+		return fmt.Errorf("name the preset to switch to, or %q for the top-level rules", defaultPreset)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := postForm(client, "http://"+addr+"/preset", url.Values{"name": {name}})
+	if err != nil {
+		return fmt.Errorf("cannot reach the running instance on %s (is it running with 'status_addr' set?): %w", addr, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot switch presets: %s", strings.TrimSpace(string(body)))
+	}
+	fmt.Print(string(body))
+	return nil
+}
+
+// startPresetHotkey registers the global shortcut that cycles through the rule sets.
+func startPresetHotkey(live *liveConfig, shortcut string) {
+	hotkey, err := watcher.ParseHotkey(shortcut)
+	if err != nil {
+		logging.Warnf("Configuration error in 'preset_hotkey', the hotkey is disabled. Details: %v", err)
+		return
+	}
+	errs := watcher.StartHotkeyWatcher(context.Background(), hotkey, live.nextPreset)
+	go func() {
+		if err := <-errs; err != nil {
+			logging.Warnf("Cannot use %s as the preset hotkey: %v", shortcut, err)
+		}
+	}()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+
+	"MSIAfterburnerScript/watcher"
+)
+
+// switchLimiter is a token bucket that caps how often profiles are switched, whatever the
+// rules decide, as a safety net against rapid switching.
+type switchLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// take reports whether a switch may happen at now under a limit of burst switches per window,
+// using up a token if so. Otherwise it returns how long until the next token is available.
+// A limit of 0 allows every switch.
+func (l *switchLimiter) take(burst int, window time.Duration, now time.Time) (bool, time.Duration) {
+	if burst <= 0 || window <= 0 {
+		return true, 0
+	}
+	// rate is in tokens per nanosecond; a full bucket refills over one window.
+	rate := float64(burst) / float64(window)
+	if l.last.IsZero() {
+		l.tokens = float64(burst)
+	} else {
+		l.tokens = min(float64(burst), l.tokens+float64(now.Sub(l.last))*rate)
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / rate)
+}
+
+// heldSwitch is the most recent switch held back by the limiter, applied once it allows it.
+type heldSwitch struct {
+	profile string
+	reason  string
+	match   watcher.Match
+	// retryAt is when the limiter has a token again and the handler is run to apply it.
+	retryAt time.Time
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"MSIAfterburnerScript/afterburner"
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/watcher"
+)
+
+// currentRTSS is the RTSS profile last applied.
+var currentRTSS string
+
+// rtssProfileFor returns the RTSS profile for a match: the matched rule's rtss_profile, or
+// rtss_profile_off for everything else. It is "" when RTSS should be left alone.
+func rtssProfileFor(cfg *config.Config, match watcher.Match) string {
+	if rule, ok := match.Tag.(*config.Rule); ok && rule.RTSSProfile != "" {
+		return rule.RTSSProfile
+	}
+	return cfg.RTSSProfileOff
+}
+
+// applyRTSS switches RivaTuner Statistics Server to the profile for match, after the
+// Afterburner profile has been applied. A failure is logged but does not undo that switch.
+func applyRTSS(cfg *config.Config, match watcher.Match) {
+	name := rtssProfileFor(cfg, match)
+	if name == "" || name == currentRTSS {
+		return
+	}
+	if cfg.DryRun || *dryRun {
+		logging.Infof("[dry-run] would apply RTSS profile '%s'", name)
+		currentRTSS = name
+		return
+	}
+	if err := afterburner.ApplyRTSSProfile(cfg.RTSSPath, name); err != nil {
+		logging.Errorf("Failed to apply RTSS profile '%s': %v", name, err)
+		return
+	}
+	logging.Infof("Successfully applied RTSS profile: %s", name)
+	currentRTSS = name
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/watcher"
+)
+
+// scheduleMatch returns a match for the schedule that applies at now, if any.
+func scheduleMatch(schedules []config.Schedule, now time.Time) (watcher.Match, bool) {
+	i := activeSchedule(schedules, now)
+	if i < 0 {
+		return watcher.Match{}, false
+	}
+	s := schedules[i]
+	return watcher.Match{
+		Keyword: fmt.Sprintf("schedule %s-%s", s.Start, s.End),
+		Profile: s.Profile,
+		Source:  watcher.SourceSchedule,
+	}, true
+}
+
+// activeSchedule returns the index of the first listed schedule whose window contains now,
+// or -1. Windows include their start minute and exclude their end minute.
+func activeSchedule(schedules []config.Schedule, now time.Time) int {
+	minute := now.Hour()*60 + now.Minute()
+	for i, s := range schedules {
+		if start, end := s.Minutes(); inWindow(minute, start, end) {
+			return i
+		}
+	}
+	return -1
+}
+
+// inWindow reports whether minute falls in [start, end), wrapping past midnight when end < start.
+func inWindow(minute, start, end int) bool {
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/watcher"
+)
+
+// shuttingDown is set once the application has started to exit. From then on the profile
+// handler stops switching and only restores profile_off.
+var shuttingDown atomic.Bool
+
+// shutdownTimeout bounds how long exiting waits for the watchers to remove their hooks.
+const shutdownTimeout = 2 * time.Second
+
+// shutdown stops the monitoring mode and restores the default profile when the application exits.
+type shutdown struct {
+	live     *liveConfig
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+	finished chan struct{}
+	once     sync.Once
+}
+
+func newShutdown(live *liveConfig) *shutdown {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &shutdown{live: live, ctx: ctx, cancel: cancel, stopped: make(chan struct{}), finished: make(chan struct{})}
+}
+
+// run runs the monitoring mode until it stops. If it was stopped by a shutdown, run also
+// waits for the shutdown to finish, so returning from main cannot cut the restore short.
+func (s *shutdown) run(mode string) {
+	run(s.ctx, s.live, mode)
+	close(s.stopped)
+	if s.ctx.Err() != nil {
+		<-s.finished
+	}
+}
+
+// watchForExit exits cleanly on Ctrl+C, when the console is closed, and when Windows ends the
+// session or asks the application to close.
+func (s *shutdown) watchForExit() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		s.shutdown(fmt.Sprintf("Received %v.", sig))
+		os.Exit(0)
+	}()
+	errs := watcher.StartSessionWatcher(context.Background(), func() {
+		s.shutdown("Windows is ending the session.")
+		os.Exit(0)
+	})
+	go func() {
+		if err := <-errs; err != nil {
+			logging.Warnf("Logging off will not restore the default profile: %v", err)
+		}
+	}()
+}
+
+// shutdown stops the watchers, waits briefly for their hooks to be removed and then restores
+// profile_off through the profile handler. Only the first call does anything; later calls
+// wait for it to finish.
+func (s *shutdown) shutdown(reason string) {
+	s.once.Do(func() {
+		defer close(s.finished)
+		logging.Infof("Exiting. Reason: %s", reason)
+		shuttingDown.Store(true)
+		s.cancel()
+		select {
+		case <-s.stopped:
+		case <-time.After(shutdownTimeout):
+			logging.Warnf("The watchers did not stop within %v.", shutdownTimeout)
+		}
+		s.live.runRecheck()
+	})
+	<-s.finished
+}
+
+// restoreOnExit applies profile_off when restore_on_exit is set and it is not already active.
+func restoreOnExit(cfg *config.Config, currentProfile string) {
+	if !cfg.RestoreOnExit || cfg.ProfileOff == "" {
+		return
+	}
+	// The process is about to exit, so a notification would never be seen.
+	cfg.Notifications = false
+	applyProfile(cfg, cfg.ProfileOff, "Restoring the default profile on exit.", watcher.Match{}, &currentProfile, false)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"MSIAfterburnerScript/afterburner"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/watcher"
+)
+
+// status is the state returned by the status endpoint.
+type status struct {
+	Active      bool   `json:"active"`
+	Keyword     string `json:"keyword,omitempty"`
+	Rule        string `json:"rule,omitempty"`
+	Source      string `json:"source,omitempty"`
+	PID         uint32 `json:"pid,omitempty"`
+	ExePath     string `json:"exe_path,omitempty"`
+	WindowTitle string `json:"window_title,omitempty"`
+	// DisplayState is how the matched foreground window is shown, e.g. "borderless".
+	DisplayState string `json:"display_state,omitempty"`
+	Profile      string `json:"profile"`
+	Paused       bool   `json:"paused"`
+	// HeldProfile is the profile applied with the apply command, until it is released.
+	HeldProfile string `json:"held_profile,omitempty"`
+	// ExternalDisplay is whether an external monitor is connected.
+	ExternalDisplay bool `json:"external_display"`
+	// AfterburnerProfile is the profile Afterburner reports as active, read for each request.
+	// It is 0 when that cannot be determined.
+	AfterburnerProfile int `json:"afterburner_profile,omitempty"`
+	// Healthy is whether the watcher is delivering events, and LastEvent when it last received one.
+	Healthy   bool       `json:"healthy"`
+	LastEvent *time.Time `json:"last_event,omitempty"`
+}
+
+var (
+	statusMu      sync.Mutex
+	currentStatus status
+)
+
+// publishStatus records the active target and profile for the status endpoint and the tray.
+func publishStatus(match watcher.Match, profile string, paused bool) {
+	s := status{Profile: profile, Paused: paused, ExternalDisplay: externalDisplay.Load()}
+	if match.Keyword != "" {
+		s.Active = true
+		s.Keyword, s.Rule, s.Source = match.Keyword, matchedRuleName(match), match.Source.String()
+		s.PID, s.ExePath, s.WindowTitle = match.PID, match.ExePath, match.WindowTitle
+		if match.DisplayState != watcher.DisplayUnknown {
+			s.DisplayState = match.DisplayState.String()
+		}
+	}
+	statusMu.Lock()
+	currentStatus = s
+	statusMu.Unlock()
+	reportStatus(statusText(match, profile, paused))
+}
+
+// commandHeader must be set on the POST requests that change what the running instance does.
+// A web page cannot add it to a cross-site request without the browser asking the server
+// first, which this server never allows, so a page cannot send commands to a loopback address.
+const commandHeader = "X-Requested-With"
+
+// command returns handler for a POST endpoint, refusing requests without commandHeader.
+func command(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(commandHeader) == "" {
+			http.Error(w, "the "+commandHeader+" header is required", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// postForm is client.PostForm with commandHeader set, as the command endpoints require.
+func postForm(client *http.Client, url string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(commandHeader, "MSIAfterburnerScript")
+	return client.Do(req)
+}
+
+// startStatusServer serves the current status as JSON at http://addr/status and the recent
+// switches at /history. It also takes the apply, release and preset commands as POST requests,
+// which must carry commandHeader. It is meant to be bound to a loopback address.
+func startStatusServer(addr string, live *liveConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		statusMu.Lock()
+		s := currentStatus
+		statusMu.Unlock()
+		cfg, _, _ := live.get()
+		s.AfterburnerProfile, _ = afterburner.New(afterburnerPath(&cfg)).CurrentProfile()
+		s.Healthy = watcher.Healthy()
+		s.HeldProfile = live.load().held
+		if t := watcher.LastEventTime(); !t.IsZero() {
+			s.LastEvent = &t
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s)
+	})
+	mux.HandleFunc("GET /history", serveHistory)
+	mux.HandleFunc("POST /preset", command(func(w http.ResponseWriter, r *http.Request) { servePreset(w, r, live) }))
+	mux.HandleFunc("POST /apply", command(func(w http.ResponseWriter, r *http.Request) { serveApply(w, r, live) }))
+	mux.HandleFunc("POST /release", command(func(w http.ResponseWriter, r *http.Request) { serveRelease(w, r, live) }))
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			logging.Warnf("Status endpoint on %s stopped: %v", addr, err)
+		}
+	}()
+}
+
+// printStatus asks the running instance for its status and prints it.
+func printStatus(addr string) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + addr + "/status")
+	if err != nil {
+		return fmt.Errorf("cannot reach the running instance on %s (is it running with 'status_addr' set?): %w", addr, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status request to %s failed: %s", addr, resp.Status)
+	}
+	var s status
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return fmt.Errorf("cannot read status from %s: %w", addr, err)
+	}
+	if s.Active && s.Rule != "" {
+		fmt.Printf("Active target: %s, rule '%s' (via %s, pid %d)\n", s.Keyword, s.Rule, s.Source, s.PID)
+	} else if s.Active {
+		fmt.Printf("Active target: %s (via %s, pid %d)\n", s.Keyword, s.Source, s.PID)
+	} else {
+		fmt.Println("Active target: none")
+	}
+	fmt.Printf("Profile: %s\n", s.Profile)
+	if s.AfterburnerProfile != 0 {
+		fmt.Printf("Afterburner reports profile %d as active.\n", s.AfterburnerProfile)
+	}
+	if s.HeldProfile != "" {
+		fmt.Printf("Holding profile %s until 'release'.\n", s.HeldProfile)
+	} else if s.Paused {
+		fmt.Println("Switching is paused.")
+	}
+	if !s.Healthy {
+		fmt.Println("Warning: the watcher is not receiving system events.")
+	}
+	return nil
+}
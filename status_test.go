@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCommandRequiresHeader(t *testing.T) {
+	called := false
+	server := httptest.NewServer(command(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte(r.FormValue("profile")))
+	}))
+	defer server.Close()
+
+	resp, err := http.PostForm(server.URL, url.Values{"profile": {"3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || called {
+		t.Fatalf("POST without %s: status %d, handler called %v; want %d and not called", commandHeader, resp.StatusCode, called, http.StatusForbidden)
+	}
+
+	resp, err = postForm(&http.Client{Timeout: 5 * time.Second}, server.URL, url.Values{"profile": {"3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !called || string(body) != "3" {
+		t.Fatalf("postForm: status %d, handler called %v, body %q; want 200, called, %q", resp.StatusCode, called, body, "3")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// inflight is the Afterburner switch in progress, so a newer decision can cancel it.
+// cancellable is whether it may be cancelled, and superseded is set when one was, until
+// takeSuperseded is called. wanted is the profile handler's check for a newer decision.
+var inflight struct {
+	sync.Mutex
+	profile     string
+	cancel      context.CancelFunc
+	cancellable bool
+	superseded  bool
+	wanted      func() (string, bool)
+}
+
+// setWantedCheck sets the function supersedeApply uses to find the profile that is wanted
+// now. It returns false when the switch in progress still stands.
+func setWantedCheck(wanted func() (string, bool)) {
+	inflight.Lock()
+	inflight.wanted = wanted
+	inflight.Unlock()
+}
+
+// startApply records a switch to profile and returns the context to apply it with. done must
+// be called once the switch has finished; it waits for a check by supersedeApply that is
+// still running, so that check never overlaps the profile handler.
+func startApply(profile string, cancellable bool) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	inflight.Lock()
+	inflight.profile, inflight.cancel, inflight.cancellable = profile, cancel, cancellable
+	inflight.Unlock()
+	return ctx, func() {
+		inflight.Lock()
+		inflight.cancel = nil
+		inflight.Unlock()
+		cancel()
+	}
+}
+
+// supersedeApply is called for every event. While a cancellable switch is in progress,
+// possibly waiting to retry, it asks the profile handler's tracker whether it would now switch
+// to another profile at once, and if so cancels the switch, so a slow switch cannot finish
+// after, and override, the newer one. The tracker's dwell, cooldown and revert grace apply as
+// usual, so a change they would hold back does not cancel anything.
+func supersedeApply() {
+	inflight.Lock()
+	defer inflight.Unlock()
+	if inflight.cancel == nil || !inflight.cancellable || inflight.wanted == nil {
+		return
+	}
+	desired, ok := inflight.wanted()
+	if !ok || desired == "" || desired == inflight.profile {
+		return
+	}
+	logging.Infof("Profile %s is wanted now. Cancelling the switch to profile %s that is still in progress.", desired, inflight.profile)
+	inflight.superseded = true
+	inflight.cancel()
+}
+
+// takeSuperseded reports whether a switch was cancelled by supersedeApply since the last call.
+func takeSuperseded() bool {
+	inflight.Lock()
+	defer inflight.Unlock()
+	superseded := inflight.superseded
+	inflight.superseded = false
+	return superseded
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestSupersedeApply(t *testing.T) {
+	tests := []struct {
+		name        string
+		cancellable bool
+		wanted      string
+		wantedOK    bool
+		cancelled   bool
+	}{
+		{"another profile wanted", true, "-Profile2", true, true},
+		{"same profile wanted", true, "-Profile1", true, false},
+		{"tracker holds the change back", true, "", false, false},
+		{"idle with profile_off empty", true, "", true, false},
+		{"hold, release or exit switch", false, "-Profile2", true, false},
+	}
+	defer setWantedCheck(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setWantedCheck(func() (string, bool) { return tt.wanted, tt.wantedOK })
+			ctx, done := startApply("-Profile1", tt.cancellable)
+			supersedeApply()
+			cancelled := ctx.Err() != nil
+			done()
+			superseded := takeSuperseded()
+			if cancelled != tt.cancelled || superseded != tt.cancelled {
+				t.Fatalf("cancelled %v, superseded %v; want %v", cancelled, superseded, tt.cancelled)
+			}
+		})
+	}
+}
+
+func TestSupersedeApplyWithoutSwitch(t *testing.T) {
+	called := false
+	setWantedCheck(func() (string, bool) { called = true; return "-Profile2", true })
+	defer setWantedCheck(nil)
+	supersedeApply()
+	if called || takeSuperseded() {
+		t.Fatal("supersedeApply checked the targets with no switch in progress")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"MSIAfterburnerScript/afterburner"
+	"MSIAfterburnerScript/config"
+	"MSIAfterburnerScript/logging"
+	"MSIAfterburnerScript/watcher"
+)
+
+// temperatureGuard tracks which temperature rule, if any, is holding its profile.
+type temperatureGuard struct {
+	active int // index into the rules, or -1
+	warned bool
+}
+
+func newTemperatureGuard() *temperatureGuard {
+	return &temperatureGuard{active: -1}
+}
+
+// check reads the GPU temperature and returns a match for the temperature rule that applies.
+// If the sensor cannot be read, no rule applies and keyword matching carries on as usual.
+func (g *temperatureGuard) check(rules []config.TemperatureRule) (watcher.Match, bool) {
+	if len(rules) == 0 {
+		g.active = -1
+		return watcher.Match{}, false
+	}
+	temp, err := afterburner.GPUTemperature()
+	if err != nil {
+		if !g.warned {
+			logging.Warnf("Temperature rules are disabled until the GPU temperature can be read: %v", err)
+			g.warned = true
+		}
+		g.active = -1
+		return watcher.Match{}, false
+	}
+	if g.warned {
+		logging.Infof("GPU temperature is available again (%.0f°C).", temp)
+		g.warned = false
+	}
+	if g.active >= len(rules) {
+		g.active = -1
+	}
+	g.active = activeTemperatureRule(rules, temp, g.active)
+	if g.active < 0 {
+		return watcher.Match{}, false
+	}
+	rule := rules[g.active]
+	logging.Debugf("GPU temperature %.0f°C keeps temperature rule %d active.", temp, g.active+1)
+	return watcher.Match{
+		Keyword: fmt.Sprintf("gpu temperature above %g°C", rule.AboveC),
+		Profile: rule.Profile,
+		Source:  watcher.SourceTemperature,
+	}, true
+}
+
+// activeTemperatureRule returns the index of the rule that applies at temp, or -1. The first
+// listed rule whose above_c is reached wins; otherwise the previously active rule keeps
+// applying until temp drops below its clear_below_c.
+func activeTemperatureRule(rules []config.TemperatureRule, temp float64, active int) int {
+	for i, rule := range rules {
+		if temp >= rule.AboveC {
+			return i
+		}
+	}
+	if active >= 0 && temp >= rules[active].ClearBelow() {
+		return active
+	}
+	return -1
+}
@@ -0,0 +1,290 @@
+// Package tray shows a system tray icon with the current status and a small menu.
+package tray
+
+import (
+	_ "embed"
+	"slices"
+	"sync"
+
+	"github.com/getlantern/systray"
+)
+
+//go:embed icon.ico
+var icon []byte
+
+// unhealthyIcon is a red version of icon, shown while the watcher is not delivering events.
+//
+//go:embed icon_unhealthy.ico
+var unhealthyIcon []byte
+
+// maxTooltip is the longest tooltip the notification area shows.
+const maxTooltip = 127
+
+// Actions are called when the matching menu items are clicked.
+type Actions struct {
+	// Pause is called with true when switching is paused and false when it is resumed.
+	Pause func(paused bool)
+	// ToggleRule is called with a rule's index when it is switched on or off in the Rules menu.
+	ToggleRule func(index int, enabled bool)
+	// SelectPreset is called with a preset's index when it is picked in the Presets menu.
+	SelectPreset func(index int)
+	Reload       func()
+	Quit         func()
+}
+
+// Rule is an entry in the Rules menu.
+type Rule struct {
+	Name    string
+	Enabled bool
+}
+
+var (
+	mu         sync.Mutex
+	status     = "Starting..."
+	paused     bool
+	healthy    = true
+	ready      bool
+	statusItem *systray.MenuItem
+	pauseItem  *systray.MenuItem
+	rules      []Rule
+	rulesMenu  *systray.MenuItem
+	ruleItems  []*systray.MenuItem
+	toggleRule func(index int, enabled bool)
+
+	presets      []string
+	activePreset int
+	presetsMenu  *systray.MenuItem
+	presetItems  []*systray.MenuItem
+	selectPreset func(index int)
+)
+
+// Run shows the tray icon and handles its menu until Quit is clicked. It blocks, and must be
+// called from the main goroutine.
+func Run(actions Actions) {
+	systray.Run(func() { onReady(actions) }, nil)
+}
+
+// SetStatus updates the tooltip and the status line at the top of the menu.
+// It can be called before Run, and from any goroutine.
+func SetStatus(s string) {
+	mu.Lock()
+	defer mu.Unlock()
+	status = s
+	if statusItem != nil {
+		apply()
+	}
+}
+
+// SetPaused updates the pause checkbox when switching is paused or resumed outside the menu.
+func SetPaused(p bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	paused = p
+	if pauseItem != nil {
+		apply()
+	}
+}
+
+// SetHealthy turns the icon red while the watcher is not delivering events, and back when it
+// recovers. It can be called before Run, and from any goroutine.
+func SetHealthy(h bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if h == healthy {
+		return
+	}
+	healthy = h
+	if ready {
+		applyIcon()
+		apply()
+	}
+}
+
+// applyIcon shows the icon for the current health. mu must be held.
+func applyIcon() {
+	if healthy {
+		systray.SetIcon(icon)
+	} else {
+		systray.SetIcon(unhealthyIcon)
+	}
+}
+
+// SetRules lists the rules in the Rules menu, checked when they are enabled.
+// It can be called before Run, and from any goroutine.
+func SetRules(r []Rule) {
+	mu.Lock()
+	defer mu.Unlock()
+	rules = slices.Clone(r)
+	if rulesMenu != nil {
+		applyRules()
+	}
+}
+
+// SetPresets lists the presets in the Presets menu, checking the active one. The menu is
+// hidden when names is empty. It can be called before Run, and from any goroutine.
+func SetPresets(names []string, active int) {
+	mu.Lock()
+	defer mu.Unlock()
+	presets, activePreset = slices.Clone(names), active
+	if presetsMenu != nil {
+		applyPresets()
+	}
+}
+
+// applyPresets shows the current presets like applyRules shows the rules. mu must be held.
+func applyPresets() {
+	if len(presets) == 0 {
+		presetsMenu.Hide()
+	} else {
+		presetsMenu.Show()
+	}
+	for len(presetItems) < len(presets) {
+		item := presetsMenu.AddSubMenuItemCheckbox("", "Use this rule set", false)
+		go handlePresetClicks(len(presetItems), item)
+		presetItems = append(presetItems, item)
+	}
+	for i, item := range presetItems {
+		if i >= len(presets) {
+			item.Hide()
+			continue
+		}
+		item.SetTitle(presets[i])
+		if i == activePreset {
+			item.Check()
+		} else {
+			item.Uncheck()
+		}
+		item.Show()
+	}
+}
+
+// handlePresetClicks selects preset i whenever its menu item is clicked.
+func handlePresetClicks(i int, item *systray.MenuItem) {
+	for range item.ClickedCh {
+		mu.Lock()
+		if i >= len(presets) {
+			mu.Unlock()
+			continue
+		}
+		activePreset = i
+		applyPresets()
+		choose := selectPreset
+		mu.Unlock()
+		if choose != nil {
+			choose(i)
+		}
+	}
+}
+
+// applyRules shows the current rules, adding menu items as needed and hiding unused ones,
+// since items cannot be removed. mu must be held.
+func applyRules() {
+	if len(rules) == 0 {
+		rulesMenu.Hide()
+	} else {
+		rulesMenu.Show()
+	}
+	for len(ruleItems) < len(rules) {
+		item := rulesMenu.AddSubMenuItemCheckbox("", "Match this rule", false)
+		go handleRuleClicks(len(ruleItems), item)
+		ruleItems = append(ruleItems, item)
+	}
+	for i, item := range ruleItems {
+		if i >= len(rules) {
+			item.Hide()
+			continue
+		}
+		item.SetTitle(rules[i].Name)
+		if rules[i].Enabled {
+			item.Check()
+		} else {
+			item.Uncheck()
+		}
+		item.Show()
+	}
+}
+
+// handleRuleClicks toggles rule i whenever its menu item is clicked.
+func handleRuleClicks(i int, item *systray.MenuItem) {
+	for range item.ClickedCh {
+		mu.Lock()
+		if i >= len(rules) {
+			mu.Unlock()
+			continue
+		}
+		rules[i].Enabled = !rules[i].Enabled
+		enabled := rules[i].Enabled
+		applyRules()
+		toggle := toggleRule
+		mu.Unlock()
+		if toggle != nil {
+			toggle(i, enabled)
+		}
+	}
+}
+
+// apply shows the current status. mu must be held.
+func apply() {
+	if paused {
+		pauseItem.Check()
+	} else {
+		pauseItem.Uncheck()
+	}
+	statusItem.SetTitle(status)
+	tooltip := "MSI Afterburner Script: " + status
+	if !healthy {
+		tooltip = "MSI Afterburner Script: No system events received. " + status
+	}
+	if r := []rune(tooltip); len(r) > maxTooltip {
+		tooltip = string(r[:maxTooltip])
+	}
+	systray.SetTooltip(tooltip)
+}
+
+func onReady(actions Actions) {
+	mu.Lock()
+	ready = true
+	applyIcon()
+	statusItem = systray.AddMenuItem(status, "Current target and profile")
+	statusItem.Disable()
+	systray.AddSeparator()
+	pauseItem = systray.AddMenuItemCheckbox("Pause switching", "Keep watching but stop changing profiles", paused)
+	apply()
+	rulesMenu = systray.AddMenuItem("Rules", "Turn rules on or off until the configuration is reloaded")
+	toggleRule = actions.ToggleRule
+	applyRules()
+	presetsMenu = systray.AddMenuItem("Presets", "Switch to another rule set until the configuration is reloaded")
+	selectPreset = actions.SelectPreset
+	applyPresets()
+	mu.Unlock()
+
+	reload := systray.AddMenuItem("Reload config", "Read the configuration file again")
+	systray.AddSeparator()
+	quit := systray.AddMenuItem("Quit", "Stop watching and exit")
+
+	go func() {
+		for {
+			select {
+			case <-pauseItem.ClickedCh:
+				mu.Lock()
+				paused = !paused
+				p := paused
+				apply()
+				mu.Unlock()
+				if actions.Pause != nil {
+					actions.Pause(p)
+				}
+			case <-reload.ClickedCh:
+				if actions.Reload != nil {
+					actions.Reload()
+				}
+			case <-quit.ClickedCh:
+				if actions.Quit != nil {
+					actions.Quit()
+				}
+				systray.Quit()
+				return
+			}
+		}
+	}()
+}
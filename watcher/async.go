@@ -0,0 +1,51 @@
+package watcher
+
+import (
+	"context"
+	"time"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// DefaultHandlerTimeout is the timeout Async uses when it is given 0.
+const DefaultHandlerTimeout = 30 * time.Second
+
+// Async wraps handler so that calls return at once and handler runs on a worker goroutine
+// instead, so a slow handler cannot stall the message loop that delivers events. Calls made
+// while handler is running are coalesced into a single run after it returns, and handler is
+// never run concurrently with itself. A warning is logged when one run takes longer than
+// timeout. The worker stops when ctx is cancelled.
+func Async(ctx context.Context, timeout time.Duration, handler func()) func() {
+	if timeout <= 0 {
+		timeout = DefaultHandlerTimeout
+	}
+	pending := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-pending:
+				runTimed(timeout, handler)
+			}
+		}
+	}()
+	return func() {
+		select {
+		case pending <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runTimed runs handler, warning if it is still running after timeout.
+func runTimed(timeout time.Duration, handler func()) {
+	start := time.Now()
+	slow := time.AfterFunc(timeout, func() {
+		logging.Warnf("The handler has been running for more than %v. Events are still received and will be handled once it returns.", timeout)
+	})
+	handler()
+	if !slow.Stop() {
+		logging.Infof("The slow handler returned after %v.", time.Since(start).Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,74 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsyncWithSlowHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		// during is how many events arrive while the first run is blocked.
+		during   int
+		wantRuns int32
+	}{
+		{"single event", 0, 1},
+		{"one event during the run", 1, 2},
+		{"burst during the run", 1000, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			started := make(chan struct{}, 10)
+			release := make(chan struct{})
+			var runs, running atomic.Int32
+			handler := Async(ctx, time.Hour, func() {
+				if running.Add(1) > 1 {
+					t.Error("the handler ran concurrently with itself")
+				}
+				defer running.Add(-1)
+				runs.Add(1)
+				started <- struct{}{}
+				<-release
+			})
+
+			handler()
+			<-started
+			begin := time.Now()
+			for i := 0; i < tt.during; i++ {
+				handler()
+			}
+			if elapsed := time.Since(begin); elapsed > time.Second {
+				t.Fatalf("%d events took %v while the handler was blocked", tt.during, elapsed)
+			}
+			close(release)
+			if tt.wantRuns > 1 {
+				select {
+				case <-started:
+				case <-time.After(5 * time.Second):
+					t.Fatal("the events during the slow run were dropped")
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			if n := runs.Load(); n != tt.wantRuns {
+				t.Fatalf("the handler ran %d times, want %d", n, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestAsyncStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var runs atomic.Int32
+	handler := Async(ctx, 0, func() { runs.Add(1) })
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	handler()
+	time.Sleep(50 * time.Millisecond)
+	if n := runs.Load(); n != 0 {
+		t.Fatalf("the handler ran %d times after cancel, want 0", n)
+	}
+}
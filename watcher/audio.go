@@ -0,0 +1,202 @@
+package watcher
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"MSIAfterburnerScript/logging"
+)
+
+var (
+	ole32                = windows.NewLazySystemDLL("ole32.dll")
+	procCoInitializeEx   = ole32.NewProc("CoInitializeEx")
+	procCoUninitialize   = ole32.NewProc("CoUninitialize")
+	procCoCreateInstance = ole32.NewProc("CoCreateInstance")
+)
+
+// Core Audio class and interface IDs.
+var (
+	clsidMMDeviceEnumerator  = windows.GUID{Data1: 0xbcde0395, Data2: 0xe52f, Data3: 0x467c, Data4: [8]byte{0x8e, 0x3d, 0xc4, 0x57, 0x92, 0x91, 0x69, 0x2e}}
+	iidIMMDeviceEnumerator   = windows.GUID{Data1: 0xa95664d2, Data2: 0x9614, Data3: 0x4f35, Data4: [8]byte{0xa7, 0x46, 0xde, 0x8d, 0xb6, 0x36, 0x17, 0xe6}}
+	iidIAudioSessionManager2 = windows.GUID{Data1: 0x77aa99a0, Data2: 0x1bd6, Data3: 0x484f, Data4: [8]byte{0x8b, 0xc7, 0x2c, 0x65, 0x4c, 0x9a, 0x9b, 0x6f}}
+	iidIAudioSessionControl2 = windows.GUID{Data1: 0xbfb7ff88, Data2: 0x7239, Data3: 0x4fc9, Data4: [8]byte{0x8f, 0xa2, 0x07, 0xc9, 0x50, 0xbe, 0x9c, 0x6d}}
+)
+
+const (
+	coinitMultithreaded     = 0x0
+	clsctxAll               = 0x17
+	eRender                 = 0
+	eMultimedia             = 1
+	audioSessionStateActive = 1
+	rpcEChangedMode         = 0x80010106
+)
+
+// Method indexes in the Core Audio vtables. Every interface starts with IUnknown's
+// QueryInterface, AddRef and Release.
+const (
+	methodQueryInterface          = 0
+	methodRelease                 = 2
+	methodGetDefaultAudioEndpoint = 4  // IMMDeviceEnumerator
+	methodActivate                = 3  // IMMDevice
+	methodGetSessionEnumerator    = 5  // IAudioSessionManager2
+	methodGetCount                = 3  // IAudioSessionEnumerator
+	methodGetSession              = 4  // IAudioSessionEnumerator
+	methodGetState                = 3  // IAudioSessionControl
+	methodGetProcessID            = 14 // IAudioSessionControl2
+)
+
+// comObject is a COM interface pointer: a pointer to its vtable.
+type comObject struct {
+	vtbl *[methodGetProcessID + 1]uintptr
+}
+
+// call calls the method with the given vtable index and returns its HRESULT.
+func (o *comObject) call(method int, args ...uintptr) error {
+	hr, _, _ := syscall.SyscallN(o.vtbl[method], append([]uintptr{uintptr(unsafe.Pointer(o))}, args...)...)
+	if int32(hr) < 0 {
+		return syscall.Errno(hr)
+	}
+	return nil
+}
+
+func (o *comObject) release() { o.call(methodRelease) }
+
+// audioUnavailable warns only once when the audio sessions cannot be read.
+var audioUnavailable sync.Once
+
+// isAudioActive checks whether a process that is playing sound on the default output device
+// matches an AudioPrefix keyword. If Core Audio is not available a warning is logged once, and
+// audio keywords no longer match.
+func isAudioActive(targets []Target, sc *scan) (Match, bool) {
+	targets = keepTargets(targets, func(t Target) bool {
+		return strings.HasPrefix(t.Keyword, AudioPrefix) && t.Scope.exes() && t.Scope.background() && !t.FullscreenOnly
+	})
+	if len(targets) == 0 {
+		return Match{}, false
+	}
+	pids, err := sc.audioPIDs()
+	if err != nil {
+		audioUnavailable.Do(func() {
+			logging.Warnf("Cannot tell which programs are playing sound, 'audio:' keywords will not match: %v", err)
+		})
+		return Match{}, false
+	}
+	processes, err := sc.processList()
+	if err != nil {
+		return Match{}, false
+	}
+	var found Match
+	best := -1
+	for _, p := range processes {
+		pid := uint32(p.Pid())
+		if !pids[pid] {
+			continue
+		}
+		lower := fold(p.Executable())
+		if i := firstMatching(targets, limitOf(best, targets), func(t Target) bool {
+			return matchAudio(lower, t.Keyword, t.Mode)
+		}); i >= 0 {
+			best = i
+			found = targets[i].match(SourceAudio)
+			found.PID, found.ExePath = pid, p.Executable()
+			if best == 0 {
+				break
+			}
+		}
+	}
+	return found, best >= 0
+}
+
+// audioPIDs returns the processes with an active audio session, reading them at most once per scan.
+func (sc *scan) audioPIDs() (map[uint32]bool, error) {
+	if !sc.audioListed {
+		sc.audio, sc.audioErr = sc.src.audioSessions()
+		sc.audioListed = true
+	}
+	return sc.audio, sc.audioErr
+}
+
+// activeAudioSessions returns the PIDs of the processes whose audio session on the default
+// multimedia output device is active, that is, currently playing sound.
+func activeAudioSessions() (map[uint32]bool, error) {
+	if err := ole32.Load(); err != nil {
+		return nil, err
+	}
+	// COM is initialized per thread, so the calls must all run on this one.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	hr, _, _ := procCoInitializeEx.Call(0, coinitMultithreaded)
+	switch {
+	case uint32(hr) == rpcEChangedMode:
+		// The thread already uses another apartment, which works just as well.
+	case int32(hr) < 0:
+		return nil, fmt.Errorf("CoInitializeEx failed: %w", syscall.Errno(hr))
+	default:
+		defer procCoUninitialize.Call()
+	}
+
+	var enumerator *comObject
+	hr, _, _ = procCoCreateInstance.Call(uintptr(unsafe.Pointer(&clsidMMDeviceEnumerator)), 0, clsctxAll,
+		uintptr(unsafe.Pointer(&iidIMMDeviceEnumerator)), uintptr(unsafe.Pointer(&enumerator)))
+	if int32(hr) < 0 {
+		return nil, fmt.Errorf("cannot create the audio device enumerator: %w", syscall.Errno(hr))
+	}
+	defer enumerator.release()
+	var device *comObject
+	if err := enumerator.call(methodGetDefaultAudioEndpoint, eRender, eMultimedia, uintptr(unsafe.Pointer(&device))); err != nil {
+		return nil, fmt.Errorf("cannot find the default audio output: %w", err)
+	}
+	defer device.release()
+	var manager *comObject
+	if err := device.call(methodActivate, uintptr(unsafe.Pointer(&iidIAudioSessionManager2)), clsctxAll, 0, uintptr(unsafe.Pointer(&manager))); err != nil {
+		return nil, fmt.Errorf("cannot open the audio session manager: %w", err)
+	}
+	defer manager.release()
+	var sessions *comObject
+	if err := manager.call(methodGetSessionEnumerator, uintptr(unsafe.Pointer(&sessions))); err != nil {
+		return nil, fmt.Errorf("cannot list the audio sessions: %w", err)
+	}
+	defer sessions.release()
+	var count int32
+	if err := sessions.call(methodGetCount, uintptr(unsafe.Pointer(&count))); err != nil {
+		return nil, fmt.Errorf("cannot count the audio sessions: %w", err)
+	}
+
+	pids := make(map[uint32]bool)
+	for i := range count {
+		if pid, ok := activeSessionPID(sessions, i); ok {
+			pids[pid] = true
+		}
+	}
+	return pids, nil
+}
+
+// activeSessionPID returns the process of session i if the session is active. The system
+// sounds session belongs to no process and is skipped.
+func activeSessionPID(sessions *comObject, i int32) (uint32, bool) {
+	var control *comObject
+	if sessions.call(methodGetSession, uintptr(i), uintptr(unsafe.Pointer(&control))) != nil {
+		return 0, false
+	}
+	defer control.release()
+	var state int32
+	if control.call(methodGetState, uintptr(unsafe.Pointer(&state))) != nil || state != audioSessionStateActive {
+		return 0, false
+	}
+	var control2 *comObject
+	if control.call(methodQueryInterface, uintptr(unsafe.Pointer(&iidIAudioSessionControl2)), uintptr(unsafe.Pointer(&control2))) != nil {
+		return 0, false
+	}
+	defer control2.release()
+	var pid uint32
+	if control2.call(methodGetProcessID, uintptr(unsafe.Pointer(&pid))) != nil || pid == 0 {
+		return 0, false
+	}
+	return pid, true
+}
@@ -0,0 +1,62 @@
+package watcher
+
+import (
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// applicationUserModelIDMaxLength is APPLICATION_USER_MODEL_ID_MAX_LENGTH, including the terminator.
+	applicationUserModelIDMaxLength = 130
+	// appmodelErrorNoApplication is returned for processes that are not packaged apps.
+	appmodelErrorNoApplication = 15703
+
+	// frameHostExe hosts the frame of every UWP window; the app itself runs in another process
+	// that owns a child window of the frame.
+	frameHostExe = "applicationframehost.exe"
+)
+
+var (
+	procGetApplicationUserModelId = kernel32.NewProc("GetApplicationUserModelId")
+	procEnumChildWindows          = user32.NewProc("EnumChildWindows")
+)
+
+// processAUMID returns the AppUserModelID of a packaged (UWP or Store) app's process, or "" for
+// ordinary programs and on Windows versions without GetApplicationUserModelId.
+func processAUMID(pid uint32) string {
+	if procGetApplicationUserModelId.Find() != nil {
+		return ""
+	}
+	var id string
+	withProcess(pid, windows.PROCESS_QUERY_LIMITED_INFORMATION, func(handle uintptr) {
+		length := uint32(applicationUserModelIDMaxLength)
+		buf := make([]uint16, length)
+		ret, _, _ := procGetApplicationUserModelId.Call(handle, uintptr(unsafe.Pointer(&length)), uintptr(unsafe.Pointer(&buf[0])))
+		if ret == 0 {
+			id = windows.UTF16ToString(buf)
+		}
+	})
+	return id
+}
+
+// foregroundAUMID returns the AppUserModelID of the app behind the foreground window. For a
+// window framed by ApplicationFrameHost it looks for the child window of the hosted app.
+func foregroundAUMID(hwnd windows.HWND, pid uint32, exePath string) string {
+	if id := processAUMID(pid); id != "" || !strings.EqualFold(filepath.Base(exePath), frameHostExe) {
+		return id
+	}
+	var id string
+	key := nextEnumKey.Add(1)
+	enumCalls.Store(key, func(child windows.HWND) bool {
+		if childPID := windowProcessID(child); childPID != 0 && childPID != pid {
+			id = processAUMID(childPID)
+		}
+		return id == ""
+	})
+	defer enumCalls.Delete(key)
+	procEnumChildWindows.Call(uintptr(hwnd), enumWindowsProc, key)
+	return id
+}
@@ -0,0 +1,57 @@
+package watcher
+
+import (
+	"encoding/binary"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	processCommandLineInformation = 60
+	statusInfoLengthMismatch      = 0xC0000004
+	// unicodeStringSize is sizeof(UNICODE_STRING) on 64-bit Windows: two uint16 lengths,
+	// padding and the buffer pointer.
+	unicodeStringSize = 16
+)
+
+var (
+	ntdll                         = windows.NewLazySystemDLL("ntdll.dll")
+	procNtQueryInformationProcess = ntdll.NewProc("NtQueryInformationProcess")
+)
+
+// processCommandLine returns a process's full command line, or "" if it cannot be read, for
+// example because the process belongs to another user or has already exited.
+func processCommandLine(pid uint32) string {
+	var cmdline string
+	withProcess(pid, windows.PROCESS_QUERY_LIMITED_INFORMATION, func(handle uintptr) {
+		var size uint32
+		ret, _, _ := procNtQueryInformationProcess.Call(handle, processCommandLineInformation, 0, 0, uintptr(unsafe.Pointer(&size)))
+		if uint32(ret) != statusInfoLengthMismatch || size < unicodeStringSize {
+			return
+		}
+		buf := make([]byte, size)
+		ret, _, _ = procNtQueryInformationProcess.Call(handle, processCommandLineInformation, uintptr(unsafe.Pointer(&buf[0])), uintptr(size), uintptr(unsafe.Pointer(&size)))
+		if ret != 0 {
+			return
+		}
+		cmdline = unicodeStringAt(buf)
+	})
+	return cmdline
+}
+
+// unicodeStringAt decodes a UNICODE_STRING at the start of buf whose characters are stored
+// later in the same buffer, as NtQueryInformationProcess returns it.
+func unicodeStringAt(buf []byte) string {
+	length := int(binary.LittleEndian.Uint16(buf[0:]))
+	offset := int(uintptr(binary.LittleEndian.Uint64(buf[8:])) - uintptr(unsafe.Pointer(&buf[0])))
+	if offset < unicodeStringSize || offset+length > len(buf) {
+		return ""
+	}
+	chars := make([]uint16, length/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(buf[offset+2*i:])
+	}
+	return string(utf16.Decode(chars))
+}
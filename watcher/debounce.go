@@ -0,0 +1,59 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Debounce wraps handler so that a burst of calls is coalesced into a single call made once
+// no new call has arrived for wait. A call after a quiet period fires immediately, so the
+// first event is not delayed. The handler is never run concurrently with itself.
+func Debounce(wait time.Duration, handler func()) func() {
+	if wait <= 0 {
+		return handler
+	}
+	d := &debouncer{wait: wait, handler: handler}
+	return d.call
+}
+
+type debouncer struct {
+	wait    time.Duration
+	handler func()
+
+	mu    sync.Mutex
+	timer *time.Timer
+	last  time.Time
+
+	run sync.Mutex
+}
+
+func (d *debouncer) call() {
+	d.mu.Lock()
+	now := time.Now()
+	quiet := now.Sub(d.last) >= d.wait
+	d.last = now
+	if quiet && d.timer == nil {
+		d.mu.Unlock()
+		d.fire()
+		return
+	}
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.wait, d.trailing)
+	} else {
+		d.timer.Reset(d.wait)
+	}
+	d.mu.Unlock()
+}
+
+func (d *debouncer) trailing() {
+	d.mu.Lock()
+	d.timer = nil
+	d.mu.Unlock()
+	d.fire()
+}
+
+func (d *debouncer) fire() {
+	d.run.Lock()
+	defer d.run.Unlock()
+	d.handler()
+}
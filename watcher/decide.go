@@ -0,0 +1,128 @@
+package watcher
+
+// DetectionState is a snapshot of everything the detection stages look at, as plain values, so
+// the matching decision can be worked out and tested without a Windows session.
+type DetectionState struct {
+	// Foreground is the foreground window, or nil if there is none.
+	Foreground *ForegroundState
+	// Processes are the running processes.
+	Processes []ProcessState
+	// Windows are the visible, non-minimized top-level windows, in the order the window stage
+	// checks them. Their Foreground field is not used.
+	Windows []WindowInfo
+	// AudioPIDs are the processes playing sound on the default output device.
+	AudioPIDs []uint32
+}
+
+// ForegroundState describes the foreground window in a DetectionState. Fields that are
+// unknown can be left empty; keywords for them then do not match.
+type ForegroundState struct {
+	PID          uint32
+	ExePath      string
+	Title        string
+	Class        string
+	AUMID        string
+	Product      string
+	Company      string
+	DisplayState WindowDisplayState
+}
+
+// ProcessState is a running process in a DetectionState. Path is only needed with
+// Options.PathMatch, and CommandLine only for "cmdline:" keywords.
+type ProcessState struct {
+	PID         uint32
+	ParentPID   uint32
+	Executable  string
+	Path        string
+	CommandLine string
+}
+
+// Decide returns the target FirstActive would report if the system looked like state: the same
+// stages, exclusions and priorities are applied, but nothing is read from Windows and the
+// process and window caches in opts are ignored.
+func Decide(state DetectionState, targets []Target, opts Options) (Match, bool) {
+	return firstActive(targets, opts, newSourceScan(state))
+}
+
+func (s DetectionState) foreground() (foregroundWindow, bool) {
+	if s.Foreground == nil {
+		return nil, false
+	}
+	return stateForeground{s.Foreground}, true
+}
+
+func (s DetectionState) processes() ([]Process, error) {
+	processes := make([]Process, len(s.Processes))
+	for i, p := range s.Processes {
+		processes[i] = stateProcess{p}
+	}
+	return processes, nil
+}
+
+func (s DetectionState) imagePath(pid uint32) string {
+	if s.Foreground != nil && s.Foreground.PID == pid && s.Foreground.ExePath != "" {
+		return s.Foreground.ExePath
+	}
+	if p, ok := s.process(pid); ok {
+		return p.Path
+	}
+	return ""
+}
+
+func (s DetectionState) commandLine(pid uint32) string {
+	p, _ := s.process(pid)
+	return p.CommandLine
+}
+
+func (s DetectionState) visibleWindows(fn func(w Window) bool) {
+	for _, w := range s.Windows {
+		if !fn(stateWindow{w}) {
+			return
+		}
+	}
+}
+
+func (s DetectionState) audioSessions() (map[uint32]bool, error) {
+	pids := make(map[uint32]bool, len(s.AudioPIDs))
+	for _, pid := range s.AudioPIDs {
+		pids[pid] = true
+	}
+	return pids, nil
+}
+
+// process returns the process with the given PID.
+func (s DetectionState) process(pid uint32) (ProcessState, bool) {
+	for _, p := range s.Processes {
+		if p.PID == pid {
+			return p, true
+		}
+	}
+	return ProcessState{}, false
+}
+
+// stateProcess, stateWindow and stateForeground present a DetectionState's values to the stages.
+type stateProcess struct{ p ProcessState }
+
+func (p stateProcess) Pid() int           { return int(p.p.PID) }
+func (p stateProcess) PPid() int          { return int(p.p.ParentPID) }
+func (p stateProcess) Executable() string { return p.p.Executable }
+
+type stateWindow struct{ w WindowInfo }
+
+func (w stateWindow) Title() string { return w.w.Title }
+func (w stateWindow) Class() string { return w.w.Class }
+func (w stateWindow) PID() uint32   { return w.w.PID }
+
+type stateForeground struct{ fg *ForegroundState }
+
+func (f stateForeground) Title() string                    { return f.fg.Title }
+func (f stateForeground) Class() string                    { return f.fg.Class }
+func (f stateForeground) PID() uint32                      { return f.fg.PID }
+func (f stateForeground) handle() uintptr                  { return 0 }
+func (f stateForeground) displayState() WindowDisplayState { return f.fg.DisplayState }
+func (f stateForeground) aumid(string) string              { return f.fg.AUMID }
+func (f stateForeground) pathUnreadable()                  {}
+
+func (f stateForeground) versionInfo(string) versionInfo {
+	return versionInfo{product: fold(f.fg.Product), company: fold(f.fg.Company)}
+}
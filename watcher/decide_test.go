@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDecideThroughTracker(t *testing.T) {
+	game := ProcessState{PID: 10, Executable: "Game.exe"}
+	editor := ProcessState{PID: 11, Executable: "Editor.exe"}
+	// Each step sets the running processes, waits and then checks. want is every profile
+	// forwarded so far, with "" for no target.
+	type step struct {
+		running []ProcessState
+		wait    time.Duration
+		want    []string
+	}
+	tests := []struct {
+		name    string
+		targets []Target
+		grace   time.Duration
+		preempt bool
+		steps   []step
+	}{
+		{
+			name:    "dwell holds back a short-lived match",
+			targets: []Target{{Keyword: "game", Profile: "1", Dwell: 50 * time.Millisecond}},
+			steps: []step{
+				{nil, 0, []string{""}},
+				{[]ProcessState{game}, 0, []string{""}},
+				{nil, 0, []string{""}},
+				{[]ProcessState{game}, 0, []string{""}},
+				{[]ProcessState{game}, 80 * time.Millisecond, []string{"", "1"}},
+			},
+		},
+		{
+			name:    "cooldown holds off a switch until it ends",
+			targets: []Target{{Keyword: "game", Profile: "1", Cooldown: 80 * time.Millisecond}, {Keyword: "editor", Profile: "2"}},
+			steps: []step{
+				{[]ProcessState{game}, 0, []string{"1"}},
+				{[]ProcessState{editor}, 0, []string{"1"}},
+				{[]ProcessState{editor}, 100 * time.Millisecond, []string{"1", "2"}},
+			},
+		},
+		{
+			name:    "higher priority preempts a cooldown",
+			targets: []Target{{Keyword: "editor", Profile: "2"}, {Keyword: "game", Profile: "1", Cooldown: time.Hour}},
+			preempt: true,
+			steps: []step{
+				{[]ProcessState{game}, 0, []string{"1"}},
+				{[]ProcessState{game, editor}, 0, []string{"1", "2"}},
+			},
+		},
+		{
+			name:    "cooldown without preemption",
+			targets: []Target{{Keyword: "editor", Profile: "2"}, {Keyword: "game", Profile: "1", Cooldown: time.Hour}},
+			steps: []step{
+				{[]ProcessState{game}, 0, []string{"1"}},
+				{[]ProcessState{game, editor}, 0, []string{"1"}},
+			},
+		},
+		{
+			name:    "revert grace keeps a target through a brief gap",
+			targets: []Target{{Keyword: "game", Profile: "1"}},
+			grace:   80 * time.Millisecond,
+			steps: []step{
+				{[]ProcessState{game}, 0, []string{"1"}},
+				{nil, 0, []string{"1"}},
+				{[]ProcessState{game}, 0, []string{"1"}},
+				{nil, 0, []string{"1"}},
+				{nil, 100 * time.Millisecond, []string{"1", ""}},
+			},
+		},
+		{
+			name:    "revert grace does not delay a switch to another target",
+			targets: []Target{{Keyword: "game", Profile: "1"}, {Keyword: "editor", Profile: "2"}},
+			grace:   time.Hour,
+			steps: []step{
+				{[]ProcessState{game}, 0, []string{"1"}},
+				{[]ProcessState{editor}, 0, []string{"1", "2"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state DetectionState
+			var forwarded []string
+			tracker := NewTransitionTracker(func() (Match, bool) { return Decide(state, tt.targets, Options{}) }, func(_, cur Match) {
+				forwarded = append(forwarded, cur.Profile)
+			})
+			// The steps check again themselves, so the timers the tracker sets do nothing.
+			tracker.Recheck = func() {}
+			tracker.RevertGrace = tt.grace
+			if tt.preempt {
+				tracker.Preempts = func(cur, last Match) bool {
+					i := slices.IndexFunc(tt.targets, cur.Of)
+					return i >= 0 && i < slices.IndexFunc(tt.targets, last.Of)
+				}
+			}
+			for i, s := range tt.steps {
+				time.Sleep(s.wait)
+				state.Processes = s.running
+				tracker.Check()
+				if !slices.Equal(forwarded, s.want) {
+					t.Fatalf("after step %d forwarded %q, want %q", i, forwarded, s.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package watcher
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	wmDisplayChange = 0x007E
+
+	qdcOnlyActivePaths = 0x00000002
+
+	// DISPLAYCONFIG_PATH_INFO is 72 bytes, with the target's outputTechnology at offset 36.
+	displayPathSize             = 72
+	displayPathTechnologyOffset = 36
+	displayModeSize             = 64
+
+	// Output technologies used by built-in laptop panels.
+	outputTechnologyLVDS        = 6
+	outputTechnologyDPEmbedded  = 11
+	outputTechnologyUDIEmbedded = 13
+	outputTechnologyInternal    = 0x80000000
+
+	displayWindowClass = "MSIAfterburnerScriptDisplayWatcher"
+	// displayConfigRetries bounds how often the display configuration is re-read when it
+	// changes between sizing the buffers and reading it.
+	displayConfigRetries = 3
+)
+
+var (
+	procGetDisplayConfigBufferSizes = user32.NewProc("GetDisplayConfigBufferSizes")
+	procQueryDisplayConfig          = user32.NewProc("QueryDisplayConfig")
+)
+
+// ExternalDisplayConnected reports whether any active display is connected through an external
+// output (HDMI, DisplayPort, DVI, ...) rather than being a built-in laptop panel.
+func ExternalDisplayConnected() (bool, error) {
+	for attempt := 0; attempt < displayConfigRetries; attempt++ {
+		var numPaths, numModes uint32
+		ret, _, _ := procGetDisplayConfigBufferSizes.Call(qdcOnlyActivePaths, uintptr(unsafe.Pointer(&numPaths)), uintptr(unsafe.Pointer(&numModes)))
+		if ret != 0 {
+			return false, fmt.Errorf("GetDisplayConfigBufferSizes failed: %w", windows.Errno(ret))
+		}
+		if numPaths == 0 {
+			return false, nil
+		}
+		paths := make([]byte, int(numPaths)*displayPathSize)
+		modes := make([]byte, max(int(numModes), 1)*displayModeSize)
+		ret, _, _ = procQueryDisplayConfig.Call(qdcOnlyActivePaths,
+			uintptr(unsafe.Pointer(&numPaths)), uintptr(unsafe.Pointer(&paths[0])),
+			uintptr(unsafe.Pointer(&numModes)), uintptr(unsafe.Pointer(&modes[0])), 0)
+		if windows.Errno(ret) == windows.ERROR_INSUFFICIENT_BUFFER {
+			continue // The display setup changed between the two calls.
+		}
+		if ret != 0 {
+			return false, fmt.Errorf("QueryDisplayConfig failed: %w", windows.Errno(ret))
+		}
+		for i := 0; i < int(numPaths); i++ {
+			tech := binary.LittleEndian.Uint32(paths[i*displayPathSize+displayPathTechnologyOffset:])
+			if !internalOutput(tech) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("QueryDisplayConfig failed: the display configuration kept changing")
+}
+
+func internalOutput(tech uint32) bool {
+	switch tech {
+	case outputTechnologyLVDS, outputTechnologyDPEmbedded, outputTechnologyUDIEmbedded, outputTechnologyInternal:
+		return true
+	}
+	return false
+}
+
+// StartDisplayWatcher calls handler whenever the display configuration changes (a monitor is
+// connected or removed, or the resolution changes). WM_DISPLAYCHANGE is only broadcast to
+// top-level windows, so a hidden window is created on a dedicated thread. The returned channel
+// receives an error if the window could not be created, or nil once ctx is cancelled, and is
+// then closed.
+func StartDisplayWatcher(ctx context.Context, handler func()) <-chan error {
+	return startMessageWindow(ctx, displayWindowClass, func(msg uint32, _ uintptr) bool {
+		if msg == wmDisplayChange {
+			handler()
+		}
+		return false
+	}, nil)
+}
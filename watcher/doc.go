@@ -0,0 +1,10 @@
+// Package watcher detects which configured target is active and reports changes.
+//
+// FirstActive reads the foreground window, processes and windows through Win32. Decide makes
+// the same decision from a DetectionState instead, so matching can be tested without Windows.
+//
+// The detection functions are safe to call from several goroutines at once, such as the
+// safety poll and the event hooks in hybrid mode. Each call keeps its working state, including
+// whatever its EnumWindows callback accumulates, in locals of that call; the only package-level
+// mutable state is the regex, process and window caches, which are guarded by their own locks.
+package watcher
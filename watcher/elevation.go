@@ -0,0 +1,64 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/windows"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// isElevated reports whether this process runs as administrator. It is read once.
+var isElevated = sync.OnceValue(func() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+})
+
+// Elevated reports whether this process runs as administrator.
+func Elevated() bool { return isElevated() }
+
+// elevationHinted records the PIDs hintElevation has already checked.
+var elevationHinted sync.Map
+
+// hintElevation logs a warning, once per PID, when the foreground process's executable cannot be
+// read because that process runs as administrator and this one does not. Such processes can then
+// only be matched by window title.
+func hintElevation(pid uint32) {
+	if pid == 0 || isElevated() {
+		return
+	}
+	if _, seen := elevationHinted.LoadOrStore(pid, true); seen {
+		return
+	}
+	err := withProcess(pid, processImageAccess, func(uintptr) {})
+	if !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+		return
+	}
+	name := limitedImageName(pid)
+	// Afterburner itself always runs elevated, and is never a target.
+	if strings.EqualFold(name, "msiafterburner.exe") {
+		return
+	}
+	process := fmt.Sprintf("process %d", pid)
+	if name != "" {
+		process = fmt.Sprintf("%s (pid %d)", name, pid)
+	}
+	logging.Warnf("Cannot read the foreground %s because it is running as administrator. Run MSIAfterburnerScript as administrator too if it needs to be matched by exe name or path.", process)
+}
+
+// limitedImageName returns the exe name of pid using the limited access that is usually still
+// granted for elevated processes, or "" if that fails too.
+func limitedImageName(pid uint32) string {
+	var name string
+	withProcess(pid, windows.PROCESS_QUERY_LIMITED_INFORMATION, func(handle uintptr) {
+		buf := make([]uint16, windows.MAX_PATH)
+		size := uint32(len(buf))
+		if windows.QueryFullProcessImageName(windows.Handle(handle), 0, &buf[0], &size) == nil {
+			name = filepath.Base(windows.UTF16ToString(buf[:size]))
+		}
+	})
+	return name
+}
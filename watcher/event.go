@@ -0,0 +1,395 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// WinEvent constants for event-driven watching
+const (
+	eventSystemForeground = 0x0003
+	eventObjectCreate     = 0x8000
+	eventObjectDestroy    = 0x8001
+	wndOutofcontext       = 0x0000
+
+	wmQuit     = 0x0012
+	wmTimer    = 0x0113
+	pmNoRemove = 0x0000
+
+	wmPowerBroadcast      = 0x0218
+	pbtAPMResumeAutomatic = 0x0012
+	pbtPowerSettingChange = 0x8013
+	// wmRearmHooks (WM_APP+1) is posted to the event watcher's thread to reinstall its hooks.
+	wmRearmHooks = 0x8001
+
+	resumeWindowClass = "MSIAfterburnerScriptResumeWatcher"
+)
+
+// rearmGrace ignores re-arm requests right after the hooks were installed, such as the display
+// state Windows reports as soon as the notification is registered.
+const rearmGrace = 2 * time.Second
+
+// errRearm is returned by runEventHooks when the hooks should be reinstalled after a resume.
+var errRearm = errors.New("re-arming event hooks")
+
+// guidConsoleDisplayState is GUID_CONSOLE_DISPLAY_STATE, notified when the display turns on or off.
+var guidConsoleDisplayState = windows.GUID{Data1: 0x6fe69556, Data2: 0x704a, Data3: 0x47a0, Data4: [8]byte{0x8f, 0x24, 0xc2, 0x8d, 0x93, 0x6f, 0xda, 0x47}}
+
+var (
+	procRegisterPowerSettingNotification   = user32.NewProc("RegisterPowerSettingNotification")
+	procUnregisterPowerSettingNotification = user32.NewProc("UnregisterPowerSettingNotification")
+	procSetTimer                           = user32.NewProc("SetTimer")
+	procKillTimer                          = user32.NewProc("KillTimer")
+)
+
+// Hook re-establishment settings used when the message loop fails.
+const (
+	maxHookAttempts  = 3
+	hookRetryBackoff = time.Second
+)
+
+// Restart settings used when the event watcher panics.
+const (
+	maxWatcherRestarts  = 5
+	watcherRestartDelay = 3 * time.Second
+)
+
+// eventsReceived, hookRearms and watcherRestarts count WinEvents delivered, hook re-installs
+// and restarts after a crash since startup.
+var eventsReceived, hookRearms, watcherRestarts atomic.Uint64
+
+// EventStats returns how many WinEvents the event watcher has received, how often it has
+// re-armed its hooks and how often it was restarted after a crash, so a watcher that silently
+// stopped receiving events or keeps crashing can be spotted.
+func EventStats() (events, rearms, restarts uint64) {
+	return eventsReceived.Load(), hookRearms.Load(), watcherRestarts.Load()
+}
+
+// Windows only has room for a limited number of syscall.NewCallback callbacks per process and
+// they are never freed, so every hook uses the one winEventProc, however often a watcher is
+// re-armed or restarted. runEventHooks registers each hook handle in hookEvents with the channel
+// its events are signalled on; events for a hook that is not registered are dropped.
+var (
+	winEventProc = syscall.NewCallback(func(hWinEventHook syscall.Handle, event uint32, hwnd syscall.Handle, idObject int32, idChild int32, idEventThread uint32, dwmsEventTime uint32) uintptr {
+		events, ok := hookEvents.Load(uintptr(hWinEventHook))
+		if !ok {
+			return 0
+		}
+		eventsReceived.Add(1)
+		lastEvent.Store(time.Now().UnixNano())
+		signalEvent(events.(chan<- struct{}))
+		return 0
+	})
+	hookEvents sync.Map
+)
+
+// eventProcs are the procedures the event watcher cannot run without.
+var eventProcs = []*windows.LazyProc{
+	procSetWinEventHook, procUnhookWinEvent, procGetMessageW, procTranslateMessage,
+	procDispatchMessageW, procPeekMessageW, procPostThreadMessageW, procSetTimer, procKillTimer,
+}
+
+// InitWatcher checks that the procedures the event watcher needs can be loaded, which is not
+// the case on some unusual Windows builds, Server Core or Wine. The error names the missing
+// procedure, so callers can fall back to StartPollWatcher instead.
+func InitWatcher() error {
+	for _, proc := range eventProcs {
+		if err := proc.Find(); err != nil {
+			return fmt.Errorf("event hooks are not available, %s cannot be loaded: %w", proc.Name, err)
+		}
+	}
+	return nil
+}
+
+// CheckEventHooks installs the event hooks and removes them again straight away, to check that
+// they can be set without starting a watcher.
+func CheckEventHooks() error {
+	if err := InitWatcher(); err != nil {
+		return err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	for _, events := range [][2]uintptr{{eventSystemForeground, eventSystemForeground}, {eventObjectCreate, eventObjectDestroy}} {
+		hook, _, err := procSetWinEventHook.Call(events[0], events[1], 0, winEventProc, 0, 0, wndOutofcontext)
+		if hook == 0 {
+			return fmt.Errorf("SetWinEventHook failed: %w", err)
+		}
+		procUnhookWinEvent.Call(hook)
+	}
+	return nil
+}
+
+// StartEventWatcher sets up Windows event hooks to listen for system events.
+// The returned channel receives an error if the watcher gives up, and is closed when it exits.
+func StartEventWatcher(handler func()) <-chan error {
+	return StartEventWatcherContext(context.Background(), handler)
+}
+
+// StartEventWatcherContext is like StartEventWatcher but stops when ctx is cancelled.
+// The hooks are reinstalled, and handler called, when the system resumes from sleep or the
+// display wakes up, since hooks can silently stop delivering events after that.
+// handler runs on its own goroutine rather than in the hook callback, once for any number of
+// events that arrive while it is running, and a panic in it is logged instead of crashing.
+// The returned channel is closed once the message loop has exited and both hooks are removed;
+// it receives nil first on a clean shutdown, or the final error if the hooks could not be kept alive.
+func StartEventWatcherContext(ctx context.Context, handler func()) <-chan error {
+	return startEventWatcher(ctx, 0, handler)
+}
+
+// StartTimedEventWatcher is StartEventWatcherContext with a safety net on the same thread: a
+// WM_TIMER in the message loop also calls handler every interval, so a change the hooks missed
+// is still picked up without a separate poll goroutine. A tick while a check is already pending
+// is absorbed by it. Intervals below MinPollInterval are raised to it.
+func StartTimedEventWatcher(ctx context.Context, interval time.Duration, handler func()) <-chan error {
+	if interval < MinPollInterval {
+		logging.Warnf("Re-scan interval %v is too short, using %v instead.", interval, MinPollInterval)
+		interval = MinPollInterval
+	}
+	return startEventWatcher(ctx, interval, handler)
+}
+
+// startEventWatcher runs the event watcher, with a WM_TIMER re-scan every interval unless it is 0.
+func startEventWatcher(ctx context.Context, interval time.Duration, handler func()) <-chan error {
+	// winEventProc only signals; handler runs on a separate goroutine so a panic or a
+	// slow check never happens inside the callback.
+	errs := make(chan error, 1)
+	if err := InitWatcher(); err != nil {
+		errs <- err
+		close(errs)
+		return errs
+	}
+	events := make(chan struct{}, 1)
+
+	eventWatchers.Add(1)
+	go func() {
+		defer close(errs)
+		defer eventWatchers.Add(-1)
+		for restarts := 0; ; restarts++ {
+			crashed, err := watchEvents(ctx, interval, handler, events)
+			if !crashed {
+				errs <- err
+				return
+			}
+			watcherRestarts.Add(1)
+			if restarts == maxWatcherRestarts {
+				errs <- fmt.Errorf("event watcher crashed %d times, giving up: %w", restarts+1, err)
+				return
+			}
+			logging.Errorf("Event watcher crashed (restart %d of %d in %v): %v", restarts+1, maxWatcherRestarts, watcherRestartDelay, err)
+			select {
+			case <-ctx.Done():
+				errs <- nil
+				return
+			case <-time.After(watcherRestartDelay):
+			}
+		}
+	}()
+	return errs
+}
+
+// watchEvents runs the message loop with the hooks installed until ctx is cancelled, which
+// returns nil, or the hooks cannot be kept alive. A panic is recovered, logged with its stack
+// and reported as crashed with an error, after the hooks have been removed.
+func watchEvents(ctx context.Context, interval time.Duration, handler func(), events chan struct{}) (crashed bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Errorf("The event watcher panicked: %v\n%s", r, debug.Stack())
+			crashed, err = true, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	// WinEvent hooks are delivered to the thread that installed them, so the
+	// message loop must stay on this OS thread for its whole lifetime.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// Make sure this thread has a message queue before anyone posts WM_QUIT to it.
+	var msg struct{ Hwnd, Message, WParam, LParam, Time, Pt uintptr }
+	procPeekMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0, pmNoRemove)
+	threadID := windows.GetCurrentThreadId()
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go consumeEvents(events, stopped, handler)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ret, _, err := procPostThreadMessageW.Call(uintptr(threadID), wmQuit, 0, 0)
+			if ret == 0 {
+				logging.Warnf("Failed to post WM_QUIT to event watcher: %v", err)
+			}
+		case <-stopped:
+		}
+	}()
+	defer watchForResume(ctx, threadID)()
+
+	backoff := hookRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := runEventHooks(interval, events)
+		if errors.Is(err, errRearm) {
+			logging.Infof("System resumed or display woke up. Re-arming event hooks.")
+			hookRearms.Add(1)
+			signalEvent(events)
+			attempt = 0
+			continue
+		}
+		if err == nil {
+			return false, nil
+		}
+		logging.Warnf("Event watcher failed (attempt %d of %d): %v", attempt, maxHookAttempts, err)
+		if attempt == maxHookAttempts {
+			return false, fmt.Errorf("event watcher gave up after %d attempts: %w", attempt, err)
+		}
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// signalEvent asks consumeEvents to run the handler. A signal that is already pending covers
+// this one, so it never blocks.
+func signalEvent(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
+
+// consumeEvents runs handler once for each signal on events until stopped is closed.
+func consumeEvents(events <-chan struct{}, stopped <-chan struct{}, handler func()) {
+	for {
+		select {
+		case <-stopped:
+			return
+		case <-events:
+			runRecovered(handler)
+		}
+	}
+}
+
+// runRecovered runs handler, logging a panic with its stack instead of crashing.
+func runRecovered(handler func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Errorf("The event handler panicked: %v\n%s", r, debug.Stack())
+		}
+	}()
+	handler()
+}
+
+// runEventHooks installs the WinEvent hooks and pumps messages until WM_QUIT, which returns nil,
+// or until wmRearmHooks, which returns errRearm. Hooks are always removed before returning.
+// With an interval above 0, a thread timer also signals events every interval.
+func runEventHooks(interval time.Duration, events chan<- struct{}) error {
+	hookForeground, _, err := procSetWinEventHook.Call(eventSystemForeground, eventSystemForeground, 0, winEventProc, 0, 0, wndOutofcontext)
+	if hookForeground == 0 {
+		return fmt.Errorf("could not set foreground event hook: %w", err)
+	}
+	hookEvents.Store(hookForeground, events)
+	defer func() {
+		hookEvents.Delete(hookForeground)
+		ret, _, err := procUnhookWinEvent.Call(hookForeground)
+		if ret == 0 {
+			logging.Warnf("Failed to unhook foreground event hook: %v", err)
+		}
+	}()
+	hookCreate, _, err := procSetWinEventHook.Call(eventObjectCreate, eventObjectDestroy, 0, winEventProc, 0, 0, wndOutofcontext)
+	if hookCreate == 0 {
+		return fmt.Errorf("could not set create/destroy event hook: %w", err)
+	}
+	hookEvents.Store(hookCreate, events)
+	defer func() {
+		hookEvents.Delete(hookCreate)
+		ret, _, err := procUnhookWinEvent.Call(hookCreate)
+		if ret == 0 {
+			logging.Warnf("Failed to unhook create/destroy event hook: %v", err)
+		}
+	}()
+
+	// logging.Infof("Event hooks set. Listening for system events...")
+
+	if interval > 0 {
+		// A timer without a window posts WM_TIMER to this thread's queue, read by the loop below.
+		timer, _, err := procSetTimer.Call(0, 0, uintptr(interval.Milliseconds()), 0)
+		if timer == 0 {
+			return fmt.Errorf("could not set the re-scan timer: %w", err)
+		}
+		defer procKillTimer.Call(0, timer)
+	}
+
+	installed := time.Now()
+	hooksInstalled.Store(installed.UnixNano())
+	var msg struct{ Hwnd, Message, WParam, LParam, Time, Pt uintptr }
+	for {
+		ret, _, err := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+		switch int32(ret) {
+		case 0: // WM_QUIT
+			return nil
+		case -1:
+			return fmt.Errorf("GetMessageW failed: %w", err)
+		}
+		if uint32(msg.Message) == wmTimer && msg.Hwnd == 0 {
+			// Not counted as an event, so the heartbeat still only reflects the hooks.
+			signalEvent(events)
+			continue
+		}
+		if uint32(msg.Message) == wmRearmHooks {
+			if time.Since(installed) > rearmGrace {
+				return errRearm
+			}
+			continue
+		}
+		// TranslateMessage and DispatchMessageW return values are not error indicators.
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&msg)))
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
+	}
+}
+
+// watchForResume posts wmRearmHooks to the event watcher's thread when the system resumes from
+// sleep or the display state changes. WM_POWERBROADCAST is only sent to top-level windows, so
+// it uses its own hidden window. The returned function stops it and waits for it to exit.
+func watchForResume(ctx context.Context, threadID uint32) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	errs := startMessageWindow(ctx, resumeWindowClass, func(msg uint32, wParam uintptr) bool {
+		if msg == wmPowerBroadcast && (wParam == pbtAPMResumeAutomatic || wParam == pbtPowerSettingChange) {
+			procPostThreadMessageW.Call(uintptr(threadID), wmRearmHooks, 0, 0)
+		}
+		// DefWindowProcW answers WM_POWERBROADCAST with TRUE, as Windows expects.
+		return false
+	}, func(hwnd uintptr) func() {
+		if err := procRegisterPowerSettingNotification.Find(); err != nil {
+			logging.Debugf("Cannot watch the display state, only resume from sleep re-arms the hooks: %v", err)
+			return func() {}
+		}
+		handle, _, err := procRegisterPowerSettingNotification.Call(hwnd, uintptr(unsafe.Pointer(&guidConsoleDisplayState)), 0)
+		if handle == 0 {
+			logging.Debugf("Cannot watch the display state, only resume from sleep re-arms the hooks: %v", err)
+			return func() {}
+		}
+		return func() { procUnregisterPowerSettingNotification.Call(handle) }
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := <-errs; err != nil {
+			logging.Warnf("Event hooks will not be re-armed after sleep: %v", err)
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
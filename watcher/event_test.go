@@ -0,0 +1,129 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestEventWatcherStopsOnCancel(t *testing.T) {
+	tests := []struct {
+		name  string
+		start func(ctx context.Context, handler func()) <-chan error
+	}{
+		{"StartEventWatcherContext", StartEventWatcherContext},
+		{"StartTimedEventWatcher", func(ctx context.Context, handler func()) <-chan error {
+			return StartTimedEventWatcher(ctx, MinPollInterval, handler)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errs := tt.start(ctx, func() {})
+			// Give the message loop time to install its hooks.
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			timeout := time.After(5 * time.Second)
+			select {
+			case err := <-errs:
+				if err != nil {
+					t.Fatalf("the watcher returned %v, want nil after cancel", err)
+				}
+			case <-timeout:
+				t.Fatal("the watcher did not stop within 5s of cancel")
+			}
+			select {
+			case _, open := <-errs:
+				if open {
+					t.Fatal("the watcher sent a second result")
+				}
+			case <-timeout:
+				t.Fatal("the watcher's channel was not closed")
+			}
+			if n := eventWatchers.Load(); n != 0 {
+				t.Fatalf("%d event watchers still running", n)
+			}
+		})
+	}
+}
+
+var (
+	procFindWindowW  = user32.NewProc("FindWindowW")
+	procSendMessageW = user32.NewProc("SendMessageW")
+)
+
+// waitFor polls cond until it holds or 5s have passed.
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestEventWatcherRearmsOnResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan struct{}, 1)
+	installedBefore := hooksInstalled.Load()
+	errs := StartEventWatcherContext(ctx, func() {
+		select {
+		case handled <- struct{}{}:
+		default:
+		}
+	})
+	defer func() {
+		cancel()
+		<-errs
+	}()
+	class, _ := windows.UTF16PtrFromString(resumeWindowClass)
+	var hwnd uintptr
+	if !waitFor(func() bool {
+		hwnd, _, _ = procFindWindowW.Call(uintptr(unsafe.Pointer(class)), 0)
+		return hwnd != 0 && hooksInstalled.Load() != installedBefore
+	}) {
+		t.Fatal("the hooks and the resume window were not set up within 5s")
+	}
+
+	tests := []struct {
+		name string
+		// wait is how long after the hooks were installed the resume is reported.
+		wait      time.Duration
+		wantRearm bool
+	}{
+		{"within the grace period", 0, false},
+		{"after the grace period", rearmGrace + 100*time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installed := hooksInstalled.Load()
+			time.Sleep(time.Until(time.Unix(0, installed).Add(tt.wait)))
+			rearms := hookRearms.Load()
+			select {
+			case <-handled:
+			default:
+			}
+			// The resume window posts wmRearmHooks to the watcher's thread.
+			procSendMessageW.Call(hwnd, wmPowerBroadcast, pbtAPMResumeAutomatic, 0)
+			if !tt.wantRearm {
+				time.Sleep(200 * time.Millisecond)
+				if n := hookRearms.Load(); n != rearms {
+					t.Fatalf("the hooks were re-armed %d times within %v of installing them", n-rearms, rearmGrace)
+				}
+				return
+			}
+			if !waitFor(func() bool { return hookRearms.Load() == rearms+1 && hooksInstalled.Load() != installed }) {
+				t.Fatalf("the hooks were not re-installed: %d re-arms", hookRearms.Load()-rearms)
+			}
+			select {
+			case <-handled:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the handler was not called after re-arming")
+			}
+		})
+	}
+}
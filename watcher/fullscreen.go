@@ -0,0 +1,134 @@
+package watcher
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const monitorDefaultToNearest = 0x00000002
+
+// Window style bits and notification states used to classify a window's display state.
+const (
+	wsCaption    = 0x00C00000
+	wsThickFrame = 0x00040000
+
+	qunsRunningD3DFullScreen = 3
+)
+
+// gwlStyle is GWL_STYLE. It is negative, so it is a variable to allow passing it as a uintptr.
+var gwlStyle int32 = -16
+
+var (
+	procGetWindowLongW = user32.NewProc("GetWindowLongW")
+
+	shell32                          = windows.NewLazySystemDLL("shell32.dll")
+	procSHQueryUserNotificationState = shell32.NewProc("SHQueryUserNotificationState")
+)
+
+// monitorInfo mirrors the Win32 MONITORINFO structure.
+type monitorInfo struct {
+	cbSize    uint32
+	rcMonitor windows.Rect
+	rcWork    windows.Rect
+	dwFlags   uint32
+}
+
+// WindowDisplayState is how a window is shown on its monitor.
+type WindowDisplayState int
+
+const (
+	// DisplayUnknown means the state was not determined, as for matches outside the foreground.
+	DisplayUnknown WindowDisplayState = iota
+	// DisplayWindowed is a normal window, including a maximized one with a title bar.
+	DisplayWindowed
+	// DisplayBorderless is a window without a title bar or sizing border that fills its
+	// monitor, or at least the monitor's work area.
+	DisplayBorderless
+	// DisplayExclusiveFullscreen is a Direct3D application running in exclusive fullscreen.
+	DisplayExclusiveFullscreen
+)
+
+func (s WindowDisplayState) String() string {
+	switch s {
+	case DisplayWindowed:
+		return "windowed"
+	case DisplayBorderless:
+		return "borderless"
+	case DisplayExclusiveFullscreen:
+		return "exclusive fullscreen"
+	}
+	return "unknown"
+}
+
+// Fullscreen reports whether the state is borderless or exclusive fullscreen.
+func (s WindowDisplayState) Fullscreen() bool {
+	return s == DisplayBorderless || s == DisplayExclusiveFullscreen
+}
+
+// isForegroundFullscreen reports whether the foreground window is borderless or exclusive
+// fullscreen. Windows with a title bar do not count, even when maximized.
+func isForegroundFullscreen() bool {
+	hwnd, _, _ := procGetForegroundWindow.Call()
+	if hwnd == 0 {
+		return false
+	}
+	return windowDisplayState(windows.HWND(hwnd)).Fullscreen()
+}
+
+// windowDisplayState classifies hwnd on the monitor it is on. The desktop and shell windows
+// are never treated as fullscreen applications.
+func windowDisplayState(hwnd windows.HWND) WindowDisplayState {
+	desktop, _, _ := procGetDesktopWindow.Call()
+	shell, _, _ := procGetShellWindow.Call()
+	if uintptr(hwnd) == desktop || uintptr(hwnd) == shell {
+		return DisplayWindowed
+	}
+
+	var wr windows.Rect
+	if ret, _, _ := procGetWindowRect.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&wr))); ret == 0 {
+		return DisplayUnknown
+	}
+	monitor, _, _ := procMonitorFromWindow.Call(uintptr(hwnd), monitorDefaultToNearest)
+	if monitor == 0 {
+		return DisplayUnknown
+	}
+	mi := monitorInfo{cbSize: uint32(unsafe.Sizeof(monitorInfo{}))}
+	if ret, _, _ := procGetMonitorInfoW.Call(monitor, uintptr(unsafe.Pointer(&mi))); ret == 0 {
+		return DisplayUnknown
+	}
+	style, _, _ := procGetWindowLongW.Call(uintptr(hwnd), uintptr(gwlStyle))
+	return classifyWindow(uint32(style), wr, mi.rcMonitor, mi.rcWork, d3dFullscreenRunning())
+}
+
+// classifyWindow decides a window's display state from its style bits and rectangle, the
+// rectangle and work area of its monitor, and whether Windows reports a Direct3D application
+// in exclusive fullscreen.
+func classifyWindow(style uint32, window, monitor, work windows.Rect, d3dFullscreen bool) WindowDisplayState {
+	if !covers(window, work) {
+		return DisplayWindowed
+	}
+	if d3dFullscreen && covers(window, monitor) {
+		return DisplayExclusiveFullscreen
+	}
+	if style&wsCaption == wsCaption || style&wsThickFrame != 0 {
+		return DisplayWindowed
+	}
+	return DisplayBorderless
+}
+
+// covers reports whether outer contains inner.
+func covers(outer, inner windows.Rect) bool {
+	return outer.Left <= inner.Left && outer.Top <= inner.Top && outer.Right >= inner.Right && outer.Bottom >= inner.Bottom
+}
+
+// d3dFullscreenRunning reports whether Windows says a Direct3D application is running in
+// exclusive fullscreen mode.
+func d3dFullscreenRunning() bool {
+	var state int32
+	if err := procSHQueryUserNotificationState.Find(); err != nil {
+		return false
+	}
+	ret, _, _ := procSHQueryUserNotificationState.Call(uintptr(unsafe.Pointer(&state)))
+	return ret == 0 && state == qunsRunningD3DFullScreen
+}
@@ -0,0 +1,49 @@
+package watcher
+
+import (
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestClassifyWindow(t *testing.T) {
+	const wsPopup = 0x80000000
+	monitor := windows.Rect{Left: 0, Top: 0, Right: 1920, Bottom: 1080}
+	work := windows.Rect{Left: 0, Top: 0, Right: 1920, Bottom: 1040}
+	second := windows.Rect{Left: 1920, Top: 0, Right: 4480, Bottom: 1440}
+	tests := []struct {
+		name   string
+		style  uint32
+		window windows.Rect
+		mon    windows.Rect
+		work   windows.Rect
+		d3d    bool
+		want   WindowDisplayState
+	}{
+		{"borderless popup", wsPopup, monitor, monitor, work, false, DisplayBorderless},
+		{"borderless larger than the monitor", wsPopup, windows.Rect{Left: -8, Top: -8, Right: 1928, Bottom: 1088}, monitor, work, false, DisplayBorderless},
+		{"borderless over the work area only", wsPopup, work, monitor, work, false, DisplayBorderless},
+		{"maximized with a title bar", wsCaption | wsThickFrame, work, monitor, work, false, DisplayWindowed},
+		{"caption without a sizing border", wsCaption, monitor, monitor, work, false, DisplayWindowed},
+		{"sizing border without a caption", wsThickFrame, monitor, monitor, work, false, DisplayWindowed},
+		{"one caption bit only", wsCaption &^ 0x00400000, monitor, monitor, work, false, DisplayBorderless},
+		{"small borderless window", wsPopup, windows.Rect{Left: 100, Top: 100, Right: 900, Bottom: 700}, monitor, work, false, DisplayWindowed},
+		{"exclusive fullscreen", wsPopup, monitor, monitor, work, true, DisplayExclusiveFullscreen},
+		{"exclusive fullscreen with a caption", wsCaption | wsThickFrame, monitor, monitor, work, true, DisplayExclusiveFullscreen},
+		{"Direct3D running but the window only covers the work area", wsPopup, work, monitor, work, true, DisplayBorderless},
+		{"Direct3D running but the window is small", wsPopup, windows.Rect{Left: 100, Top: 100, Right: 900, Bottom: 700}, monitor, work, true, DisplayWindowed},
+		{"borderless on the second monitor", wsPopup, second, second, second, false, DisplayBorderless},
+		{"first monitor's size on the second monitor", wsPopup, monitor, second, second, false, DisplayWindowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyWindow(tt.style, tt.window, tt.mon, tt.work, tt.d3d)
+			if got != tt.want {
+				t.Fatalf("classifyWindow = %v, want %v", got, tt.want)
+			}
+			if got.Fullscreen() != (tt.want != DisplayWindowed) {
+				t.Fatalf("%v.Fullscreen() = %v", got, got.Fullscreen())
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package watcher
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HealthThreshold is how long the event watcher may go without receiving an event before
+// Healthy reports it as not delivering events.
+const HealthThreshold = DefaultHeartbeatTimeout
+
+var (
+	// lastEvent and hooksInstalled hold, in Unix nanoseconds, when the last WinEvent arrived
+	// and when the hooks were last installed.
+	lastEvent, hooksInstalled atomic.Int64
+	// eventWatchers and pollWatchers count the watchers that are running.
+	eventWatchers, pollWatchers atomic.Int32
+)
+
+// LastEventTime returns when the event watcher last received a WinEvent, or the zero time if
+// it has not received any.
+func LastEventTime() time.Time {
+	if t := lastEvent.Load(); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// Healthy reports whether the watchers are alive. While an event watcher runs, it is healthy
+// if an event arrived, or its hooks were installed, within HealthThreshold. Otherwise it is
+// healthy while a poll watcher runs, since polling does not depend on events. It is safe to
+// call from any goroutine.
+func Healthy() bool {
+	if eventWatchers.Load() > 0 {
+		return time.Since(heartbeat()) < HealthThreshold
+	}
+	return pollWatchers.Load() > 0
+}
+
+// heartbeat returns the later of the last event and the last time the hooks were installed.
+func heartbeat() time.Time {
+	return time.Unix(0, max(lastEvent.Load(), hooksInstalled.Load()))
+}
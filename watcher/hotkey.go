@@ -0,0 +1,132 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// RegisterHotKey modifiers and messages.
+const (
+	modAlt      = 0x0001
+	modControl  = 0x0002
+	modShift    = 0x0004
+	modWin      = 0x0008
+	modNoRepeat = 0x4000
+
+	wmHotkey = 0x0312
+
+	errorHotkeyAlreadyRegistered = windows.Errno(1409)
+)
+
+// Hotkey is a parsed global keyboard shortcut.
+type Hotkey struct {
+	Modifiers uint32
+	Key       uint32
+}
+
+// ParseHotkey parses a shortcut such as "Ctrl+Alt+P" or "Shift+F9". Modifiers are Ctrl, Alt,
+// Shift and Win; the key is a letter, a digit or F1-F24. Case and spaces are ignored.
+func ParseHotkey(s string) (Hotkey, error) {
+	var h Hotkey
+	parts := strings.Split(strings.ToLower(strings.ReplaceAll(s, " ", "")), "+")
+	for _, part := range parts[:len(parts)-1] {
+		switch part {
+		case "ctrl", "control":
+			h.Modifiers |= modControl
+		case "alt":
+			h.Modifiers |= modAlt
+		case "shift":
+			h.Modifiers |= modShift
+		case "win":
+			h.Modifiers |= modWin
+		default:
+			return Hotkey{}, fmt.Errorf("unknown modifier %q in hotkey %q", part, s)
+		}
+	}
+	key := parts[len(parts)-1]
+	switch {
+	case len(key) == 1 && (key[0] >= 'a' && key[0] <= 'z' || key[0] >= '0' && key[0] <= '9'):
+		// Virtual-key codes for letters and digits are their uppercase ASCII codes.
+		h.Key = uint32(strings.ToUpper(key)[0])
+	case strings.HasPrefix(key, "f"):
+		n, err := strconv.Atoi(key[1:])
+		if err != nil || n < 1 || n > 24 {
+			return Hotkey{}, fmt.Errorf("unknown key %q in hotkey %q", key, s)
+		}
+		h.Key = 0x70 + uint32(n-1) // VK_F1
+	default:
+		return Hotkey{}, fmt.Errorf("unknown key %q in hotkey %q", key, s)
+	}
+	if h.Modifiers == 0 {
+		return Hotkey{}, fmt.Errorf("hotkey %q needs at least one of Ctrl, Alt, Shift or Win", s)
+	}
+	return h, nil
+}
+
+// StartHotkeyWatcher registers a system-wide hotkey and calls handler each time it is pressed.
+// The returned channel receives the registration error, for example when another application
+// already uses the same shortcut, or nil once ctx is cancelled, and is then closed.
+func StartHotkeyWatcher(ctx context.Context, hotkey Hotkey, handler func()) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		// WM_HOTKEY is posted to the thread that registered the hotkey.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		ret, _, err := procRegisterHotKey.Call(0, 1, uintptr(hotkey.Modifiers|modNoRepeat), uintptr(hotkey.Key))
+		if ret == 0 {
+			if errors.Is(err, errorHotkeyAlreadyRegistered) {
+				errs <- errors.New("the hotkey is already in use by another application")
+			} else {
+				errs <- fmt.Errorf("could not register the hotkey: %w", err)
+			}
+			return
+		}
+		defer func() {
+			if ret, _, err := procUnregisterHotKey.Call(0, 1); ret == 0 {
+				logging.Warnf("Failed to unregister hotkey: %v", err)
+			}
+		}()
+
+		threadID := windows.GetCurrentThreadId()
+		stopped := make(chan struct{})
+		defer close(stopped)
+		go func() {
+			select {
+			case <-ctx.Done():
+				ret, _, err := procPostThreadMessageW.Call(uintptr(threadID), wmQuit, 0, 0)
+				if ret == 0 {
+					logging.Warnf("Failed to post WM_QUIT to hotkey watcher: %v", err)
+				}
+			case <-stopped:
+			}
+		}()
+
+		var msg struct{ Hwnd, Message, WParam, LParam, Time, Pt uintptr }
+		for {
+			ret, _, err := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+			switch int32(ret) {
+			case 0: // WM_QUIT
+				errs <- nil
+				return
+			case -1:
+				errs <- fmt.Errorf("GetMessageW failed: %w", err)
+				return
+			}
+			if uint32(msg.Message) == wmHotkey {
+				handler()
+			}
+		}
+	}()
+	return errs
+}
@@ -0,0 +1,109 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// Defaults for StartHybridWatcher.
+const (
+	DefaultSafetyPollInterval = 5 * time.Second
+	DefaultHeartbeatTimeout   = 2 * time.Minute
+)
+
+// StartHybridWatcher backs off while re-armed event watchers deliver no events: each re-arm
+// without an event since the previous one doubles the extra wait, from hybridMinRearmBackoff up
+// to hybridMaxRearmBackoff, and an event clears it.
+const (
+	hybridMinRearmBackoff = 30 * time.Second
+	hybridMaxRearmBackoff = 30 * time.Minute
+)
+
+// StartHybridWatcher runs the event hooks together with a low-frequency safety poll.
+// If the event watcher exits, or no event arrives within heartbeatTimeout of the last one or
+// of arming the hooks, the poller re-arms the hooks, waiting longer each time while the
+// re-armed watchers deliver no events. The handler is called on every event and
+// every poll tick, never concurrently. The returned channel behaves like StartEventWatcherContext's.
+func StartHybridWatcher(ctx context.Context, pollInterval, heartbeatTimeout time.Duration, handler func()) <-chan error {
+	if pollInterval < MinPollInterval {
+		logging.Warnf("Poll interval %v is too short, using %v instead.", pollInterval, MinPollInterval)
+		pollInterval = MinPollInterval
+	}
+	var mu sync.Mutex
+	serialHandler := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		handler()
+	}
+	var armed time.Time
+	arm := func() (context.CancelFunc, <-chan error) {
+		armed = time.Now()
+		eventCtx, cancel := context.WithCancel(ctx)
+		return cancel, StartEventWatcherContext(eventCtx, serialHandler)
+	}
+
+	errs := make(chan error, 1)
+	// The safety poll keeps the targets checked even while no events arrive.
+	pollWatchers.Add(1)
+	go func() {
+		defer close(errs)
+		defer pollWatchers.Add(-1)
+		cancelEvents, eventErrs := arm()
+		var backoff time.Duration
+		var stopped time.Time
+		growBackoff := func() {
+			if LastEventTime().After(armed) {
+				backoff = 0
+			} else {
+				backoff = min(max(2*backoff, hybridMinRearmBackoff), hybridMaxRearmBackoff)
+			}
+		}
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				cancelEvents()
+				if eventErrs != nil {
+					<-eventErrs
+				}
+				errs <- nil
+				return
+			case err := <-eventErrs:
+				eventErrs = nil
+				stopped = time.Now()
+				growBackoff()
+				if ctx.Err() == nil {
+					logging.Warnf("Event watcher stopped (%v); relying on the safety poll and re-arming it in %v.", err, backoff)
+				}
+			case <-ticker.C:
+				serialHandler()
+				if eventErrs == nil {
+					if time.Since(stopped) < backoff {
+						continue
+					}
+					logging.Infof("Re-arming the event watcher that stopped %v ago.", time.Since(stopped).Round(time.Second))
+					cancelEvents, eventErrs = arm()
+					continue
+				}
+				last := heartbeat()
+				if armed.After(last) {
+					last = armed
+				}
+				silence := time.Since(last)
+				if silence < heartbeatTimeout+backoff {
+					continue
+				}
+				growBackoff()
+				logging.Warnf("No system events for %v. Re-arming event hooks.", silence.Round(time.Second))
+				cancelEvents()
+				<-eventErrs
+				cancelEvents, eventErrs = arm()
+			}
+		}
+	}()
+	return errs
+}
@@ -0,0 +1,77 @@
+package watcher
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// ProcessInfo is a running process as seen by the process stage.
+type ProcessInfo struct {
+	PID        uint32
+	Executable string
+}
+
+// WindowInfo is a window as seen by the window stage.
+type WindowInfo struct {
+	PID        uint32
+	Title      string
+	Class      string
+	Foreground bool
+}
+
+// ListProcesses returns every running process, sorted by executable name.
+func ListProcesses() ([]ProcessInfo, error) {
+	processes, err := processLister.Processes()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ProcessInfo, 0, len(processes))
+	for _, p := range processes {
+		result = append(result, ProcessInfo{PID: uint32(p.Pid()), Executable: p.Executable()})
+	}
+	slices.SortFunc(result, func(a, b ProcessInfo) int {
+		return strings.Compare(strings.ToLower(a.Executable), strings.ToLower(b.Executable))
+	})
+	return result, nil
+}
+
+// ListWindows returns every visible, non-minimized window with a title, in the order the
+// window stage checks them, marking the foreground window.
+func ListWindows() []WindowInfo {
+	foreground, _, _ := procGetForegroundWindow.Call()
+	var result []WindowInfo
+	enumVisibleWindows(func(hwnd windows.HWND) bool {
+		if title := getWindowText(hwnd); title != "" {
+			result = append(result, WindowInfo{
+				PID:        windowProcessID(hwnd),
+				Title:      title,
+				Class:      getWindowClass(hwnd),
+				Foreground: uintptr(hwnd) == foreground,
+			})
+		}
+		return true
+	})
+	return result
+}
+
+// ForegroundInfo describes the foreground window.
+type ForegroundInfo struct {
+	HWND    uintptr
+	PID     uint32
+	ExePath string
+	Title   string
+}
+
+// CurrentForeground returns the foreground window, or false if there is none. ExePath is
+// empty when the process cannot be opened.
+func CurrentForeground() (ForegroundInfo, bool) {
+	hwnd, _, _ := procGetForegroundWindow.Call()
+	if hwnd == 0 {
+		return ForegroundInfo{}, false
+	}
+	pid := windowProcessID(windows.HWND(hwnd))
+	path, _ := processImagePath(pid)
+	return ForegroundInfo{HWND: hwnd, PID: pid, ExePath: path, Title: getWindowText(windows.HWND(hwnd))}, true
+}
@@ -0,0 +1,169 @@
+package watcher
+
+import (
+	"time"
+
+	"github.com/mitchellh/go-ps"
+	"golang.org/x/sys/windows"
+)
+
+// Process is a running process as seen by the process stage. ps.Process satisfies it.
+type Process interface {
+	Pid() int
+	PPid() int
+	Executable() string
+}
+
+// ProcessLister lists the running processes.
+type ProcessLister interface {
+	Processes() ([]Process, error)
+}
+
+// Window is a visible, non-minimized top-level window as seen by the window stage.
+type Window interface {
+	Title() string
+	Class() string
+	PID() uint32
+}
+
+// WindowEnumerator calls fn for each visible, non-minimized top-level window until fn returns false.
+type WindowEnumerator interface {
+	VisibleWindows(fn func(w Window) bool)
+}
+
+// source is everything the detection stages read from the system: Win32 for FirstActive, or a
+// DetectionState for Decide.
+type source interface {
+	// foreground returns the foreground window, or false if there is none.
+	foreground() (foregroundWindow, bool)
+	processes() ([]Process, error)
+	// imagePath returns the full executable path of pid, or "" if it cannot be read.
+	imagePath(pid uint32) string
+	// commandLine returns the command line of pid, or "" if it cannot be read.
+	commandLine(pid uint32) string
+	visibleWindows(fn func(w Window) bool)
+	// audioSessions returns the PIDs playing sound on the default output device.
+	audioSessions() (map[uint32]bool, error)
+}
+
+// foregroundWindow is the foreground window as seen by the foreground stage.
+type foregroundWindow interface {
+	Window
+	// handle is the window's HWND, or 0 if it has none.
+	handle() uintptr
+	displayState() WindowDisplayState
+	// versionInfo and aumid describe the window's executable, whose path is given.
+	versionInfo(exePath string) versionInfo
+	aumid(exePath string) string
+	// pathUnreadable is called when the window's executable path cannot be read.
+	pathUnreadable()
+}
+
+// processLister and windowEnumerator are what the process and window stages read from.
+// Tests can replace them with fakes to exercise matching without a Windows session.
+var (
+	processLister    ProcessLister    = psLister{}
+	windowEnumerator WindowEnumerator = win32Windows{}
+)
+
+// psLister lists processes with go-ps.
+type psLister struct{}
+
+func (psLister) Processes() ([]Process, error) {
+	processes, err := ps.Processes()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Process, len(processes))
+	for i, p := range processes {
+		result[i] = p
+	}
+	return result, nil
+}
+
+// win32Windows enumerates windows with EnumWindows.
+type win32Windows struct{}
+
+func (win32Windows) VisibleWindows(fn func(w Window) bool) {
+	enumVisibleWindows(func(hwnd windows.HWND) bool {
+		return fn(win32Window(hwnd))
+	})
+}
+
+// win32Window reads a window's properties through Win32 each time they are asked for.
+type win32Window windows.HWND
+
+func (w win32Window) Title() string { return getWindowText(windows.HWND(w)) }
+func (w win32Window) Class() string { return getWindowClass(windows.HWND(w)) }
+func (w win32Window) PID() uint32   { return windowProcessID(windows.HWND(w)) }
+
+// win32Source reads the system through Win32, sharing the package's process and window caches.
+type win32Source struct {
+	processTTL time.Duration
+	windowTTL  time.Duration
+}
+
+func (win32Source) foreground() (foregroundWindow, bool) {
+	hwnd, _, _ := procGetForegroundWindow.Call()
+	if hwnd == 0 {
+		return nil, false
+	}
+	return &win32Foreground{hwnd: windows.HWND(hwnd), pid: windowProcessID(windows.HWND(hwnd)), title: getWindowText(windows.HWND(hwnd))}, true
+}
+
+func (s win32Source) processes() ([]Process, error) { return cachedProcesses(s.processTTL) }
+
+func (win32Source) imagePath(pid uint32) string {
+	path, _ := processImagePath(pid)
+	return path
+}
+
+func (win32Source) commandLine(pid uint32) string { return processCommandLine(pid) }
+
+func (s win32Source) visibleWindows(fn func(w Window) bool) {
+	if s.windowTTL <= 0 {
+		windowEnumerator.VisibleWindows(fn)
+		return
+	}
+	for _, w := range cachedWindows(s.windowTTL) {
+		if !fn(w) {
+			return
+		}
+	}
+}
+
+func (win32Source) audioSessions() (map[uint32]bool, error) { return activeAudioSessions() }
+
+// win32Foreground is the foreground window. Its class and display state are read on first use.
+type win32Foreground struct {
+	hwnd      windows.HWND
+	pid       uint32
+	title     string
+	class     string
+	classRead bool
+	state     WindowDisplayState
+}
+
+func (w *win32Foreground) Title() string   { return w.title }
+func (w *win32Foreground) PID() uint32     { return w.pid }
+func (w *win32Foreground) handle() uintptr { return uintptr(w.hwnd) }
+
+func (w *win32Foreground) Class() string {
+	if !w.classRead {
+		w.class, w.classRead = getWindowClass(w.hwnd), true
+	}
+	return w.class
+}
+
+func (w *win32Foreground) displayState() WindowDisplayState {
+	if w.state == DisplayUnknown {
+		w.state = windowDisplayState(w.hwnd)
+	}
+	return w.state
+}
+
+func (w *win32Foreground) versionInfo(exePath string) versionInfo { return exeVersionInfo(exePath) }
+func (w *win32Foreground) aumid(exePath string) string {
+	return foregroundAUMID(w.hwnd, w.pid, exePath)
+}
+func (w *win32Foreground) pathUnreadable() { hintElevation(w.pid) }
@@ -0,0 +1,134 @@
+package watcher
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeLister is a ProcessLister returning fixed processes, counting how often it is asked.
+type fakeLister struct {
+	processes []ProcessState
+	err       error
+	calls     int
+}
+
+func (f *fakeLister) Processes() ([]Process, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return DetectionState{Processes: f.processes}.processes()
+}
+
+// fakeWindows is a WindowEnumerator listing fixed windows, counting the titles read.
+type fakeWindows struct {
+	windows    []WindowInfo
+	titleReads int
+}
+
+func (f *fakeWindows) VisibleWindows(fn func(w Window) bool) {
+	for _, w := range f.windows {
+		if !fn(fakeWindow{w, f}) {
+			return
+		}
+	}
+}
+
+type fakeWindow struct {
+	info WindowInfo
+	from *fakeWindows
+}
+
+func (w fakeWindow) Title() string {
+	w.from.titleReads++
+	return w.info.Title
+}
+func (w fakeWindow) Class() string { return w.info.Class }
+func (w fakeWindow) PID() uint32   { return w.info.PID }
+
+// useFakes makes the process and window stages read from the fakes until the test ends.
+func useFakes(t testing.TB, processes ProcessLister, windows WindowEnumerator) {
+	savedProcesses, savedWindows := processLister, windowEnumerator
+	processLister, windowEnumerator = processes, windows
+	t.Cleanup(func() { processLister, windowEnumerator = savedProcesses, savedWindows })
+}
+
+// backgroundStages leaves out the stages that read the foreground window and audio sessions.
+var backgroundStages = Options{Stages: []Stage{StageProcess, StageWindow}, ProcessCacheTTL: -1}
+
+func TestFirstActiveWithFakes(t *testing.T) {
+	processes := []ProcessState{
+		{PID: 4, Executable: "System"},
+		{PID: 100, Executable: "explorer.exe"},
+		{PID: 200, Executable: "Cyberpunk2077.exe"},
+		{PID: 300, Executable: "steam.exe"},
+	}
+	windows := []WindowInfo{
+		{PID: 100, Title: "Downloads"},
+		{PID: 400, Title: "Elden Ring", Class: "ELDEN RING™"},
+		{PID: 500, Title: "Blender 4.1", Class: "GHOST_WindowClass"},
+	}
+	tests := []struct {
+		name       string
+		targets    []Target
+		wantOK     bool
+		wantKey    string
+		wantSource Source
+		wantPID    uint32
+	}{
+		{"process contains", []Target{{Keyword: "cyberpunk"}}, true, "cyberpunk", SourceProcess, 200},
+		{"process exact", []Target{{Keyword: "cyberpunk2077", Mode: MatchExact}}, true, "cyberpunk2077", SourceProcess, 200},
+		{"process exact needs the whole name", []Target{{Keyword: "cyberpunk", Mode: MatchExact}}, false, "", 0, 0},
+		{"process word", []Target{{Keyword: "steam", Mode: MatchWord}}, true, "steam", SourceProcess, 300},
+		{"process regexp", []Target{{Keyword: `re:^cyber\w+\.exe$`}}, true, `re:^cyber\w+\.exe$`, SourceProcess, 200},
+		{"window contains", []Target{{Keyword: "elden"}}, true, "elden", SourceWindow, 400},
+		{"window exact", []Target{{Keyword: "elden ring", Mode: MatchExact}}, true, "elden ring", SourceWindow, 400},
+		{"window word", []Target{{Keyword: "ring", Mode: MatchWord}}, true, "ring", SourceWindow, 400},
+		{"window word inside a word", []Target{{Keyword: "blend", Mode: MatchWord}}, false, "", 0, 0},
+		{"window class", []Target{{Keyword: "class:ghost_windowclass", Mode: MatchExact}}, true, "class:ghost_windowclass", SourceWindow, 500},
+		{"process stage before window stage", []Target{{Keyword: "elden"}, {Keyword: "steam"}}, true, "steam", SourceProcess, 300},
+		{"title scope skips processes", []Target{{Keyword: "steam", Scope: ScopeTitle}}, false, "", 0, 0},
+		{"exe scope skips windows", []Target{{Keyword: "elden", Scope: ScopeExe}}, false, "", 0, 0},
+		{"own exclusion", []Target{{Keyword: "elden", Exclude: []string{"explorer"}}}, false, "", 0, 0},
+		{"global exclusion", []Target{{Keyword: "elden"}, {Keyword: ExcludePrefix + "explorer"}}, false, "", 0, 0},
+		{"exclusions ignore background windows", []Target{{Keyword: "elden", Exclude: []string{"downloads"}}}, true, "elden", SourceWindow, 400},
+		{"nothing", []Target{{Keyword: "valorant"}}, false, "", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakes(t, &fakeLister{processes: processes}, &fakeWindows{windows: windows})
+			m, ok := FirstActive(tt.targets, backgroundStages)
+			if ok != tt.wantOK || m.Keyword != tt.wantKey || m.Source != tt.wantSource || m.PID != tt.wantPID {
+				t.Fatalf("FirstActive = %q from %v (PID %d), %v; want %q from %v (PID %d), %v",
+					m.Keyword, m.Source, m.PID, ok, tt.wantKey, tt.wantSource, tt.wantPID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFirstActiveListerError(t *testing.T) {
+	useFakes(t, &fakeLister{err: errors.New("access denied")}, &fakeWindows{windows: []WindowInfo{{PID: 1, Title: "Elden Ring"}}})
+	if m, ok := FirstActive([]Target{{Keyword: "elden"}}, backgroundStages); !ok || m.Source != SourceWindow {
+		t.Fatalf("FirstActive = %+v, %v; want the window match after the process list failed", m, ok)
+	}
+	if _, err := ListProcesses(); err == nil {
+		t.Fatal("ListProcesses did not return the lister's error")
+	}
+}
+
+func TestListProcessesSorted(t *testing.T) {
+	useFakes(t, &fakeLister{processes: []ProcessState{{PID: 3, Executable: "steam.exe"}, {PID: 1, Executable: "Explorer.EXE"}, {PID: 2, Executable: "audiodg.exe"}}}, &fakeWindows{})
+	got, err := ListProcesses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ProcessInfo{{2, "audiodg.exe"}, {1, "Explorer.EXE"}, {3, "steam.exe"}}
+	if len(got) != len(want) {
+		t.Fatalf("ListProcesses = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ListProcesses = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,571 @@
+package watcher
+
+import (
+	"reflect"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+)
+
+// RegexPrefix marks a keyword as a regular expression rather than a plain substring.
+const RegexPrefix = "re:"
+
+// ClassPrefix marks a keyword that is matched against a window's class name
+// (e.g. "class:unitywndclass") instead of its title or process name.
+const ClassPrefix = "class:"
+
+// CmdlinePrefix marks a keyword that is matched against a process's full command line
+// (e.g. "cmdline:minecraft"), for telling apart programs that share one executable.
+const CmdlinePrefix = "cmdline:"
+
+// ProductPrefix marks a keyword that is matched against the product or company name in the
+// foreground executable's version resource (e.g. "product:electronic arts").
+const ProductPrefix = "product:"
+
+// AUMIDPrefix marks a keyword that is matched against the AppUserModelID of the foreground
+// UWP or Store app (e.g. "aumid:microsoft.minecraftuwp"), which has no useful exe name.
+const AUMIDPrefix = "aumid:"
+
+// AudioPrefix marks a keyword that is matched against the exe names of the processes playing
+// sound on the default output device (e.g. "audio:spotify"), whatever window is in front.
+const AudioPrefix = "audio:"
+
+// DirPrefix marks a keyword that is matched against the folder the foreground application's
+// executable was started from, including its subfolders (e.g. "dir:d:\emulation").
+const DirPrefix = "dir:"
+
+// ExcludePrefix marks a keyword whose presence vetoes every other match (e.g. "!loading").
+const ExcludePrefix = "!"
+
+// folders reuses case folders, which keep state and cannot be shared between goroutines.
+var folders = sync.Pool{New: func() any { return cases.Fold() }}
+
+// fold case-folds s for comparison. Keywords and the names and titles they are matched
+// against are all folded the same way, so "CAFÉ" matches "café" and "STRASSE" matches "straße".
+func fold(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			c := folders.Get().(cases.Caser)
+			defer folders.Put(c)
+			return c.String(s)
+		}
+	}
+	return strings.ToLower(s)
+}
+
+// regexCache holds compiled keyword patterns so they are not recompiled on every event.
+var regexCache sync.Map
+
+// MatchMode controls how a keyword is compared against process names and window titles.
+type MatchMode int
+
+const (
+	// MatchContains matches when the keyword appears anywhere in the name or title.
+	MatchContains MatchMode = iota
+	// MatchExact matches only when the exe basename (with or without ".exe") or the
+	// whole window title equals the keyword.
+	MatchExact
+	// MatchWord matches when the keyword appears in a window title as a whole word
+	// delimited by non-alphanumeric characters, or equals the exe basename.
+	MatchWord
+)
+
+// Source identifies which detection stage produced a Match.
+type Source int
+
+const (
+	SourceForeground Source = iota
+	SourceProcess
+	SourceWindow
+	// SourceTemperature marks a match made by a GPU temperature rule rather than a keyword.
+	SourceTemperature
+	// SourceSchedule marks a match made by a time-of-day schedule.
+	SourceSchedule
+	// SourcePower marks a match made by an AC or battery power rule.
+	SourcePower
+	// SourceDisplay marks a match made by a display rule.
+	SourceDisplay
+	// SourceAudio marks a match made by StageAudio.
+	SourceAudio
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceForeground:
+		return "foreground"
+	case SourceProcess:
+		return "process"
+	case SourceWindow:
+		return "window"
+	case SourceTemperature:
+		return "temperature"
+	case SourceSchedule:
+		return "schedule"
+	case SourcePower:
+		return "power"
+	case SourceDisplay:
+		return "display"
+	case SourceAudio:
+		return "audio"
+	}
+	return "unknown"
+}
+
+// Stage is one of the detection stages FirstActive runs to look for targets.
+type Stage int
+
+const (
+	// StageForeground checks the foreground window's process and title.
+	StageForeground Stage = iota
+	// StageFullscreen is StageForeground that matches only while the foreground window covers
+	// its whole monitor.
+	StageFullscreen
+	// StageProcess checks every running process's name.
+	StageProcess
+	// StageWindow checks the titles of all visible windows.
+	StageWindow
+	// StageAudio checks the processes playing sound against AudioPrefix keywords. It does
+	// nothing when no target uses the prefix.
+	StageAudio
+)
+
+// DefaultStages is the order FirstActive runs its stages in when Options.Stages is empty.
+var DefaultStages = []Stage{StageForeground, StageProcess, StageWindow, StageAudio}
+
+func (s Stage) String() string {
+	switch s {
+	case StageForeground:
+		return "foreground"
+	case StageFullscreen:
+		return "fullscreen"
+	case StageProcess:
+		return "process"
+	case StageWindow:
+		return "window"
+	case StageAudio:
+		return "audio"
+	}
+	return "unknown"
+}
+
+// ParseStage converts a config value into a Stage.
+func ParseStage(s string) (Stage, bool) {
+	for _, stage := range []Stage{StageForeground, StageFullscreen, StageProcess, StageWindow, StageAudio} {
+		if strings.EqualFold(s, stage.String()) {
+			return stage, true
+		}
+	}
+	return StageForeground, false
+}
+
+// Scope limits where a target's keyword can match. The zero Scope matches everywhere.
+type Scope int
+
+const (
+	// ScopeTitle matches window titles and class names, but not exe names.
+	ScopeTitle Scope = 1 << iota
+	// ScopeExe matches exe names, paths, command lines, version info and AUMIDs, but not titles.
+	ScopeExe
+	// ScopeForeground matches only the foreground window, not background processes or windows.
+	ScopeForeground
+)
+
+// ParseScope converts a config value into a Scope.
+func ParseScope(s string) (Scope, bool) {
+	switch strings.ToLower(s) {
+	case "title":
+		return ScopeTitle, true
+	case "exe":
+		return ScopeExe, true
+	case "foreground":
+		return ScopeForeground, true
+	}
+	return 0, false
+}
+
+// titles reports whether the scope includes window titles.
+func (s Scope) titles() bool { return s&ScopeTitle != 0 || s&ScopeExe == 0 }
+
+// exes reports whether the scope includes exe names.
+func (s Scope) exes() bool { return s&ScopeExe != 0 || s&ScopeTitle == 0 }
+
+// background reports whether the scope includes the process and window stages.
+func (s Scope) background() bool { return s&ScopeForeground == 0 }
+
+// Target is a keyword to look for, together with the profile it selects and how it is matched.
+type Target struct {
+	Keyword string
+	Profile string
+	Mode    MatchMode
+	// Exclude vetoes this target when any of these keywords is found in the
+	// foreground window or the running processes.
+	Exclude []string
+	// Dwell is how long the target must stay the active match before a
+	// TransitionTracker reports it.
+	Dwell time.Duration
+	// Cooldown is how long a TransitionTracker keeps this target once it has been reported,
+	// before it reports a switch to anything else.
+	Cooldown time.Duration
+	// Scope limits where Keyword can match; the zero value matches everywhere.
+	Scope Scope
+	// FullscreenOnly makes the target match only in the foreground window, and only while it
+	// is borderless or exclusive fullscreen.
+	FullscreenOnly bool
+	// MatchDescendants makes the foreground stage also match when the foreground process was
+	// started, directly or further down, by a process matching Keyword, such as a game started
+	// by its launcher.
+	MatchDescendants bool
+	// Tag is caller data copied into the Match, such as the config rule the target came from.
+	Tag any
+}
+
+// match returns a Match for this target found by source.
+func (t Target) match(source Source) Match {
+	return Match{Keyword: t.Keyword, Profile: t.Profile, Dwell: t.Dwell, Cooldown: t.Cooldown, Tag: t.Tag, Source: source}
+}
+
+// Match describes an active target and where it was detected.
+// PID, ExePath and WindowTitle are filled in when the detection stage knows them.
+type Match struct {
+	// Keyword is the keyword that was found. When several targets share a Tag, as the
+	// keywords of one rule do, any one of them matching is enough and this names which.
+	Keyword     string
+	Profile     string
+	Dwell       time.Duration
+	Cooldown    time.Duration
+	Tag         any
+	Source      Source
+	PID         uint32
+	ExePath     string
+	WindowTitle string
+	// HWND is the foreground window a SourceForeground match was found in.
+	HWND uintptr
+	// DisplayState is how the foreground window of a SourceForeground match is shown.
+	DisplayState WindowDisplayState
+	// ExternalDisplay records whether an external monitor was connected. The watcher does
+	// not fill it in; callers that track the display state do.
+	ExternalDisplay bool
+}
+
+// SameTarget reports whether m and other were found for the same target: the same keyword
+// with the same Tag. Two rules can share a keyword with different scopes and profiles, so the
+// keyword alone does not tell them apart. Two zero Matches, for no target, are the same.
+func (m Match) SameTarget(other Match) bool {
+	return m.Keyword == other.Keyword && sameTag(m.Tag, other.Tag)
+}
+
+// Of reports whether m was found for the target t.
+func (m Match) Of(t Target) bool {
+	return m.Keyword == t.Keyword && sameTag(m.Tag, t.Tag)
+}
+
+// sameTag reports whether two Tags are equal. Tags whose values cannot be compared never are.
+func sameTag(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	return va.Type() == vb.Type() && va.Comparable() && vb.Comparable() && va.Equal(vb)
+}
+
+// ParseMatchMode converts a config value into a MatchMode.
+func ParseMatchMode(s string) (MatchMode, bool) {
+	switch strings.ToLower(s) {
+	case "", "contains":
+		return MatchContains, true
+	case "exact":
+		return MatchExact, true
+	case "word":
+		return MatchWord, true
+	}
+	return MatchContains, false
+}
+
+// keywordRegexp returns the cached compiled pattern for a "re:" keyword, rewritten by
+// foldPattern to match the folded text it is applied to.
+// Patterns are validated when the config is loaded, so MustCompile cannot panic here.
+func keywordRegexp(keyword string) (*regexp.Regexp, bool) {
+	pattern, ok := strings.CutPrefix(keyword, RegexPrefix)
+	if !ok {
+		return nil, false
+	}
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), true
+	}
+	re := regexp.MustCompile(foldPattern(pattern))
+	regexCache.Store(pattern, re)
+	return re, true
+}
+
+// foldPattern makes a pattern ignore case the way plain keywords do. Its literal text is folded
+// like the names and titles it is matched against, so "re:^CAFÉ" and "re:straße" match, and the
+// rest ignores case, while escapes like \D keep their meaning.
+func foldPattern(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl|syntax.FoldCase)
+	if err != nil {
+		return pattern
+	}
+	foldLiterals(re)
+	return re.String()
+}
+
+// foldLiterals folds the literal text in re and its subexpressions.
+func foldLiterals(re *syntax.Regexp) {
+	if re.Op == syntax.OpLiteral {
+		re.Rune = []rune(fold(string(re.Rune)))
+	}
+	for _, sub := range re.Sub {
+		foldLiterals(sub)
+	}
+}
+
+// fieldKeyword reports whether a keyword names a specific field to match, such as a window
+// class or command line, instead of the usual exe name and window title.
+func fieldKeyword(keyword string) bool {
+	return strings.HasPrefix(keyword, ClassPrefix) || strings.HasPrefix(keyword, CmdlinePrefix) ||
+		strings.HasPrefix(keyword, ProductPrefix) || strings.HasPrefix(keyword, AUMIDPrefix) ||
+		strings.HasPrefix(keyword, AudioPrefix) || strings.HasPrefix(keyword, DirPrefix)
+}
+
+// matchExeName reports whether a lowercased exe basename satisfies the keyword.
+func matchExeName(lowerExeName, keyword string, mode MatchMode) bool {
+	if fieldKeyword(keyword) {
+		return false
+	}
+	if re, ok := keywordRegexp(keyword); ok {
+		return re.MatchString(lowerExeName)
+	}
+	switch mode {
+	case MatchExact, MatchWord:
+		return lowerExeName == keyword || strings.TrimSuffix(lowerExeName, ".exe") == keyword
+	default:
+		return strings.Contains(lowerExeName, keyword)
+	}
+}
+
+// matchPath reports whether a lowercased full executable path satisfies the keyword.
+func matchPath(lowerPath, keyword string, mode MatchMode) bool {
+	if fieldKeyword(keyword) {
+		return false
+	}
+	if re, ok := keywordRegexp(keyword); ok {
+		return re.MatchString(lowerPath)
+	}
+	switch mode {
+	case MatchExact:
+		return lowerPath == keyword
+	case MatchWord:
+		return containsWord(lowerPath, keyword)
+	default:
+		return strings.Contains(lowerPath, keyword)
+	}
+}
+
+// matchTitle reports whether a lowercased window title satisfies the keyword.
+func matchTitle(lowerTitle, keyword string, mode MatchMode) bool {
+	if fieldKeyword(keyword) {
+		return false
+	}
+	if re, ok := keywordRegexp(keyword); ok {
+		return re.MatchString(lowerTitle)
+	}
+	switch mode {
+	case MatchExact:
+		return lowerTitle == keyword
+	case MatchWord:
+		return containsWord(lowerTitle, keyword)
+	default:
+		return strings.Contains(lowerTitle, keyword)
+	}
+}
+
+// matchClass reports whether a lowercased window class name satisfies a "class:" keyword.
+// Keywords without the prefix never match a class name.
+func matchClass(lowerClass, keyword string, mode MatchMode) bool {
+	return matchField(lowerClass, keyword, ClassPrefix, mode)
+}
+
+// matchCmdline reports whether a lowercased command line satisfies a "cmdline:" keyword.
+// Keywords without the prefix never match a command line.
+func matchCmdline(lowerCmdline, keyword string, mode MatchMode) bool {
+	return matchField(lowerCmdline, keyword, CmdlinePrefix, mode)
+}
+
+// matchProduct reports whether a "product:" keyword matches an executable's product or company name.
+func matchProduct(info versionInfo, keyword string, mode MatchMode) bool {
+	return matchField(info.product, keyword, ProductPrefix, mode) || matchField(info.company, keyword, ProductPrefix, mode)
+}
+
+// matchAUMID reports whether a lowercased AppUserModelID satisfies an "aumid:" keyword.
+func matchAUMID(lowerAUMID, keyword string, mode MatchMode) bool {
+	return matchField(lowerAUMID, keyword, AUMIDPrefix, mode)
+}
+
+// matchAudio reports whether the lowercased exe name of a process playing sound satisfies an
+// "audio:" keyword, compared like an exe name.
+func matchAudio(lowerExeName, keyword string, mode MatchMode) bool {
+	name, ok := strings.CutPrefix(keyword, AudioPrefix)
+	return ok && matchExeName(lowerExeName, name, mode)
+}
+
+// matchDir reports whether a lowercased executable path lies in the folder of a "dir:" keyword
+// or one of its subfolders. Both use backslashes for the comparison, and a trailing one on the
+// folder is ignored. A "re:" pattern after the prefix is matched against the whole path instead.
+func matchDir(lowerPath, keyword string) bool {
+	dir, ok := strings.CutPrefix(keyword, DirPrefix)
+	if !ok || lowerPath == "" {
+		return false
+	}
+	path := strings.ReplaceAll(lowerPath, "/", `\`)
+	if re, ok := keywordRegexp(dir); ok {
+		return re.MatchString(path)
+	}
+	dir = strings.TrimRight(strings.ReplaceAll(dir, "/", `\`), `\`)
+	return dir != "" && strings.HasPrefix(path, dir+`\`)
+}
+
+// matchField matches a field keyword such as "class:..." against its lowercased value.
+// The part after the prefix may itself be a "re:" pattern.
+func matchField(lowerValue, keyword, prefix string, mode MatchMode) bool {
+	name, ok := strings.CutPrefix(keyword, prefix)
+	if !ok || lowerValue == "" {
+		return false
+	}
+	if re, ok := keywordRegexp(name); ok {
+		return re.MatchString(lowerValue)
+	}
+	switch mode {
+	case MatchExact:
+		return lowerValue == name
+	case MatchWord:
+		return containsWord(lowerValue, name)
+	default:
+		return strings.Contains(lowerValue, name)
+	}
+}
+
+// titleSeparator separates a window title from the application name that many programs append.
+const titleSeparator = " - "
+
+// normalizeTitle strips decorations from a lowercased window title: the strip entries at either
+// end (or, for "re:" entries, wherever they match), everything after the last titleSeparator,
+// and repeated whitespace.
+func normalizeTitle(lowerTitle string, strip []string) string {
+	title := lowerTitle
+	for changed := true; changed; {
+		changed = false
+		for _, s := range strip {
+			before := title
+			if re, ok := keywordRegexp(s); ok {
+				title = re.ReplaceAllString(title, "")
+			} else if s != "" {
+				title = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(title, s), s))
+			}
+			changed = changed || title != before
+		}
+	}
+	if i := strings.LastIndex(title, titleSeparator); i > 0 {
+		title = title[:i]
+	}
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// containsWord reports whether keyword occurs in s with no letter or digit directly
+// on either side, so "ark" matches "ark: survival evolved" but not "stardew valley".
+func containsWord(s, keyword string) bool {
+	if keyword == "" {
+		return false
+	}
+	for offset := 0; ; {
+		i := strings.Index(s[offset:], keyword)
+		if i < 0 {
+			return false
+		}
+		start := offset + i
+		end := start + len(keyword)
+		before, _ := utf8.DecodeLastRuneInString(s[:start])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(s[start:])
+		offset = start + size
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// TargetsFromMap converts a keyword->profile map into targets matched with mode. The listed
+// priority keywords come first, in order, followed by the rest alphabetically, so detection
+// does not depend on map iteration order.
+func TargetsFromMap(targets map[string]string, mode MatchMode, priority []string) []Target {
+	keywords := make([]string, 0, len(targets))
+	for _, k := range priority {
+		if _, ok := targets[k]; ok && !slices.Contains(keywords, k) {
+			keywords = append(keywords, k)
+		}
+	}
+	ranked := len(keywords)
+	for k := range targets {
+		if !slices.Contains(keywords[:ranked], k) {
+			keywords = append(keywords, k)
+		}
+	}
+	slices.Sort(keywords[ranked:])
+
+	result := make([]Target, len(keywords))
+	for i, k := range keywords {
+		result[i] = Target{Keyword: k, Profile: targets[k], Mode: mode}
+	}
+	return result
+}
+
+// firstMatching returns the index of the first target before limit for which match is true, or -1.
+func firstMatching(targets []Target, limit int, match func(t Target) bool) int {
+	for i := 0; i < limit && i < len(targets); i++ {
+		if match(targets[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// limitOf converts a best-so-far index (-1 meaning none) into a search limit.
+func limitOf(best int, targets []Target) int {
+	if best < 0 {
+		return len(targets)
+	}
+	return best
+}
+
+// splitExclusions separates ordered targets into inclusions and global exclusions,
+// with ExcludePrefix removed from the latter.
+func splitExclusions(targets []Target) (includes, excludes []Target) {
+	for _, t := range targets {
+		if exclude, ok := strings.CutPrefix(t.Keyword, ExcludePrefix); ok {
+			t.Keyword = exclude
+			excludes = append(excludes, t)
+		} else {
+			includes = append(includes, t)
+		}
+	}
+	return includes, excludes
+}
+
+// exclusionTargets turns a target's Exclude keywords into targets using the target's match mode.
+func exclusionTargets(t Target) []Target {
+	excludes := make([]Target, len(t.Exclude))
+	for i, k := range t.Exclude {
+		excludes[i] = Target{Keyword: k, Mode: t.Mode}
+	}
+	return excludes
+}
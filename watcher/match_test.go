@@ -0,0 +1,207 @@
+package watcher
+
+import "testing"
+
+func TestFoldMatchesBothSides(t *testing.T) {
+	tests := []struct {
+		keyword string
+		title   string
+		want    bool
+	}{
+		{"café", "CAFÉ Manager", true},
+		{"CAFÉ", "café manager", true},
+		{"re:^café", "CAFÉ Manager", true},
+		{"re:^CAFÉ", "café manager", true},
+		{"re:^Café M", "CAFÉ MANAGER", true},
+		{"straße", "STRASSE Racer", true},
+		{"re:^STRASSE", "Straße Racer", true},
+		{"re:straße", "STRASSE Racer", true},
+		// Turkish dotted and dotless I fold differently, but the same way on both sides.
+		{"İstanbul", "İSTANBUL Taxi", true},
+		{"re:^İstanbul", "İSTANBUL Taxi", true},
+		{"ISTANBUL", "istanbul taxi", true},
+		{"ıstanbul", "ISTANBUL Taxi", false},
+		{"re:^ıstanbul", "ISTANBUL Taxi", false},
+		// Escapes keep their meaning when the pattern is folded.
+		{`re:^\D+$`, "DOOM", true},
+		{`re:^\D+$`, "Doom 3", false},
+		{`re:\bARK\b`, "ARK: Survival Evolved", true},
+	}
+	for _, tt := range tests {
+		keyword := tt.keyword
+		if _, ok := keywordRegexp(keyword); !ok {
+			keyword = fold(keyword)
+		}
+		if got := matchTitle(fold(tt.title), keyword, MatchContains); got != tt.want {
+			t.Errorf("matchTitle(%q, %q) = %v, want %v", tt.title, tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestSameTarget(t *testing.T) {
+	title, exe := new(int), new(int)
+	type rule struct{ name string }
+	tests := []struct {
+		name string
+		a, b Match
+		want bool
+	}{
+		{"no target", Match{}, Match{}, true},
+		{"same keyword, no tag", Match{Keyword: "chrome"}, Match{Keyword: "chrome", Source: SourceProcess}, true},
+		{"same keyword, same rule", Match{Keyword: "chrome", Tag: title}, Match{Keyword: "chrome", Tag: title}, true},
+		{"same keyword, other rule", Match{Keyword: "chrome", Tag: title}, Match{Keyword: "chrome", Tag: exe}, false},
+		{"same keyword, tag and none", Match{Keyword: "chrome", Tag: title}, Match{Keyword: "chrome"}, false},
+		{"other keyword, same rule", Match{Keyword: "chrome", Tag: title}, Match{Keyword: "edge", Tag: title}, false},
+		{"equal tag values", Match{Keyword: "on ac power", Tag: rule{"ac"}}, Match{Keyword: "on ac power", Tag: rule{"ac"}}, true},
+		{"tags that cannot be compared", Match{Keyword: "k", Tag: []string{"a"}}, Match{Keyword: "k", Tag: []string{"a"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.SameTarget(tt.b); got != tt.want {
+			t.Errorf("%s: SameTarget = %v, want %v", tt.name, got, tt.want)
+		}
+		if got := tt.a.Of(Target{Keyword: tt.b.Keyword, Tag: tt.b.Tag}); got != tt.want {
+			t.Errorf("%s: Of = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchExeNameExact(t *testing.T) {
+	tests := []struct {
+		exe     string
+		keyword string
+		want    bool
+	}{
+		{"doom.exe", "doom", true},
+		{"doom.exe", "doom.exe", true},
+		{"DOOM.EXE", "doom", true},
+		{"doometernal.exe", "doom", false},
+		{"doometernalx64vk.exe", "doom", false},
+		{"doom", "doom", true},
+		{"mydoom.exe", "doom", false},
+	}
+	for _, tt := range tests {
+		if got := matchExeName(fold(tt.exe), tt.keyword, MatchExact); got != tt.want {
+			t.Errorf("matchExeName(%q, %q, MatchExact) = %v, want %v", tt.exe, tt.keyword, got, tt.want)
+		}
+	}
+
+	targets := []Target{{Keyword: "doom", Profile: "2", Mode: MatchExact}}
+	state := DetectionState{Processes: []ProcessState{{PID: 7, Executable: "DOOMEternalx64vk.exe"}}}
+	if m, ok := Decide(state, targets, Options{}); ok {
+		t.Errorf("Decide with only doometernal running matched %+v, want no match", m)
+	}
+	state.Processes = append(state.Processes, ProcessState{PID: 8, Executable: "doom.exe"})
+	if m, ok := Decide(state, targets, Options{}); !ok || m.PID != 8 {
+		t.Errorf("Decide with doom.exe running = %+v, %v, want the match for PID 8", m, ok)
+	}
+}
+
+func TestMatchTitleWord(t *testing.T) {
+	tests := []struct {
+		title   string
+		keyword string
+		want    bool
+	}{
+		{"ARK: Survival Evolved", "ark", true},
+		{"Stardew Valley", "ark", false},
+		{"Dark Souls III", "ark", false},
+		{"Arkham Knight", "ark", false},
+		{"ark", "ark", true},
+		{"Playing (ARK)", "ark", true},
+		{"ARK2", "ark", false},
+		{"Stardew Valley - ARK: Survival Evolved", "ark", true},
+		{"Counter-Strike 2", "counter-strike", true},
+		{"Counter-Striker", "counter-strike", false},
+	}
+	for _, tt := range tests {
+		if got := matchTitle(fold(tt.title), tt.keyword, MatchWord); got != tt.want {
+			t.Errorf("matchTitle(%q, %q, MatchWord) = %v, want %v", tt.title, tt.keyword, got, tt.want)
+		}
+	}
+}
+
+func TestTargetsFromMapPriority(t *testing.T) {
+	profiles := map[string]string{"steam": "-Profile1", "game": "-Profile2", "obs": "-Profile3"}
+	running := DetectionState{Processes: []ProcessState{{PID: 1, Executable: "steam.exe"}, {PID: 2, Executable: "game.exe"}, {PID: 3, Executable: "obs64.exe"}}}
+	tests := []struct {
+		name     string
+		priority []string
+		running  DetectionState
+		want     string
+	}{
+		{"no priority is alphabetical", nil, running, "game"},
+		{"listed first wins", []string{"steam", "game"}, running, "steam"},
+		{"listed keyword beats unlisted", []string{"obs"}, running, "obs"},
+		{"unknown keywords are ignored", []string{"valorant", "steam"}, running, "steam"},
+		{"duplicates keep their first place", []string{"obs", "steam", "obs"}, running, "obs"},
+		{"higher priority not running", []string{"valorant", "obs", "steam"}, DetectionState{Processes: running.Processes[:2]}, "steam"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := TargetsFromMap(profiles, MatchContains, tt.priority)
+			if len(targets) != len(profiles) {
+				t.Fatalf("TargetsFromMap returned %d targets, want %d", len(targets), len(profiles))
+			}
+			m, ok := Decide(tt.running, targets, Options{})
+			if !ok || m.Keyword != tt.want || m.Profile != profiles[tt.want] {
+				t.Fatalf("Decide = %q (%s), %v; want %q", m.Keyword, m.Profile, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	strip := []string{"(not responding)", "*", `re:\[\d+ fps\]`}
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Cyberpunk 2077 - Reddit - Google Chrome", "cyberpunk 2077 - reddit"},
+		{"Document1 - Word", "document1"},
+		{"*Untitled - Notepad", "untitled"},
+		{"Elden Ring (Not Responding)", "elden ring"},
+		{"ELDEN RING™  [144 FPS]", "elden ring™"},
+		{"Counter-Strike 2", "counter-strike 2"},
+		{"  Baldur's Gate 3   (1920x1080)  ", "baldur's gate 3 (1920x1080)"},
+		{"- Steam", "- steam"},
+		{"Minecraft* 1.20.4 - Singleplayer", "minecraft* 1.20.4"},
+		{"YouTube - Mozilla Firefox (Not Responding)", "youtube"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeTitle(fold(tt.title), strip); got != tt.want {
+			t.Errorf("normalizeTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestDirKeyword(t *testing.T) {
+	keyword := DirPrefix + `d:\emulation`
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{`D:\Emulation\RetroArch\retroarch.exe`, true},
+		{`D:\Emulation\Dolphin\Dolphin.exe`, true},
+		{`D:\Emulation\pcsx2.exe`, true},
+		{`d:/emulation/yuzu/yuzu.exe`, true},
+		{`D:\EmulationTools\cemu.exe`, false},
+		{`C:\Emulation\ppsspp.exe`, false},
+		{`D:\Games\Emulation\rpcs3.exe`, false},
+		{``, false},
+	}
+	for _, tt := range tests {
+		if got := matchDir(fold(tt.path), keyword); got != tt.want {
+			t.Errorf("matchDir(%q, %q) = %v, want %v", tt.path, keyword, got, tt.want)
+		}
+	}
+
+	// Two differently named exes in the folder both match the one rule.
+	targets := []Target{{Keyword: DirPrefix + `d:/emulation/`, Profile: "-Profile4"}}
+	for pid, path := range map[uint32]string{1: `D:\Emulation\RetroArch\retroarch.exe`, 2: `D:\Emulation\Dolphin\Dolphin.exe`} {
+		state := DetectionState{Foreground: &ForegroundState{PID: pid, Title: "Emulator", ExePath: path}}
+		if m, ok := Decide(state, targets, Options{}); !ok || m.PID != pid || m.ExePath != path {
+			t.Errorf("Decide for %s = %+v, %v; want a foreground match for PID %d", path, m, ok, pid)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package watcher
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// MinPollInterval is the shortest interval StartPollWatcher will honour.
+const MinPollInterval = 100 * time.Millisecond
+
+// StartPollWatcher calls handler every interval until ctx is cancelled.
+// It has the same handler signature and return value as StartEventWatcherContext, so callers
+// can swap between the two, and is the stable fallback when event hooks are unreliable.
+// Intervals below MinPollInterval are raised to it.
+func StartPollWatcher(ctx context.Context, interval time.Duration, handler func()) <-chan error {
+	return StartPollWatcherJitter(ctx, interval, 0, handler)
+}
+
+// StartPollWatcherJitter is StartPollWatcher with each wait varied randomly by up to jitter
+// times interval either way, so instances started together drift apart. A jitter of 0 behaves
+// exactly like StartPollWatcher.
+func StartPollWatcherJitter(ctx context.Context, interval time.Duration, jitter float64, handler func()) <-chan error {
+	if interval < MinPollInterval {
+		logging.Warnf("Poll interval %v is too short, using %v instead.", interval, MinPollInterval)
+		interval = MinPollInterval
+	}
+	errs := make(chan error, 1)
+	pollWatchers.Add(1)
+	go func() {
+		defer close(errs)
+		defer pollWatchers.Add(-1)
+		ticker := time.NewTicker(jittered(interval, jitter))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				errs <- nil
+				return
+			case <-ticker.C:
+				handler()
+				if jitter > 0 {
+					ticker.Reset(jittered(interval, jitter))
+				}
+			}
+		}
+	}()
+	return errs
+}
+
+// jittered returns interval moved randomly by up to jitter times itself in either direction,
+// but never below MinPollInterval.
+func jittered(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	d := interval + time.Duration((2*rand.Float64()-1)*jitter*float64(interval))
+	return max(d, MinPollInterval)
+}
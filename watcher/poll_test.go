@@ -0,0 +1,59 @@
+package watcher
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJitteredZeroKeepsInterval(t *testing.T) {
+	for _, interval := range []time.Duration{MinPollInterval, time.Second, 15 * time.Second} {
+		for i := 0; i < 100; i++ {
+			if got := jittered(interval, 0); got != interval {
+				t.Fatalf("jittered(%v, 0) = %v, want %v", interval, got, interval)
+			}
+		}
+	}
+}
+
+func TestJitteredStaysInRange(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		jitter   float64
+		min, max time.Duration
+	}{
+		{15 * time.Second, 0.2, 12 * time.Second, 18 * time.Second},
+		{time.Second, 0.5, 500 * time.Millisecond, 1500 * time.Millisecond},
+		{MinPollInterval, 0.5, MinPollInterval, 150 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 1000; i++ {
+			got := jittered(tt.interval, tt.jitter)
+			if got < tt.min || got > tt.max {
+				t.Fatalf("jittered(%v, %v) = %v, want between %v and %v", tt.interval, tt.jitter, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestStartPollWatcherJitterZeroMatchesStartPollWatcher(t *testing.T) {
+	count := func(start func(context.Context, func()) <-chan error) int32 {
+		var calls atomic.Int32
+		ctx, cancel := context.WithTimeout(context.Background(), 550*time.Millisecond)
+		defer cancel()
+		if err := <-start(ctx, func() { calls.Add(1) }); err != nil {
+			t.Fatalf("watcher returned %v, want nil", err)
+		}
+		return calls.Load()
+	}
+	plain := count(func(ctx context.Context, h func()) <-chan error {
+		return StartPollWatcher(ctx, MinPollInterval, h)
+	})
+	zero := count(func(ctx context.Context, h func()) <-chan error {
+		return StartPollWatcherJitter(ctx, MinPollInterval, 0, h)
+	})
+	if plain < 4 || plain > 6 || zero < 4 || zero > 6 {
+		t.Fatalf("handler calls in 550ms: StartPollWatcher %d, StartPollWatcherJitter with 0 jitter %d, want about 5 each", plain, zero)
+	}
+}
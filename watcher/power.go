@@ -0,0 +1,36 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// systemPowerStatus mirrors SYSTEM_POWER_STATUS.
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// errPowerUnknown is returned by OnACPower when Windows does not know the power source.
+var errPowerUnknown = errors.New("the power source is unknown")
+
+// OnACPower reports whether the computer is running on AC power rather than battery.
+func OnACPower() (bool, error) {
+	var status systemPowerStatus
+	ret, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status)))
+	if ret == 0 {
+		return false, fmt.Errorf("GetSystemPowerStatus failed: %w", err)
+	}
+	switch status.ACLineStatus {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, errPowerUnknown
+}
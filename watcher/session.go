@@ -0,0 +1,30 @@
+package watcher
+
+import "context"
+
+const (
+	wmClose      = 0x0010
+	wmEndSession = 0x0016
+
+	sessionWindowClass = "MSIAfterburnerScriptSessionWatcher"
+)
+
+// StartSessionWatcher calls onEnd when Windows is logging off or shutting down (WM_ENDSESSION),
+// or when the application is asked to close (WM_CLOSE, as sent by taskkill without /f).
+// onEnd runs before the message is answered, because Windows may end the process as soon as
+// WM_ENDSESSION returns. The returned channel behaves as in StartDisplayWatcher.
+func StartSessionWatcher(ctx context.Context, onEnd func()) <-chan error {
+	return startMessageWindow(ctx, sessionWindowClass, func(msg uint32, wParam uintptr) bool {
+		switch msg {
+		case wmEndSession:
+			if wParam != 0 {
+				onEnd()
+			}
+			return true
+		case wmClose:
+			onEnd()
+			return true
+		}
+		return false
+	}, nil)
+}
@@ -0,0 +1,78 @@
+package watcher
+
+import (
+	"context"
+	"time"
+
+	"MSIAfterburnerScript/logging"
+)
+
+// SuperviseTimeout is how long a supervised watcher may stay silent before it is restarted.
+const SuperviseTimeout = DefaultHeartbeatTimeout
+
+// Settings for Supervise, variables so tests can shorten them. A restarted watcher that has not
+// shown signs of life yet gets the backoff on top of superviseTimeout; the backoff starts at
+// superviseMinBackoff and doubles, up to superviseMaxBackoff, with every further restart that
+// follows without a heartbeat in between.
+var (
+	superviseTimeout    = SuperviseTimeout
+	superviseInterval   = 10 * time.Second
+	superviseMinBackoff = 30 * time.Second
+	superviseMaxBackoff = 30 * time.Minute
+)
+
+// Supervise runs a watcher started by start and replaces it with a fresh one whenever heartbeat,
+// which returns when the watcher last showed signs of life, falls more than SuperviseTimeout
+// behind, backing off while the restarted watchers stay silent. The old watcher is cancelled
+// and waited for before the new one starts. The returned channel behaves like the watchers': it
+// receives nil once ctx is cancelled and the current watcher has stopped, or the error of a
+// watcher that gave up by itself, and is then closed.
+func Supervise(ctx context.Context, start func(ctx context.Context) <-chan error, heartbeat func() time.Time) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		ticker := time.NewTicker(superviseInterval)
+		defer ticker.Stop()
+		var backoff time.Duration
+		for restarts := 1; ; restarts++ {
+			runCtx, cancel := context.WithCancel(ctx)
+			started := time.Now()
+			done := start(runCtx)
+			var silence time.Duration
+			var alive bool
+			for silence == 0 {
+				select {
+				case <-ctx.Done():
+					cancel()
+					<-done
+					errs <- nil
+					return
+				case err := <-done:
+					cancel()
+					errs <- err
+					return
+				case <-ticker.C:
+					last := heartbeat()
+					alive = last.After(started)
+					limit := superviseTimeout
+					if !alive {
+						last = started
+						limit += backoff
+					}
+					if s := time.Since(last); s >= limit {
+						silence = s
+					}
+				}
+			}
+			cancel()
+			<-done
+			if alive {
+				backoff = 0
+			} else {
+				backoff = min(max(2*backoff, superviseMinBackoff), superviseMaxBackoff)
+			}
+			logging.Warnf("The watcher has been silent for %v. Restarting it (restart %d); it gets %v before the next one.", silence.Round(time.Second), restarts, superviseTimeout+backoff)
+		}
+	}()
+	return errs
+}
@@ -0,0 +1,77 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSuperviseBackoff(t *testing.T) {
+	defer func(interval, timeout, minBackoff, maxBackoff time.Duration) {
+		superviseInterval, superviseTimeout, superviseMinBackoff, superviseMaxBackoff = interval, timeout, minBackoff, maxBackoff
+	}(superviseInterval, superviseTimeout, superviseMinBackoff, superviseMaxBackoff)
+	superviseInterval = 5 * time.Millisecond
+	superviseTimeout = 50 * time.Millisecond
+	superviseMinBackoff = 50 * time.Millisecond
+	superviseMaxBackoff = 200 * time.Millisecond
+
+	tests := []struct {
+		name string
+		// beats is whether each watcher shows a sign of life right after it starts.
+		beats bool
+		// want is the expected time each watcher runs before it is replaced.
+		want []time.Duration
+	}{
+		{"silent watchers back off", false, []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 150 * time.Millisecond, 250 * time.Millisecond, 250 * time.Millisecond}},
+		{"a heartbeat resets the backoff", true, []time.Duration{50 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			var beat atomic.Int64
+			var mu sync.Mutex
+			var starts []time.Time
+			done := make(chan struct{})
+			start := func(ctx context.Context) <-chan error {
+				mu.Lock()
+				defer mu.Unlock()
+				starts = append(starts, time.Now())
+				if len(starts) == len(tt.want)+1 {
+					close(done)
+				}
+				if tt.beats {
+					time.Sleep(time.Millisecond)
+					beat.Store(time.Now().UnixNano())
+				}
+				errs := make(chan error, 1)
+				go func() {
+					<-ctx.Done()
+					errs <- nil
+					close(errs)
+				}()
+				return errs
+			}
+			errs := Supervise(ctx, start, func() time.Time { return time.Unix(0, beat.Load()) })
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("the watchers were not restarted")
+			}
+			cancel()
+			if err := <-errs; err != nil {
+				t.Fatalf("Supervise returned %v, want nil after cancel", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for i, want := range tt.want {
+				// The last heartbeat can be a tick old, and ticks can be delayed on a busy machine.
+				got := starts[i+1].Sub(starts[i])
+				if got < want || got > want+want/2+5*superviseInterval {
+					t.Errorf("watcher %d ran for %v before it was replaced, want about %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
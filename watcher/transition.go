@@ -0,0 +1,185 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// TransitionTracker runs a detection function on every Check and forwards to onChange only
+// when the matched target differs from the previous result, as told by Match.SameTarget, including transitions to and
+// from "no target active", which is represented by a zero Match. A match with a Dwell is only
+// forwarded once it has stayed the active match for that long, and a forwarded match with a
+// Cooldown holds off any further change for that long unless Preempts allows it. With a
+// RevertGrace, a change from a target to no target is only forwarded once nothing has matched
+// for that long, so a brief switch to another window and back leaves the target in place.
+type TransitionTracker struct {
+	detect   func() (Match, bool)
+	onChange func(prev, cur Match)
+	// Recheck is called when a dwell period ends, to evaluate the state again.
+	// It defaults to Check; callers that wrap Check should set it to their wrapper.
+	Recheck func()
+	// Preempts reports whether cur may replace last while last's cooldown is running, for
+	// example because cur has a higher priority. When nil, nothing preempts a cooldown.
+	Preempts func(cur, last Match) bool
+	// Suppressed, if set, is called for each Check whose change is held back by a cooldown,
+	// with the time the cooldown has left.
+	Suppressed func(cur Match, remaining time.Duration)
+	// RevertGrace is how long no target must match before that is forwarded.
+	RevertGrace time.Duration
+	// Reverting reports whether changing from last to cur goes back to the idle state and so
+	// waits out RevertGrace. When nil, a change from a keyword to a zero Match does.
+	Reverting func(cur, last Match) bool
+
+	mu      sync.Mutex
+	started bool
+	last    Match
+	// pending is a match waiting out its dwell time since pendingSince.
+	pending      Match
+	pendingSince time.Time
+	// idleSince is when the idle state waiting out RevertGrace began, if idlePending is set.
+	idlePending bool
+	idleSince   time.Time
+	// changedAt is when last was forwarded, and cooldownRecheck whether a Recheck has been
+	// scheduled for the end of its cooldown.
+	changedAt       time.Time
+	cooldownRecheck bool
+
+	// forwardMu guards forwarded, a copy of what Supersedes needs, since it cannot take mu
+	// while onChange is running.
+	forwardMu sync.Mutex
+	forwarded forwardedMatch
+}
+
+// forwardedMatch is the last forwarded match, when it was forwarded and the RevertGrace then.
+type forwardedMatch struct {
+	match Match
+	at    time.Time
+	grace time.Duration
+}
+
+// NewTransitionTracker returns a tracker whose first Check always forwards the current state.
+func NewTransitionTracker(detect func() (Match, bool), onChange func(prev, cur Match)) *TransitionTracker {
+	t := &TransitionTracker{detect: detect, onChange: onChange}
+	t.Recheck = t.Check
+	return t
+}
+
+// NewMatchIdleTracker returns a tracker that calls onMatch when a new target becomes active and
+// onIdle once when the last target goes away. onIdle is also called by the first Check (and the
+// first Check after Reset) if nothing is active, so callers can establish their idle state.
+func NewMatchIdleTracker(detect func() (Match, bool), onMatch func(Match), onIdle func()) *TransitionTracker {
+	return NewTransitionTracker(detect, func(_, cur Match) {
+		if cur.Keyword != "" {
+			onMatch(cur)
+		} else {
+			onIdle()
+		}
+	})
+}
+
+// Check has the handler signature expected by the watchers.
+func (t *TransitionTracker) Check() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cur, ok := t.detect()
+	if !ok {
+		cur = Match{}
+	}
+	if t.started && cur.SameTarget(t.last) {
+		t.pending, t.idlePending = Match{}, false
+		return
+	}
+	if t.started && t.RevertGrace > 0 && t.reverting(cur, t.last) {
+		if !t.idlePending {
+			t.idlePending, t.idleSince = true, time.Now()
+			time.AfterFunc(t.RevertGrace, t.Recheck)
+			return
+		}
+		if time.Since(t.idleSince) < t.RevertGrace {
+			return
+		}
+	} else {
+		t.idlePending = false
+	}
+	if t.started && cur.Keyword != "" && cur.Dwell > 0 {
+		if !t.pending.SameTarget(cur) {
+			t.pending, t.pendingSince = cur, time.Now()
+			time.AfterFunc(cur.Dwell, t.Recheck)
+			return
+		}
+		if time.Since(t.pendingSince) < cur.Dwell {
+			return
+		}
+	}
+	if t.started && t.last.Cooldown > 0 {
+		remaining := t.last.Cooldown - time.Since(t.changedAt)
+		if remaining > 0 && (t.Preempts == nil || !t.Preempts(cur, t.last)) {
+			if !t.cooldownRecheck {
+				t.cooldownRecheck = true
+				time.AfterFunc(remaining, t.Recheck)
+			}
+			if t.Suppressed != nil {
+				t.Suppressed(cur, remaining)
+			}
+			return
+		}
+	}
+	t.pending, t.idlePending = Match{}, false
+	prev := t.last
+	t.started = true
+	t.last = cur
+	t.changedAt, t.cooldownRecheck = time.Now(), false
+	t.forwardMu.Lock()
+	t.forwarded = forwardedMatch{match: cur, at: t.changedAt, grace: t.RevertGrace}
+	t.forwardMu.Unlock()
+	t.onChange(prev, cur)
+}
+
+// Supersedes runs detection and returns its result if Check would forward it straight away,
+// replacing the last forwarded match with no dwell, revert grace or cooldown holding it back.
+// It is meant to be called from another goroutine while onChange is still acting on the last
+// match, to decide whether to abandon that, so it does not wait for Check to return. The
+// caller must make sure detection does not run concurrently with itself.
+func (t *TransitionTracker) Supersedes() (Match, bool) {
+	cur, ok := t.detect()
+	if !ok {
+		cur = Match{}
+	}
+	t.forwardMu.Lock()
+	f := t.forwarded
+	t.forwardMu.Unlock()
+	switch {
+	case cur.SameTarget(f.match):
+		return Match{}, false
+	case f.grace > 0 && t.reverting(cur, f.match):
+		return Match{}, false
+	case cur.Keyword != "" && cur.Dwell > 0:
+		return Match{}, false
+	case f.match.Cooldown > 0 && time.Since(f.at) < f.match.Cooldown && (t.Preempts == nil || !t.Preempts(cur, f.match)):
+		return Match{}, false
+	}
+	return cur, true
+}
+
+// Last returns the last forwarded match.
+func (t *TransitionTracker) Last() Match {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// reverting reports whether changing from last to cur waits out RevertGrace.
+func (t *TransitionTracker) reverting(cur, last Match) bool {
+	if t.Reverting != nil {
+		return t.Reverting(cur, last)
+	}
+	return cur.Keyword == "" && last.Keyword != ""
+}
+
+// Reset makes the next Check forward its result even if the target is unchanged,
+// for example after the configuration has been reloaded.
+func (t *TransitionTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.started = false
+}
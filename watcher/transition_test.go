@@ -0,0 +1,164 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSupersedes(t *testing.T) {
+	game := Match{Keyword: "game.exe", Source: SourceProcess}
+	tests := []struct {
+		name     string
+		last     Match
+		cur      Match
+		grace    time.Duration
+		preempts bool
+		want     bool
+	}{
+		{"another target", game, Match{Keyword: "other.exe"}, 0, false, true},
+		{"same target", game, game, 0, false, false},
+		{"another target with a dwell", game, Match{Keyword: "other.exe", Dwell: time.Hour}, 0, false, false},
+		{"cooldown running", Match{Keyword: "game.exe", Cooldown: time.Hour}, Match{Keyword: "other.exe"}, 0, false, false},
+		{"cooldown preempted", Match{Keyword: "game.exe", Cooldown: time.Hour}, Match{Keyword: "other.exe"}, 0, true, true},
+		{"cooldown over", Match{Keyword: "game.exe", Cooldown: time.Nanosecond}, Match{Keyword: "other.exe"}, 0, false, true},
+		{"idle", game, Match{}, 0, false, true},
+		{"idle within revert grace", game, Match{}, time.Hour, false, false},
+		{"target after idle with revert grace", Match{}, game, time.Hour, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detected := tt.last
+			tracker := NewTransitionTracker(func() (Match, bool) { return detected, detected.Keyword != "" }, func(_, _ Match) {})
+			tracker.RevertGrace = tt.grace
+			tracker.Preempts = func(_, _ Match) bool { return tt.preempts }
+			tracker.Check()
+			time.Sleep(time.Millisecond)
+			detected = tt.cur
+			got, ok := tracker.Supersedes()
+			if ok != tt.want {
+				t.Fatalf("Supersedes() = %v, %v; want ok %v", got, ok, tt.want)
+			}
+			if ok && got.Keyword != tt.cur.Keyword {
+				t.Fatalf("Supersedes() returned %q, want %q", got.Keyword, tt.cur.Keyword)
+			}
+			if last := tracker.Last(); last.Keyword != tt.last.Keyword {
+				t.Fatalf("Supersedes changed the last match to %q, want %q", last.Keyword, tt.last.Keyword)
+			}
+		})
+	}
+}
+
+func TestSupersedesDuringOnChange(t *testing.T) {
+	detected := Match{Keyword: "slow.exe"}
+	result := make(chan bool, 1)
+	var tracker *TransitionTracker
+	tracker = NewTransitionTracker(func() (Match, bool) { return detected, true }, func(_, cur Match) {
+		if cur.Keyword != "slow.exe" {
+			return
+		}
+		// The apply for slow.exe is in progress when fast.exe takes over.
+		detected = Match{Keyword: "fast.exe"}
+		go func() {
+			_, ok := tracker.Supersedes()
+			result <- ok
+		}()
+		select {
+		case ok := <-result:
+			result <- ok
+		case <-time.After(5 * time.Second):
+			t.Error("Supersedes blocked while onChange was running")
+		}
+	})
+	tracker.Check()
+	if ok := <-result; !ok {
+		t.Fatal("Supersedes did not report fast.exe replacing slow.exe")
+	}
+	tracker.Check()
+	if last := tracker.Last(); last.Keyword != "fast.exe" {
+		t.Fatalf("the next Check forwarded %q, want fast.exe", last.Keyword)
+	}
+}
+
+func TestTrackerTellsSharedKeywordsApart(t *testing.T) {
+	titleRule, exeRule := new(int), new(int)
+	detected := Match{Keyword: "chrome", Profile: "-Profile2", Tag: titleRule}
+	var forwarded []Match
+	tracker := NewTransitionTracker(func() (Match, bool) { return detected, true }, func(_, cur Match) {
+		forwarded = append(forwarded, cur)
+	})
+	tracker.Check()
+	tracker.Check()
+	detected = Match{Keyword: "chrome", Profile: "-Profile3", Tag: exeRule}
+	tracker.Check()
+	if len(forwarded) != 2 || forwarded[1].Tag != exeRule {
+		t.Fatalf("forwarded %v, want the title rule and then the exe rule", forwarded)
+	}
+}
+
+func TestMatchIdleTrackerRevertsOnce(t *testing.T) {
+	game := ProcessState{PID: 1, Executable: "game.exe"}
+	second := ProcessState{PID: 2, Executable: "game.exe"}
+	other := ProcessState{PID: 3, Executable: "other.exe"}
+	targets := []Target{{Keyword: "game"}, {Keyword: "other"}}
+	tests := []struct {
+		name        string
+		steps       [][]ProcessState
+		wantMatches int
+		wantIdles   int
+	}{
+		{"last process closes", [][]ProcessState{{game}, nil, nil, nil}, 1, 1},
+		{"one of two instances closes", [][]ProcessState{{game, second}, {second}, {second}, nil, nil}, 1, 1},
+		{"another target is left", [][]ProcessState{{game, other}, {other}, nil, nil}, 2, 1},
+		{"closed and started again", [][]ProcessState{{game}, nil, {game}, nil}, 2, 2},
+		{"nothing running from the start", [][]ProcessState{nil, nil}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state DetectionState
+			matches, idles := 0, 0
+			tracker := NewMatchIdleTracker(func() (Match, bool) { return Decide(state, targets, Options{}) },
+				func(Match) { matches++ }, func() { idles++ })
+			for _, running := range tt.steps {
+				state.Processes = running
+				tracker.Check()
+			}
+			if matches != tt.wantMatches || idles != tt.wantIdles {
+				t.Fatalf("onMatch called %d times and onIdle %d times, want %d and %d", matches, idles, tt.wantMatches, tt.wantIdles)
+			}
+		})
+	}
+}
+
+func TestMatchIdleTrackerStartup(t *testing.T) {
+	game := DetectionState{Processes: []ProcessState{{PID: 1, Executable: "game.exe"}}}
+	targets := []Target{{Keyword: "game", Profile: "-Profile2"}}
+	tests := []struct {
+		name string
+		// checks counts the Checks run at startup and for the events after it.
+		checks      int
+		reset       bool
+		wantApplies int
+	}{
+		{"startup scan alone", 1, false, 1},
+		{"startup scan and the first events", 4, false, 1},
+		{"resume from pause", 3, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var applied []string
+			tracker := NewMatchIdleTracker(func() (Match, bool) { return Decide(game, targets, Options{}) },
+				func(m Match) { applied = append(applied, m.Profile) }, func() { t.Error("onIdle called while the game is running") })
+			for i := 0; i < tt.checks; i++ {
+				tracker.Check()
+			}
+			if tt.reset {
+				tracker.Reset()
+				tracker.Check()
+				tracker.Check()
+			}
+			if len(applied) != tt.wantApplies {
+				t.Fatalf("applied %q, want the game's profile %d times", applied, tt.wantApplies)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package watcher
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// versionInfo is the product and company name from an executable's version resource, lowercased.
+type versionInfo struct {
+	product string
+	company string
+}
+
+// versionCache holds version info by executable path; an exe's resource does not change while
+// it runs, so entries are kept for the life of the process.
+var versionCache sync.Map
+
+// exeVersionInfo returns the cached version info for path, reading it on first use.
+// Executables without a version resource get an empty versionInfo.
+func exeVersionInfo(path string) versionInfo {
+	if v, ok := versionCache.Load(path); ok {
+		return v.(versionInfo)
+	}
+	info := readVersionInfo(path)
+	versionCache.Store(path, info)
+	return info
+}
+
+func readVersionInfo(path string) versionInfo {
+	size, err := windows.GetFileVersionInfoSize(path, nil)
+	if err != nil || size == 0 {
+		return versionInfo{}
+	}
+	data := make([]byte, size)
+	if err := windows.GetFileVersionInfo(path, 0, size, unsafe.Pointer(&data[0])); err != nil {
+		return versionInfo{}
+	}
+	block := unsafe.Pointer(&data[0])
+
+	// Try the languages the file lists first, then the common US English code pages.
+	languages := []string{"040904b0", "040904e4"}
+	var translation *[2]uint16
+	var n uint32
+	if windows.VerQueryValue(block, `\VarFileInfo\Translation`, unsafe.Pointer(&translation), &n) == nil && n >= 4 {
+		languages = append([]string{fmt.Sprintf("%04x%04x", translation[0], translation[1])}, languages...)
+	}
+	for _, lang := range languages {
+		info := versionInfo{
+			product: fold(versionString(block, lang, "ProductName")),
+			company: fold(versionString(block, lang, "CompanyName")),
+		}
+		if info.product != "" || info.company != "" {
+			return info
+		}
+	}
+	return versionInfo{}
+}
+
+// versionString reads one StringFileInfo value from a version resource.
+func versionString(block unsafe.Pointer, lang, name string) string {
+	var value *uint16
+	var n uint32
+	if err := windows.VerQueryValue(block, `\StringFileInfo\`+lang+`\`+name, unsafe.Pointer(&value), &n); err != nil || n == 0 {
+		return ""
+	}
+	return windows.UTF16ToString(unsafe.Slice(value, n))
+}
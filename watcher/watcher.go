@@ -1,22 +1,19 @@
 package watcher
 
 import (
-	"log"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+	"unicode/utf16"
 	"unsafe"
 
-	"github.com/mitchellh/go-ps"
 	"golang.org/x/sys/windows"
-)
 
-// WinEvent constants for event-driven watching
-const (
-	eventSystemForeground = 0x0003
-	eventObjectCreate     = 0x8000
-	eventObjectDestroy    = 0x8001
-	wndOutofcontext       = 0x0000
+	"MSIAfterburnerScript/logging"
 )
 
 // Lazy-load necessary DLL procedures for performance.
@@ -26,204 +23,727 @@ var (
 	procGetWindowThreadProcessId = user32.NewProc("GetWindowThreadProcessId")
 	procGetWindowTextW           = user32.NewProc("GetWindowTextW")
 	procGetWindowTextLen         = user32.NewProc("GetWindowTextLengthW")
+	procGetClassNameW            = user32.NewProc("GetClassNameW")
 	procEnumWindows              = user32.NewProc("EnumWindows")
 	procIsWindowVisible          = user32.NewProc("IsWindowVisible")
+	procIsIconic                 = user32.NewProc("IsIconic")
+	procGetWindowRect            = user32.NewProc("GetWindowRect")
+	procMonitorFromWindow        = user32.NewProc("MonitorFromWindow")
+	procGetMonitorInfoW          = user32.NewProc("GetMonitorInfoW")
+	procGetDesktopWindow         = user32.NewProc("GetDesktopWindow")
+	procGetShellWindow           = user32.NewProc("GetShellWindow")
 	procSetWinEventHook          = user32.NewProc("SetWinEventHook")
 	procUnhookWinEvent           = user32.NewProc("UnhookWinEvent")
 	procGetMessageW              = user32.NewProc("GetMessageW")
 	procTranslateMessage         = user32.NewProc("TranslateMessage")
 	procDispatchMessageW         = user32.NewProc("DispatchMessageW")
+	procPeekMessageW             = user32.NewProc("PeekMessageW")
+	procPostThreadMessageW       = user32.NewProc("PostThreadMessageW")
+	procRegisterHotKey           = user32.NewProc("RegisterHotKey")
+	procUnregisterHotKey         = user32.NewProc("UnregisterHotKey")
 
 	kernel32        = windows.NewLazySystemDLL("kernel32.dll")
 	procOpenProcess = kernel32.NewProc("OpenProcess")
 	procCloseHandle = kernel32.NewProc("CloseHandle")
 
+	procGetSystemPowerStatus = kernel32.NewProc("GetSystemPowerStatus")
+
 	psapi                    = windows.NewLazySystemDLL("psapi.dll")
 	procGetModuleFileNameExW = psapi.NewProc("GetModuleFileNameExW")
 )
 
-// StartEventWatcher sets up Windows event hooks to listen for system events.
-func StartEventWatcher(handler func()) {
-	go func() {
-		winEventProc := syscall.NewCallback(func(hWinEventHook syscall.Handle, event uint32, hwnd syscall.Handle, idObject int32, idChild int32, idEventThread uint32, dwmsEventTime uint32) uintptr {
-			handler()
-			return 0
-		})
+// Options controls how FirstActive and FirstActiveTarget detect targets.
+type Options struct {
+	// Mode is the match mode used by FirstActiveTarget for its map keys.
+	Mode MatchMode
+	// PathMatch makes the process stage match keywords against each process's
+	// full executable path instead of just its basename.
+	PathMatch bool
+	// Priority lists map keys for FirstActiveTarget in the order they should win
+	// when several are active. Keys not listed follow in alphabetical order.
+	Priority []string
+	// Stages lists the detection stages to run, in order. Stages left out are skipped,
+	// including for exclusions. Empty means DefaultStages.
+	Stages []Stage
+	// FullscreenOnly makes the foreground stage match only when the foreground
+	// window covers its whole monitor (exclusive or borderless fullscreen).
+	// It turns StageForeground into StageFullscreen.
+	FullscreenOnly bool
+	// ForegroundOnly limits detection, including exclusions, to the foreground window, so
+	// background processes and other visible windows never match. It drops StageProcess,
+	// StageWindow and StageAudio.
+	ForegroundOnly bool
+	// NormalizeTitles makes window titles match without their decorations: the TitleStrip
+	// entries are removed from either end, everything after the last " - " is dropped (as in
+	// "Document - Google Chrome") and runs of whitespace are collapsed.
+	NormalizeTitles bool
+	// TitleStrip lists lowercase prefixes and suffixes to remove when NormalizeTitles is set.
+	// Entries starting with RegexPrefix are patterns removed wherever they match.
+	TitleStrip []string
+	// WindowCacheTTL lets the window stage reuse the list of visible windows and their
+	// titles for this long instead of enumerating them on every call. 0 disables caching.
+	WindowCacheTTL time.Duration
+	// ProcessCacheTTL lets consecutive calls share one process list for this long, so a burst
+	// of events does not list every process each time. 0 uses DefaultProcessCacheTTL and a
+	// negative value disables caching.
+	ProcessCacheTTL time.Duration
+}
 
-		hookForeground, _, err := procSetWinEventHook.Call(eventSystemForeground, eventSystemForeground, 0, winEventProc, 0, 0, wndOutofcontext)
-		if hookForeground == 0 {
-			log.Fatalf("Fatal: Could not set foreground event hook: %v", err)
+// matchedTitle returns the form of a window title that keywords are matched against.
+func (o Options) matchedTitle(title string) string {
+	if !o.NormalizeTitles {
+		return title
+	}
+	return normalizeTitle(fold(title), o.TitleStrip)
+}
+
+// stages returns the stages to run, in order, with FullscreenOnly and ForegroundOnly applied.
+func (o Options) stages() []Stage {
+	stages := o.Stages
+	if len(stages) == 0 {
+		stages = DefaultStages
+	}
+	var resolved []Stage
+	for _, stage := range stages {
+		switch {
+		case o.ForegroundOnly && (stage == StageProcess || stage == StageWindow || stage == StageAudio):
+			continue
+		case o.FullscreenOnly && stage == StageForeground:
+			stage = StageFullscreen
+		}
+		if !slices.Contains(resolved, stage) {
+			resolved = append(resolved, stage)
 		}
-		hookCreate, _, err := procSetWinEventHook.Call(eventObjectCreate, eventObjectDestroy, 0, winEventProc, 0, 0, wndOutofcontext)
-		if hookCreate == 0 {
-			log.Fatalf("Fatal: Could not set create/destroy event hook: %v", err)
+	}
+	return resolved
+}
+
+// runs reports whether any of the given stages is enabled.
+func (o Options) runs(stages ...Stage) bool {
+	return slices.ContainsFunc(o.stages(), func(s Stage) bool { return slices.Contains(stages, s) })
+}
+
+// runStage runs a single detection stage.
+func runStage(stage Stage, targets []Target, opts Options, sc *scan) (Match, bool) {
+	switch stage {
+	case StageForeground:
+		return getForegroundTarget(targets, opts, sc)
+	case StageFullscreen:
+		if fg, ok := sc.foreground(); ok && fg.displayState().Fullscreen() {
+			return getForegroundTarget(targets, opts, sc)
 		}
+	case StageProcess:
+		return isProcessActive(targets, opts, sc)
+	case StageWindow:
+		return isWindowActive(targets, opts, sc)
+	case StageAudio:
+		return isAudioActive(targets, sc)
+	}
+	return Match{}, false
+}
 
-		defer func() {
-			ret, _, err := procUnhookWinEvent.Call(hookForeground)
-			if ret == 0 {
-				log.Printf("Warning: Failed to unhook foreground event hook: %v", err)
-			}
-		}()
-		defer func() {
-			ret, _, err := procUnhookWinEvent.Call(hookCreate)
-			if ret == 0 {
-				log.Printf("Warning: Failed to unhook create/destroy event hook: %v", err)
-			}
-		}()
+// DefaultProcessCacheTTL is the process list lifetime used when Options.ProcessCacheTTL is 0.
+const DefaultProcessCacheTTL = time.Second
 
-		// log.Println("Event hooks set. Listening for system events...")
+// FirstActiveTarget checks for a target in a keyword->profile map using the given options.
+// It is FirstActive with the map converted by TargetsFromMap using opts.Mode and opts.Priority.
+func FirstActiveTarget(targets map[string]string, opts Options) (Match, bool) {
+	return FirstActive(TargetsFromMap(targets, opts.Mode, opts.Priority), opts)
+}
 
-		var msg struct{ Hwnd, Message, WParam, LParam, Time, Pt uintptr }
+// FirstActive checks for an active target, running the stages in opts.Stages in order, which by
+// default prioritizes the foreground application.
+// It returns the Match describing the keyword and where it was found, and a boolean indicating if a match was found.
+// Within each stage the earliest active target in the slice wins. Targets whose keyword starts with
+// ExcludePrefix are exclusions: if any of them is found in the foreground window or the running
+// processes, no target is reported at all, so exclusions always win over inclusions. A target's own
+// Exclude keywords veto only that target.
+func FirstActive(targets []Target, opts Options) (Match, bool) {
+	return firstActive(targets, opts, newScan(opts))
+}
+
+// firstActive is FirstActive reading the system through sc.
+func firstActive(targets []Target, opts Options, sc *scan) (Match, bool) {
+	targets, ok := unexcluded(targets, opts, sc)
+	if !ok {
+		return Match{}, false
+	}
+
+	for _, stage := range opts.stages() {
+		if m, ok := runStage(stage, targets, opts, sc); ok {
+			return m, true
+		}
+	}
+	return Match{}, false
+}
+
+// IsTargetActive reports whether a single keyword is active, using the same foreground, process
+// and window stages as FirstActive with default options. Like the keywords in the config, it
+// should be lowercase apart from any "re:" pattern.
+func IsTargetActive(keyword string, mode MatchMode) bool {
+	_, ok := FirstActive([]Target{{Keyword: keyword, Mode: mode}}, Options{Mode: mode})
+	return ok
+}
+
+// AllActiveTargets is AllActive for a keyword->profile map, converted as in FirstActiveTarget.
+func AllActiveTargets(targets map[string]string, opts Options) []Match {
+	return AllActive(TargetsFromMap(targets, opts.Mode, opts.Priority), opts)
+}
+
+// AllActive returns a Match for every active target, not just the one FirstActive would pick,
+// so conflicting targets can be spotted. Each keyword appears once, from the first stage that
+// found it, and the matches are ordered as FirstActive ranks them: by stage order, then by
+// target order within a stage. Exclusions apply as in FirstActive.
+func AllActive(targets []Target, opts Options) []Match {
+	sc := newScan(opts)
+	targets, ok := unexcluded(targets, opts, sc)
+	if !ok {
+		return nil
+	}
+
+	var matches []Match
+	for _, stage := range opts.stages() {
 		for {
-			ret, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
-			if int32(ret) == -1 {
+			m, ok := runStage(stage, targets, opts, sc)
+			if !ok {
 				break
 			}
-			_, _, err := procTranslateMessage.Call(uintptr(unsafe.Pointer(&msg)))
-			if err != nil && err.(syscall.Errno) != 0 {
-				log.Fatalf("TranslateMessage failed: %v", err)
-			}
-			_, _, err = procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
-			if err != nil && err.(syscall.Errno) != 0 {
-				log.Fatalf("DispatchMessageW failed: %v", err)
-			}
-
+			matches = append(matches, m)
+			targets = slices.DeleteFunc(targets, m.Of)
 		}
-	}()
+	}
+	return matches
 }
 
-// FirstActiveTarget checks for a target using partial matching, prioritizing the foreground application.
-// It returns the *keyword* that was matched, and a boolean indicating if a match was found.
-func FirstActiveTarget(targets map[string]string) (string, bool) {
-	keywords := make([]string, 0, len(targets))
-	for k := range targets {
-		keywords = append(keywords, k)
+// unexcluded returns a copy of targets without the exclusion targets and without targets
+// vetoed by their own Exclude keywords. It reports false if a global exclusion is present.
+func unexcluded(targets []Target, opts Options, sc *scan) ([]Target, bool) {
+	targets, excludes := splitExclusions(targets)
+	if len(excludes) > 0 && anyPresent(excludes, opts, sc) {
+		return nil, false
 	}
+	return slices.DeleteFunc(slices.Clone(targets), func(t Target) bool {
+		return len(t.Exclude) > 0 && anyPresent(exclusionTargets(t), opts, sc)
+	}), true
+}
 
-	if name, ok := getForegroundTarget(keywords); ok {
-		return name, true
+// FirstActiveSince is FirstActive with a fast path for the steady state. When previous is a
+// foreground match from an earlier call with the same targets and options, and its window is
+// still the foreground window with the same title and process, previous is returned again
+// without matching exe names or reading process paths. Exclusions are still checked. The fast
+// path is only taken when the foreground stage runs first.
+func FirstActiveSince(previous Match, targets []Target, opts Options) (Match, bool) {
+	stages := opts.stages()
+	if previous.Source != SourceForeground || previous.HWND == 0 || len(stages) == 0 ||
+		(stages[0] != StageForeground && stages[0] != StageFullscreen) || !foregroundUnchanged(previous) {
+		return FirstActive(targets, opts)
 	}
-	if name, ok := isProcessActive(keywords); ok {
-		return name, true
+	sc := newScan(opts)
+	targets, ok := unexcluded(targets, opts, sc)
+	if !ok {
+		return Match{}, false
 	}
-	if name, ok := isWindowActive(keywords); ok {
-		return name, true
+	i := slices.IndexFunc(targets, previous.Of)
+	if i < 0 || ((stages[0] == StageFullscreen || targets[i].FullscreenOnly) && !isForegroundFullscreen()) {
+		return FirstActive(targets, opts)
 	}
-	return "", false
+	return previous, true
 }
 
-// getForegroundTarget checks if the foreground app's process or title contains a keyword.
-func getForegroundTarget(keywords []string) (string, bool) {
+// foregroundUnchanged reports whether previous's window is still in the foreground, showing the
+// same title for the same process.
+func foregroundUnchanged(previous Match) bool {
 	hwnd, _, _ := procGetForegroundWindow.Call()
-	if hwnd == 0 {
-		return "", false
+	return hwnd == previous.HWND && windowProcessID(windows.HWND(hwnd)) == previous.PID &&
+		getWindowText(windows.HWND(hwnd)) == previous.WindowTitle
+}
+
+// anyPresent reports whether any of the targets is found in the foreground window or the running
+// processes, checking each only if its stage is enabled.
+func anyPresent(targets []Target, opts Options, sc *scan) bool {
+	if opts.runs(StageForeground, StageFullscreen) {
+		if _, ok := getForegroundTarget(targets, opts, sc); ok {
+			return true
+		}
+	}
+	if opts.runs(StageProcess) {
+		if _, ok := isProcessActive(targets, opts, sc); ok {
+			return true
+		}
+	}
+	if opts.runs(StageAudio) {
+		_, ok := isAudioActive(targets, sc)
+		return ok
+	}
+	return false
+}
+
+// scan holds what one FirstActive call has already looked up, so exclusion checks and the
+// detection stages share a single foreground window and process list and resolve each path
+// only once.
+type scan struct {
+	// src is where the system is read from.
+	src source
+	// fg is the foreground window once fgRead is set; fgFound reports whether there is one.
+	fg         foregroundWindow
+	fgFound    bool
+	fgRead     bool
+	paths      map[uint32]string
+	cmdlines   map[uint32]string
+	processes  []Process
+	processErr error
+	listed     bool
+	// byPID indexes processes by PID for startedBy, built on first use.
+	byPID map[uint32]Process
+	// audio holds the PIDs with an active audio session once audioListed is set.
+	audio       map[uint32]bool
+	audioErr    error
+	audioListed bool
+}
+
+// newScan returns a scan that reads the system through Win32, using the caches in opts.
+func newScan(opts Options) *scan {
+	processTTL := opts.ProcessCacheTTL
+	if processTTL == 0 {
+		processTTL = DefaultProcessCacheTTL
+	}
+	return newSourceScan(win32Source{processTTL: processTTL, windowTTL: opts.WindowCacheTTL})
+}
+
+// newSourceScan returns a scan that reads the system from src.
+func newSourceScan(src source) *scan {
+	return &scan{src: src, paths: make(map[uint32]string), cmdlines: make(map[uint32]string)}
+}
+
+// foreground returns the foreground window, reading it at most once per scan.
+func (sc *scan) foreground() (foregroundWindow, bool) {
+	if !sc.fgRead {
+		sc.fg, sc.fgFound = sc.src.foreground()
+		sc.fgRead = true
 	}
+	return sc.fg, sc.fgFound
+}
 
-	title := getWindowText(windows.HWND(hwnd))
-	if title != "" {
-		lowerTitle := strings.ToLower(title)
-		for _, keyword := range keywords {
-			if strings.Contains(lowerTitle, keyword) {
-				return keyword, true
+// exePath returns the full executable path of pid, reading it at most once per scan.
+func (sc *scan) exePath(pid uint32) (string, bool) {
+	path, ok := sc.paths[pid]
+	if !ok {
+		path = sc.src.imagePath(pid)
+		sc.paths[pid] = path
+	}
+	return path, path != ""
+}
+
+// commandLine returns the lowercased command line of pid, reading it at most once per scan.
+// It is "" when the command line cannot be read, so "cmdline:" keywords simply do not match.
+func (sc *scan) commandLine(pid uint32) string {
+	cmdline, ok := sc.cmdlines[pid]
+	if !ok {
+		cmdline = fold(sc.src.commandLine(pid))
+		sc.cmdlines[pid] = cmdline
+	}
+	return cmdline
+}
+
+// matchProcess reports whether a target matches a process by its name (or path) or, for
+// "cmdline:" keywords, its command line.
+func (sc *scan) matchProcess(t Target, pid uint32, match func(Target) bool) bool {
+	if strings.HasPrefix(t.Keyword, CmdlinePrefix) {
+		return matchCmdline(sc.commandLine(pid), t.Keyword, t.Mode)
+	}
+	return match(t)
+}
+
+// maxAncestors bounds how far startedBy walks up the process tree.
+const maxAncestors = 16
+
+// startedBy reports whether one of pid's ancestors matches the target by exe name or command line.
+// The tree comes from the scan's process list, so it is only built once per scan.
+func (sc *scan) startedBy(pid uint32, t Target) bool {
+	if sc.byPID == nil {
+		processes, err := sc.processList()
+		if err != nil {
+			return false
+		}
+		sc.byPID = make(map[uint32]Process, len(processes))
+		for _, p := range processes {
+			sc.byPID[uint32(p.Pid())] = p
+		}
+	}
+	seen := map[uint32]bool{pid: true}
+	p, ok := sc.byPID[pid]
+	for depth := 0; ok && depth < maxAncestors; depth++ {
+		parentPID := uint32(p.PPid())
+		// A parent PID can be reused by a newer process, which would form a loop.
+		if parentPID == 0 || seen[parentPID] {
+			return false
+		}
+		seen[parentPID] = true
+		if p, ok = sc.byPID[parentPID]; ok {
+			lower := fold(p.Executable())
+			if sc.matchProcess(t, parentPID, func(t Target) bool { return matchExeName(lower, t.Keyword, t.Mode) }) {
+				return true
 			}
 		}
 	}
+	return false
+}
 
-	var pid uint32
-	_, _, err := procGetWindowThreadProcessId.Call(hwnd, uintptr(unsafe.Pointer(&pid)))
+// processList returns the running processes, listing them at most once per scan.
+func (sc *scan) processList() ([]Process, error) {
+	if !sc.listed {
+		sc.processes, sc.processErr = sc.src.processes()
+		sc.listed = true
+	}
+	return sc.processes, sc.processErr
+}
+
+var processCache struct {
+	mu        sync.Mutex
+	processes []Process
+	taken     time.Time
+}
+
+// cachedProcesses returns the process list from the last successful listing if it is younger
+// than ttl, and lists the processes again otherwise. Errors are never cached. The slice is
+// shared between callers, so it must not be modified; it is clipped so appending copies it.
+func cachedProcesses(ttl time.Duration) ([]Process, error) {
+	if ttl < 0 {
+		return processLister.Processes()
+	}
+	processCache.mu.Lock()
+	defer processCache.mu.Unlock()
+	if !processCache.taken.IsZero() && time.Since(processCache.taken) < ttl {
+		return slices.Clip(processCache.processes), nil
+	}
+	processes, err := processLister.Processes()
 	if err != nil {
-		return "", false
+		return nil, err
 	}
+	processCache.processes, processCache.taken = processes, time.Now()
+	return slices.Clip(processes), nil
+}
+
+// withProcess opens pid with the given access rights, runs fn with the handle and always closes it
+// afterwards. It returns the error without calling fn if the process could not be opened. Every
+// OpenProcess in this package goes through here so no return path can leak a handle.
+func withProcess(pid uint32, access uint32, fn func(handle uintptr)) error {
 	if pid == 0 {
-		return "", false
+		return windows.ERROR_INVALID_PARAMETER
 	}
-	handle, _, _ := procOpenProcess.Call(windows.PROCESS_QUERY_INFORMATION|windows.PROCESS_VM_READ, 0, uintptr(pid))
+	handle, _, err := procOpenProcess.Call(uintptr(access), 0, uintptr(pid))
 	if handle == 0 {
-		return "", false
+		return err
 	}
 	defer func() {
 		ret, _, err := procCloseHandle.Call(handle)
 		if ret == 0 {
-			log.Printf("Warning: Failed to close process handle %v: %v", handle, err)
+			logging.Warnf("Failed to close process handle %v: %v", handle, err)
 		}
 	}()
+	fn(handle)
+	return nil
+}
+
+// processImageAccess is the access GetModuleFileNameExW needs.
+const processImageAccess = windows.PROCESS_QUERY_INFORMATION | windows.PROCESS_VM_READ
+
+// processImagePath resolves a process's full executable path via GetModuleFileNameExW.
+func processImagePath(pid uint32) (string, bool) {
+	var path string
+	withProcess(pid, processImageAccess, func(handle uintptr) {
+		buf := make([]uint16, windows.MAX_PATH)
+		n, _, _ := procGetModuleFileNameExW.Call(handle, 0, uintptr(unsafe.Pointer(&buf[0])), windows.MAX_PATH)
+		if n > 0 {
+			path = windows.UTF16ToString(buf[:n])
+		}
+	})
+	return path, path != ""
+}
+
+// getForegroundTarget checks if the foreground app's process or title contains a keyword.
+func getForegroundTarget(targets []Target, opts Options, sc *scan) (Match, bool) {
+	fg, ok := sc.foreground()
+	if !ok {
+		return Match{}, false
+	}
+	if slices.ContainsFunc(targets, func(t Target) bool { return t.FullscreenOnly }) && !fg.displayState().Fullscreen() {
+		targets = keepTargets(targets, func(t Target) bool { return !t.FullscreenOnly })
+	}
 
-	buf := make([]uint16, windows.MAX_PATH)
-	n, _, _ := procGetModuleFileNameExW.Call(handle, 0, uintptr(unsafe.Pointer(&buf[0])), windows.MAX_PATH)
-	if n > 0 {
-		exePath := windows.UTF16ToString(buf)
-		lowerExeName := strings.ToLower(filepath.Base(exePath))
-		for _, keyword := range keywords {
-			if strings.Contains(lowerExeName, keyword) {
-				return keyword, true
+	pid := fg.PID()
+	title := fg.Title()
+	best := windowTargetIndex(opts.matchedTitle(title), fg.Class, targets, len(targets))
+
+	var exePath string
+	if best != 0 {
+		path, ok := sc.exePath(pid)
+		if !ok {
+			fg.pathUnreadable()
+		}
+		lowerExeName := fold(filepath.Base(path))
+		var lowerAUMID string
+		aumidRead := false
+		if i := firstMatching(targets, limitOf(best, targets), func(t Target) bool {
+			if !t.Scope.exes() {
+				return false
+			}
+			if strings.HasPrefix(t.Keyword, ProductPrefix) {
+				return path != "" && matchProduct(fg.versionInfo(path), t.Keyword, t.Mode)
 			}
+			if strings.HasPrefix(t.Keyword, DirPrefix) {
+				return matchDir(fold(path), t.Keyword)
+			}
+			if strings.HasPrefix(t.Keyword, AUMIDPrefix) {
+				if !aumidRead {
+					lowerAUMID = fold(fg.aumid(path))
+					aumidRead = true
+				}
+				return matchAUMID(lowerAUMID, t.Keyword, t.Mode)
+			}
+			return sc.matchProcess(t, pid, func(t Target) bool {
+				return path != "" && matchExeName(lowerExeName, t.Keyword, t.Mode)
+			}) || (t.MatchDescendants && sc.startedBy(pid, t))
+		}); i >= 0 {
+			best = i
+			exePath = path
 		}
 	}
 
-	return "", false
+	if best < 0 {
+		return Match{}, false
+	}
+	m := targets[best].match(SourceForeground)
+	m.PID, m.ExePath, m.WindowTitle, m.HWND = pid, exePath, title, fg.handle()
+	m.DisplayState = fg.displayState()
+	return m, true
+}
+
+// keepTargets returns the targets accepted by keep, in order. It returns targets itself when
+// all of them are kept.
+func keepTargets(targets []Target, keep func(Target) bool) []Target {
+	drop := func(t Target) bool { return !keep(t) }
+	if !slices.ContainsFunc(targets, drop) {
+		return targets
+	}
+	return slices.DeleteFunc(slices.Clone(targets), drop)
 }
 
-// isProcessActive checks if any running process name contains a keyword.
-func isProcessActive(keywords []string) (string, bool) {
-	processes, err := ps.Processes()
+// isProcessActive checks if any running process name (or full path, with PathMatch) contains a keyword.
+func isProcessActive(targets []Target, opts Options, sc *scan) (Match, bool) {
+	targets = keepTargets(targets, func(t Target) bool { return t.Scope.exes() && t.Scope.background() && !t.FullscreenOnly })
+	processes, err := sc.processList()
 	if err != nil {
-		return "", false
+		return Match{}, false
 	}
+	var found Match
+	best := -1
 	for _, p := range processes {
-		lowerExeName := strings.ToLower(p.Executable())
-		for _, keyword := range keywords {
-			if strings.Contains(lowerExeName, keyword) {
-				return keyword, true
+		pid := uint32(p.Pid())
+		var candidate, lower string
+		var match func(Target) bool
+		if opts.PathMatch {
+			exePath, ok := sc.exePath(pid)
+			if !ok {
+				continue
+			}
+			candidate, lower = exePath, fold(exePath)
+			match = func(t Target) bool { return matchPath(lower, t.Keyword, t.Mode) }
+		} else {
+			candidate, lower = p.Executable(), fold(p.Executable())
+			match = func(t Target) bool { return matchExeName(lower, t.Keyword, t.Mode) }
+		}
+		if i := firstMatching(targets, limitOf(best, targets), func(t Target) bool {
+			return sc.matchProcess(t, pid, match)
+		}); i >= 0 {
+			best = i
+			found = targets[i].match(SourceProcess)
+			found.PID, found.ExePath = pid, candidate
+			if best == 0 {
+				break
 			}
 		}
 	}
-	return "", false
+	return found, best >= 0
 }
 
-// isWindowActive checks if any visible window title contains a keyword.
-func isWindowActive(keywords []string) (string, bool) {
-	var foundKeyword string
-	cb := syscall.NewCallback(func(hwnd syscall.Handle, _ uintptr) uintptr {
-		isVisible, _, _ := procIsWindowVisible.Call(uintptr(hwnd))
-		if isVisible == 0 {
-			return 1 // Continue
-		}
-		title := getWindowText(windows.HWND(hwnd))
-		if title != "" {
-			lowerTitle := strings.ToLower(title)
-			for _, keyword := range keywords {
-				if strings.Contains(lowerTitle, keyword) {
-					foundKeyword = keyword
-					return 0 // Stop enumeration
-				}
+// isWindowActive checks if any visible, non-minimized window title contains a keyword.
+func isWindowActive(targets []Target, opts Options, sc *scan) (Match, bool) {
+	targets = keepTargets(targets, func(t Target) bool { return t.Scope.background() && !t.FullscreenOnly })
+	var found Match
+	best := -1
+	check := func(w Window) bool {
+		title := w.Title()
+		if i := windowTargetIndex(opts.matchedTitle(title), w.Class, targets, limitOf(best, targets)); i >= 0 {
+			best = i
+			found = targets[i].match(SourceWindow)
+			found.PID, found.WindowTitle = w.PID(), title
+			if best == 0 {
+				return false
 			}
 		}
+		return true
+	}
+	sc.src.visibleWindows(check)
+	return found, best >= 0
+}
+
+// cachedWindow is a window whose title was read when cachedWindows listed it.
+type cachedWindow struct {
+	Window
+	title string
+}
+
+func (w cachedWindow) Title() string { return w.title }
+
+var windowCache struct {
+	mu      sync.Mutex
+	entries []Window
+	taken   time.Time
+}
+
+// cachedWindows returns the visible windows and their titles, enumerating them again only
+// when the previous list is older than ttl. Windows that have since closed simply stop matching.
+// Like cachedProcesses, the returned slice is shared and must not be modified.
+func cachedWindows(ttl time.Duration) []Window {
+	windowCache.mu.Lock()
+	defer windowCache.mu.Unlock()
+	if windowCache.taken.IsZero() || time.Since(windowCache.taken) >= ttl {
+		var entries []Window
+		windowEnumerator.VisibleWindows(func(w Window) bool {
+			entries = append(entries, cachedWindow{Window: w, title: w.Title()})
+			return true
+		})
+		windowCache.entries, windowCache.taken = entries, time.Now()
+	}
+	return slices.Clip(windowCache.entries)
+}
+
+// Windows only has room for a limited number of syscall.NewCallback callbacks per process and
+// they are never freed, so EnumWindows always uses the one enumWindowsProc. Each call registers
+// its own function in enumCalls and passes the key as the lparam.
+var (
+	enumWindowsProc = syscall.NewCallback(func(hwnd syscall.Handle, key uintptr) uintptr {
+		fn, ok := enumCalls.Load(key)
+		if !ok || !fn.(func(windows.HWND) bool)(windows.HWND(hwnd)) {
+			return 0 // Stop enumeration
+		}
 		return 1 // Continue
 	})
+	enumCalls   sync.Map
+	nextEnumKey atomic.Uintptr
+)
+
+// enumVisibleWindows calls fn for each visible, non-minimized top-level window until fn returns false.
+func enumVisibleWindows(fn func(hwnd windows.HWND) bool) {
+	key := nextEnumKey.Add(1)
+	enumCalls.Store(key, func(hwnd windows.HWND) bool {
+		isVisible, _, _ := procIsWindowVisible.Call(uintptr(hwnd))
+		isMinimized, _, _ := procIsIconic.Call(uintptr(hwnd))
+		if skipWindow(isVisible != 0, isMinimized != 0) {
+			return true
+		}
+		return fn(hwnd)
+	})
+	defer enumCalls.Delete(key)
 
 	// A return of 0 here can mean the callback stopped it, which is not an error.
 	// A true failure is when ret is 0 AND the error is not nil.
-	ret, _, err := procEnumWindows.Call(cb, 0)
+	ret, _, err := procEnumWindows.Call(enumWindowsProc, key)
 	if ret == 0 && err != nil {
-		log.Printf("Warning: EnumWindows call failed with an error: %v", err)
+		logging.Warnf("EnumWindows call failed with an error: %v", err)
 	}
+}
 
-	if foundKeyword != "" {
-		return foundKeyword, true
-	}
-	return "", false
+// skipWindow reports whether a window should be ignored by isWindowActive.
+// Minimized windows can still report as visible, so both states are checked.
+func skipWindow(visible, minimized bool) bool {
+	return !visible || minimized
 }
 
-func getWindowText(hwnd windows.HWND) string {
-	length, _, _ := procGetWindowTextLen.Call(uintptr(hwnd))
-	if length == 0 {
-		return ""
+// windowProcessID returns the PID owning a window, or 0 if it cannot be determined.
+func windowProcessID(hwnd windows.HWND) uint32 {
+	var pid uint32
+	tid, _, _ := procGetWindowThreadProcessId.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&pid)))
+	if tid == 0 {
+		return 0
 	}
-	buf := make([]uint16, length+1)
-	ret, _, _ := procGetWindowTextW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&buf[0])), length+1)
+	return pid
+}
+
+// windowTargetIndex returns the index of the highest-priority target below limit that matches
+// the window's title or, for "class:" keywords, its class name. class is only called if a
+// "class:" keyword is reached. It returns -1 if none match.
+func windowTargetIndex(title string, class func() string, targets []Target, limit int) int {
+	lowerTitle := fold(title)
+	var lowerClass string
+	classRead := false
+	return firstMatching(targets, limit, func(t Target) bool {
+		if !t.Scope.titles() {
+			return false
+		}
+		if !strings.HasPrefix(t.Keyword, ClassPrefix) {
+			return title != "" && matchTitle(lowerTitle, t.Keyword, t.Mode)
+		}
+		if !classRead {
+			lowerClass = fold(class())
+			classRead = true
+		}
+		return matchClass(lowerClass, t.Keyword, t.Mode)
+	})
+}
+
+// getWindowClass returns the window's class name, or "" if it cannot be read.
+func getWindowClass(hwnd windows.HWND) string {
+	// Window class names are limited to 256 characters.
+	buf := make([]uint16, 257)
+	ret, _, _ := procGetClassNameW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
 	if ret == 0 {
 		return ""
 	}
 	return windows.UTF16ToString(buf)
 }
+
+// maxWindowText caps how far getWindowText grows its buffer for a single title, and
+// minWindowText is the smallest buffer it starts with.
+const (
+	maxWindowText = 1 << 15
+	minWindowText = 64
+)
+
+// getWindowText returns the window's title, or "" if it has none or cannot be read.
+// GetWindowTextLengthW is only a hint: the title can grow before GetWindowTextW copies it, so
+// a buffer filled to the last character is taken as truncated and the read is retried with a
+// larger one. The length can also be larger than the copied text, so only the copied characters
+// are used, or smaller, as for titles with an embedded null, so the first buffer is never tiny.
+func getWindowText(hwnd windows.HWND) string {
+	length, _, _ := procGetWindowTextLen.Call(uintptr(hwnd))
+	// One slot for the null and one spare, so a title that did not change is not mistaken
+	// for a truncated one.
+	size := max(int(length)+2, minWindowText)
+	for {
+		buf := make([]uint16, size)
+		ret, _, _ := procGetWindowTextW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&buf[0])), uintptr(size))
+		n := int(ret)
+		if n == 0 {
+			return ""
+		}
+		if !textTruncated(n, size) || size >= maxWindowText {
+			return decodeWindowText(buf[:n])
+		}
+		size *= 2
+	}
+}
+
+// textTruncated reports whether a Win32 text copy of n characters into a buffer of size
+// characters (including the terminating null) may have been cut short.
+func textTruncated(n, size int) bool {
+	return n >= size-1
+}
+
+// decodeWindowText converts the characters GetWindowTextW copied into a string. Unlike
+// windows.UTF16ToString it keeps everything after an embedded null, so the whole title can
+// be matched and is reported as it is.
+func decodeWindowText(text []uint16) string {
+	return string(utf16.Decode(text))
+}
@@ -0,0 +1,457 @@
+package watcher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// resetProcessCache empties the shared process cache before and after the test.
+func resetProcessCache(t *testing.T) {
+	empty := func() {
+		processCache.mu.Lock()
+		processCache.processes, processCache.taken = nil, time.Time{}
+		processCache.mu.Unlock()
+	}
+	empty()
+	t.Cleanup(empty)
+}
+
+func TestCachedProcesses(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		err       error
+		wait      time.Duration
+		wantCalls int
+	}{
+		{"second call within the TTL is cached", time.Hour, nil, 0, 1},
+		{"expired TTL lists again", 20 * time.Millisecond, nil, 40 * time.Millisecond, 2},
+		{"negative TTL disables the cache", -1, nil, 0, 2},
+		{"errors are not cached", time.Hour, errors.New("access denied"), 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetProcessCache(t)
+			lister := &fakeLister{processes: []ProcessState{{PID: 1, Executable: "game.exe"}}, err: tt.err}
+			useFakes(t, lister, &fakeWindows{})
+			for i := 0; i < 2; i++ {
+				processes, err := cachedProcesses(tt.ttl)
+				if (err != nil) != (tt.err != nil) || (err == nil && len(processes) != 1) {
+					t.Fatalf("cachedProcesses = %v, %v", processes, err)
+				}
+				time.Sleep(tt.wait)
+			}
+			if lister.calls != tt.wantCalls {
+				t.Fatalf("the lister was called %d times, want %d", lister.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestFirstActiveSharesProcessCache(t *testing.T) {
+	resetProcessCache(t)
+	lister := &fakeLister{processes: []ProcessState{{PID: 1, Executable: "game.exe"}}}
+	useFakes(t, lister, &fakeWindows{})
+	opts := Options{Stages: []Stage{StageProcess}, ProcessCacheTTL: 50 * time.Millisecond}
+	targets := []Target{{Keyword: "game"}, {Keyword: "other"}}
+	if m, ok := FirstActive(targets, opts); !ok || m.Keyword != "game" {
+		t.Fatalf("FirstActive = %+v, %v; want game", m, ok)
+	}
+	// A burst of calls within the TTL reuses the list, even though other.exe has started since.
+	lister.processes = []ProcessState{{PID: 2, Executable: "other.exe"}}
+	for i := 0; i < 10; i++ {
+		if m, ok := FirstActive(targets, opts); !ok || m.Keyword != "game" {
+			t.Fatalf("FirstActive within the TTL = %+v, %v; want the cached game", m, ok)
+		}
+	}
+	if lister.calls != 1 {
+		t.Fatalf("the lister was called %d times within the TTL, want 1", lister.calls)
+	}
+	time.Sleep(70 * time.Millisecond)
+	if m, ok := FirstActive(targets, opts); !ok || m.Keyword != "other" {
+		t.Fatalf("FirstActive after the TTL = %+v, %v; want other", m, ok)
+	}
+	if lister.calls != 2 {
+		t.Fatalf("the lister was called %d times, want 2 once the TTL expired", lister.calls)
+	}
+}
+
+func TestClassKeywords(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyword string
+		mode    MatchMode
+		title   string
+		class   string
+		want    bool
+	}{
+		{"class with an empty title", "class:unitywndclass", MatchContains, "", "UnityWndClass", true},
+		{"exact class", "class:unitywndclass", MatchExact, "", "UnityWndClass", true},
+		{"part of the class", "class:unity", MatchContains, "", "UnityWndClass", true},
+		{"part of the class in exact mode", "class:unity", MatchExact, "", "UnityWndClass", false},
+		{"class regexp", "class:re:^unreal", MatchContains, "", "UnrealWindow", true},
+		{"other class", "class:unitywndclass", MatchContains, "", "UnrealWindow", false},
+		{"class keyword does not match the title", "class:unitywndclass", MatchContains, "UnityWndClass", "", false},
+		{"plain keyword does not match the class", "unitywndclass", MatchContains, "", "UnityWndClass", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := []Target{{Keyword: tt.keyword, Mode: tt.mode}}
+			fg := DetectionState{Foreground: &ForegroundState{PID: 1, Title: tt.title, Class: tt.class}}
+			if _, ok := Decide(fg, targets, Options{}); ok != tt.want {
+				t.Errorf("foreground stage matched %v, want %v", ok, tt.want)
+			}
+			windows := DetectionState{Windows: []WindowInfo{{PID: 1, Title: tt.title, Class: tt.class}}}
+			if m, ok := Decide(windows, targets, Options{}); ok != tt.want || (ok && m.Source != SourceWindow) {
+				t.Errorf("window stage matched %v from %v, want %v", ok, m.Source, tt.want)
+			}
+		})
+	}
+}
+
+func TestExclusions(t *testing.T) {
+	game := ProcessState{PID: 1, Executable: "game.exe"}
+	bench := ProcessState{PID: 2, Executable: "game_bench.exe"}
+	tests := []struct {
+		name    string
+		targets []Target
+		state   DetectionState
+		want    string
+	}{
+		{"process match", []Target{{Keyword: "game.exe", Exclude: []string{"loading"}}},
+			DetectionState{Processes: []ProcessState{game}}, "game.exe"},
+		{"excluded foreground title", []Target{{Keyword: "game.exe", Exclude: []string{"loading"}}},
+			DetectionState{Foreground: &ForegroundState{PID: 1, Title: "Game - Loading...", ExePath: `C:\Games\game.exe`}, Processes: []ProcessState{game}}, ""},
+		{"excluded title in another foreground window", []Target{{Keyword: "game.exe", Exclude: []string{"loading"}}},
+			DetectionState{Foreground: &ForegroundState{PID: 9, Title: "Loading screen wallpapers - Browser"}, Processes: []ProcessState{game}}, ""},
+		{"excluded process", []Target{{Keyword: "game.exe", Exclude: []string{"game_bench"}}},
+			DetectionState{Processes: []ProcessState{game, bench}}, ""},
+		{"excluded keyword matched in exact mode", []Target{{Keyword: "game.exe", Mode: MatchExact, Exclude: []string{"loading"}}},
+			DetectionState{Foreground: &ForegroundState{PID: 1, Title: "Loading"}, Processes: []ProcessState{game}}, ""},
+		{"exclusion only vetoes its own target", []Target{{Keyword: "game.exe", Exclude: []string{"loading"}}, {Keyword: "steam"}},
+			DetectionState{Foreground: &ForegroundState{PID: 9, Title: "Loading"}, Processes: []ProcessState{game, {PID: 3, Executable: "steam.exe"}}}, "steam"},
+		{"global exclusion vetoes everything", []Target{{Keyword: "game.exe"}, {Keyword: ExcludePrefix + "loading"}},
+			DetectionState{Foreground: &ForegroundState{PID: 1, Title: "Loading"}, Processes: []ProcessState{game}}, ""},
+		{"exclusions ignore background windows", []Target{{Keyword: "game.exe", Exclude: []string{"loading"}}},
+			DetectionState{Windows: []WindowInfo{{PID: 9, Title: "Loading"}}, Processes: []ProcessState{game}}, "game.exe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m, _ := Decide(tt.state, tt.targets, Options{}); m.Keyword != tt.want {
+				t.Fatalf("Decide matched %q, want %q", m.Keyword, tt.want)
+			}
+		})
+	}
+}
+
+func TestForegroundOnly(t *testing.T) {
+	background := DetectionState{
+		Foreground: &ForegroundState{PID: 9, Title: "Discord", ExePath: `C:\Apps\Discord.exe`},
+		Processes:  []ProcessState{{PID: 1, Executable: "game.exe"}, {PID: 9, Executable: "Discord.exe"}},
+		Windows:    []WindowInfo{{PID: 1, Title: "Game"}, {PID: 9, Title: "Discord"}},
+		AudioPIDs:  []uint32{1},
+	}
+	foreground := DetectionState{
+		Foreground: &ForegroundState{PID: 1, Title: "Game", ExePath: `C:\Games\game.exe`},
+		Processes:  background.Processes,
+	}
+	tests := []struct {
+		name    string
+		target  Target
+		opts    Options
+		state   DetectionState
+		wantSrc Source
+		wantOK  bool
+	}{
+		{"background process matches by default", Target{Keyword: "game"}, Options{}, background, SourceProcess, true},
+		{"foreground scope skips the background process", Target{Keyword: "game", Scope: ScopeForeground}, Options{}, background, 0, false},
+		{"foreground scope skips background audio", Target{Keyword: AudioPrefix + "game", Scope: ScopeForeground}, Options{}, background, 0, false},
+		{"foreground scope matches the foreground exe", Target{Keyword: "game", Scope: ScopeForeground}, Options{}, foreground, SourceForeground, true},
+		{"foreground and exe scope", Target{Keyword: "game", Scope: ScopeForeground | ScopeExe}, Options{}, foreground, SourceForeground, true},
+		{"foreground and title scope skips the background window", Target{Keyword: "game", Scope: ScopeForeground | ScopeTitle}, Options{}, background, 0, false},
+		{"ForegroundOnly skips the background process", Target{Keyword: "game"}, Options{ForegroundOnly: true}, background, 0, false},
+		{"ForegroundOnly matches the foreground", Target{Keyword: "game"}, Options{ForegroundOnly: true}, foreground, SourceForeground, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := Decide(tt.state, []Target{tt.target}, tt.opts)
+			if ok != tt.wantOK || (ok && m.Source != tt.wantSrc) {
+				t.Fatalf("Decide = %v from %v, want %v from %v", ok, m.Source, tt.wantOK, tt.wantSrc)
+			}
+		})
+	}
+}
+
+func TestSkipWindow(t *testing.T) {
+	tests := []struct {
+		name               string
+		visible, minimized bool
+		want               bool
+	}{
+		{"visible", true, false, false},
+		{"minimized but reported visible", true, true, true},
+		{"hidden", false, false, true},
+		{"hidden and minimized", false, true, true},
+	}
+	for _, tt := range tests {
+		if got := skipWindow(tt.visible, tt.minimized); got != tt.want {
+			t.Errorf("%s: skipWindow(%v, %v) = %v, want %v", tt.name, tt.visible, tt.minimized, got, tt.want)
+		}
+	}
+}
+
+var procGetProcessHandleCount = kernel32.NewProc("GetProcessHandleCount")
+
+// handleCount returns the number of handles this process has open.
+func handleCount(t *testing.T) int {
+	var count uint32
+	if ret, _, err := procGetProcessHandleCount.Call(uintptr(windows.CurrentProcess()), uintptr(unsafe.Pointer(&count))); ret == 0 {
+		t.Fatalf("GetProcessHandleCount: %v", err)
+	}
+	return int(count)
+}
+
+func TestLookupsDoNotLeakHandles(t *testing.T) {
+	// A title no window has, so the foreground stage reads the foreground process's path.
+	targets := []Target{{Keyword: "no window has this title 7f3a"}}
+	tests := []struct {
+		name   string
+		lookup func()
+	}{
+		{"foreground stage", func() { FirstActive(targets, Options{Stages: []Stage{StageForeground}}) }},
+		{"foreground with descendants", func() {
+			FirstActive([]Target{{Keyword: "no process has this name 7f3a", MatchDescendants: true}}, Options{Stages: []Stage{StageForeground}})
+		}},
+		{"current foreground", func() { CurrentForeground() }},
+		{"own image path", func() { processImagePath(uint32(os.Getpid())) }},
+		{"process that does not exist", func() { processImagePath(0xFFFFFFF0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.lookup()
+			before := handleCount(t)
+			for i := 0; i < 5000; i++ {
+				tt.lookup()
+			}
+			// The runtime can open a few handles of its own meanwhile, but not one per lookup.
+			if after := handleCount(t); after > before+20 {
+				t.Fatalf("the handle count grew from %d to %d over 5000 lookups", before, after)
+			}
+		})
+	}
+}
+
+func TestLongWindowText(t *testing.T) {
+	long := strings.Repeat("Very Long Title ", 2500) + "Game"
+	tests := []struct {
+		name  string
+		title string
+	}{
+		{"longer than the first buffer", strings.Repeat("x", minWindowText+1)},
+		{"near the maximum", long[:maxWindowText-2]},
+		{"longer than 32K characters", long},
+		{"characters outside the BMP", strings.Repeat("🎮", maxWindowText/4)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeWindowText(utf16.Encode([]rune(tt.title))); got != tt.title {
+				t.Fatalf("decodeWindowText returned %d characters, want %d", len(got), len(tt.title))
+			}
+		})
+	}
+	if !matchTitle(fold(long), "game", MatchContains) {
+		t.Fatal("a keyword at the end of a very long title did not match")
+	}
+}
+
+func TestGetWindowTextLongTitle(t *testing.T) {
+	// A window's text is read with a message to the thread that owns it, so both stay here.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	long := strings.Repeat("Very Long Title ", 2500)
+	tests := []struct {
+		name  string
+		title string
+		want  string
+	}{
+		{"short", "Game", "Game"},
+		{"fills the first buffer", long[:minWindowText-1], long[:minWindowText-1]},
+		{"longer than the first buffer", long[:minWindowText*3], long[:minWindowText*3]},
+		{"near the maximum", long[:maxWindowText-1], long[:maxWindowText-1]},
+		{"cut at the maximum", long, long[:maxWindowText-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title, err := windows.UTF16PtrFromString(tt.title)
+			if err != nil {
+				t.Fatal(err)
+			}
+			class, _ := windows.UTF16PtrFromString("STATIC")
+			hwnd, _, err := procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(class)), uintptr(unsafe.Pointer(title)), 0, 0, 0, 0, 0, 0, 0, 0, 0)
+			if hwnd == 0 {
+				t.Fatalf("CreateWindowExW: %v", err)
+			}
+			defer procDestroyWindow.Call(hwnd)
+			if got := getWindowText(windows.HWND(hwnd)); got != tt.want {
+				t.Fatalf("getWindowText returned %d characters, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestWindowTextEmbeddedNull(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    []uint16
+		want    string
+		keyword string
+	}{
+		{"text after the null", utf16.Encode([]rune("Game\x00Launcher")), "Game\x00Launcher", "launcher"},
+		{"text before the null", utf16.Encode([]rune("Game\x00Launcher")), "Game\x00Launcher", "game"},
+		{"leading null", utf16.Encode([]rune("\x00Elden Ring")), "\x00Elden Ring", "elden ring"},
+		{"several nulls", utf16.Encode([]rune("A\x00\x00B")), "A\x00\x00B", "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			title := decodeWindowText(tt.text)
+			if title != tt.want {
+				t.Fatalf("decodeWindowText = %q, want %q", title, tt.want)
+			}
+			state := DetectionState{Windows: []WindowInfo{{PID: 1, Title: title}}}
+			if _, ok := Decide(state, []Target{{Keyword: tt.keyword}}, Options{}); !ok {
+				t.Fatalf("%q did not match the title %q", tt.keyword, title)
+			}
+		})
+	}
+}
+
+// TestEnumWindowsStress runs the window stage far more often than Windows has room for
+// callbacks, which only works because every call shares enumWindowsProc.
+func TestEnumWindowsStress(t *testing.T) {
+	targets := []Target{{Keyword: "no window has this title 7f3a"}}
+	opts := Options{Stages: []Stage{StageWindow}}
+	tests := []struct {
+		name       string
+		goroutines int
+		calls      int
+		run        func()
+	}{
+		{"window stage", 1, 20000, func() { isWindowActive(targets, opts, newScan(opts)) }},
+		{"stopped after the first window", 1, 20000, func() { enumVisibleWindows(func(windows.HWND) bool { return false }) }},
+		{"concurrent window stages", 4, 5000, func() { isWindowActive(targets, opts, newScan(opts)) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := tt.calls
+			if testing.Short() {
+				calls /= 10
+			}
+			var wg sync.WaitGroup
+			for g := 0; g < tt.goroutines; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < calls; i++ {
+						tt.run()
+					}
+				}()
+			}
+			wg.Wait()
+			enumCalls.Range(func(key, _ any) bool {
+				t.Errorf("enumeration %v is still registered", key)
+				return true
+			})
+		})
+	}
+}
+
+func TestTextTruncated(t *testing.T) {
+	tests := []struct {
+		n, size int
+		want    bool
+	}{
+		{0, 64, false},
+		{10, 64, false},
+		{62, 64, false},
+		{63, 64, true},
+		{64, 64, true},
+		{maxWindowText - 1, maxWindowText, true},
+	}
+	for _, tt := range tests {
+		if got := textTruncated(tt.n, tt.size); got != tt.want {
+			t.Errorf("textTruncated(%d, %d) = %v, want %v", tt.n, tt.size, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkSteadyState measures a check while a known game is running among many windows.
+// titles/op counts the window titles read, each a GetWindowText call on Windows: the window
+// cache and matching the process stage first both avoid reading them on every check.
+func BenchmarkSteadyState(b *testing.B) {
+	windows := make([]WindowInfo, 200)
+	for i := range windows {
+		windows[i] = WindowInfo{PID: uint32(1000 + i), Title: fmt.Sprintf("Document %d - Editor", i)}
+	}
+	windows = append(windows, WindowInfo{PID: 1, Title: "Game"})
+	lister := &fakeLister{processes: []ProcessState{{PID: 1, Executable: "game.exe"}}}
+	targets := []Target{{Keyword: "game"}}
+	benchmarks := []struct {
+		name string
+		opts Options
+	}{
+		{"windows listed each check", Options{Stages: []Stage{StageWindow}}},
+		{"windows cached", Options{Stages: []Stage{StageWindow}, WindowCacheTTL: time.Minute}},
+		{"process stage first", Options{Stages: []Stage{StageProcess, StageWindow}}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			windowCache.mu.Lock()
+			windowCache.entries, windowCache.taken = nil, time.Time{}
+			windowCache.mu.Unlock()
+			enumerator := &fakeWindows{windows: windows}
+			useFakes(b, lister, enumerator)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, ok := FirstActive(targets, bm.opts); !ok {
+					b.Fatal("the game was not found")
+				}
+			}
+			b.ReportMetric(float64(enumerator.titleReads)/float64(b.N), "titles/op")
+		})
+	}
+}
+
+// BenchmarkFirstActiveSince compares a full check of the foreground window with the fast path
+// taken while it has not changed. It matches the title of whatever window is in front.
+func BenchmarkFirstActiveSince(b *testing.B) {
+	fg, ok := CurrentForeground()
+	if !ok || fg.Title == "" {
+		b.Skip("no foreground window with a title")
+	}
+	targets := []Target{{Keyword: "not running"}, {Keyword: fold(fg.Title), Mode: MatchExact}}
+	opts := Options{Stages: []Stage{StageForeground}}
+	previous, ok := FirstActive(targets, opts)
+	if !ok {
+		b.Skip("the foreground window changed")
+	}
+	b.Run("FirstActive", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			FirstActive(targets, opts)
+		}
+	})
+	b.Run("FirstActiveSince", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			FirstActiveSince(previous, targets, opts)
+		}
+	})
+}
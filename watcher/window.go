@@ -0,0 +1,125 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"MSIAfterburnerScript/logging"
+)
+
+var (
+	procRegisterClassExW = user32.NewProc("RegisterClassExW")
+	procUnregisterClassW = user32.NewProc("UnregisterClassW")
+	procCreateWindowExW  = user32.NewProc("CreateWindowExW")
+	procDestroyWindow    = user32.NewProc("DestroyWindow")
+	procDefWindowProcW   = user32.NewProc("DefWindowProcW")
+)
+
+// Every message window uses the one wndProc, since syscall.NewCallback callbacks are never
+// freed and restarting a watcher must not use up the few a process can have. startMessageWindow
+// registers each window's onMessage in windowHandlers under its hwnd once CreateWindowExW has
+// returned; messages sent while the window is being created go to DefWindowProcW.
+var (
+	wndProc = syscall.NewCallback(func(hwnd windows.HWND, msg uint32, wParam, lParam uintptr) uintptr {
+		if onMessage, ok := windowHandlers.Load(uintptr(hwnd)); ok && onMessage.(func(uint32, uintptr) bool)(msg, wParam) {
+			return 0
+		}
+		ret, _, _ := procDefWindowProcW.Call(uintptr(hwnd), uintptr(msg), wParam, lParam)
+		return ret
+	})
+	windowHandlers sync.Map
+)
+
+// wndClassEx mirrors WNDCLASSEXW.
+type wndClassEx struct {
+	Size       uint32
+	Style      uint32
+	WndProc    uintptr
+	ClsExtra   int32
+	WndExtra   int32
+	Instance   windows.Handle
+	Icon       windows.Handle
+	Cursor     windows.Handle
+	Background windows.Handle
+	MenuName   *uint16
+	ClassName  *uint16
+	IconSm     windows.Handle
+}
+
+// startMessageWindow creates a hidden top-level window of the given class on a dedicated thread,
+// for messages that Windows only sends to top-level windows, and passes each message to
+// onMessage. onMessage reports whether it handled the message; otherwise DefWindowProcW runs.
+// If setup is not nil it is called with the new window, and the function it returns is called
+// before the window is destroyed. The returned channel receives an error if the window could
+// not be created, or nil once ctx is cancelled, and is then closed.
+func startMessageWindow(ctx context.Context, class string, onMessage func(msg uint32, wParam uintptr) bool, setup func(hwnd uintptr) func()) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		className, _ := windows.UTF16PtrFromString(class)
+		var instance windows.Handle
+		if err := windows.GetModuleHandleEx(0, nil, &instance); err != nil {
+			errs <- fmt.Errorf("could not get the module handle: %w", err)
+			return
+		}
+		wc := wndClassEx{WndProc: wndProc, Instance: instance, ClassName: className}
+		wc.Size = uint32(unsafe.Sizeof(wc))
+		if ret, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&wc))); ret == 0 {
+			errs <- fmt.Errorf("could not register the %s window class: %w", class, err)
+			return
+		}
+		defer procUnregisterClassW.Call(uintptr(unsafe.Pointer(className)), uintptr(instance))
+
+		// A hidden top-level window: not visible, so the window stage never sees it.
+		hwnd, _, err := procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(className)), uintptr(unsafe.Pointer(className)), 0, 0, 0, 0, 0, 0, 0, uintptr(instance), 0)
+		if hwnd == 0 {
+			errs <- fmt.Errorf("could not create the %s window: %w", class, err)
+			return
+		}
+		defer procDestroyWindow.Call(hwnd)
+		windowHandlers.Store(hwnd, onMessage)
+		defer windowHandlers.Delete(hwnd)
+		if setup != nil {
+			defer setup(hwnd)()
+		}
+
+		threadID := windows.GetCurrentThreadId()
+		stopped := make(chan struct{})
+		defer close(stopped)
+		go func() {
+			select {
+			case <-ctx.Done():
+				ret, _, err := procPostThreadMessageW.Call(uintptr(threadID), wmQuit, 0, 0)
+				if ret == 0 {
+					logging.Warnf("Failed to post WM_QUIT to the %s window: %v", class, err)
+				}
+			case <-stopped:
+			}
+		}()
+
+		var msg struct{ Hwnd, Message, WParam, LParam, Time, Pt uintptr }
+		for {
+			ret, _, err := procGetMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0)
+			switch int32(ret) {
+			case 0: // WM_QUIT
+				errs <- nil
+				return
+			case -1:
+				errs <- fmt.Errorf("GetMessageW failed: %w", err)
+				return
+			}
+			procTranslateMessage.Call(uintptr(unsafe.Pointer(&msg)))
+			procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
+		}
+	}()
+	return errs
+}